@@ -0,0 +1,229 @@
+// package comment...
+package main
+
+/*
+NOTES:
+	- Manages the shapes index behind ELASTIC_DEFAULT_INDEX, which is an
+	alias over versioned indices (see pkg/manager/elasticIndex.go).
+
+	esindex ensure
+	esindex reindex [-bucket b] [-concurrency n] [-force] [-keep]
+	esindex backfill [-bucket b] [-concurrency n]
+
+	- `ensure` puts the index template and, if the alias doesn't exist,
+	creates the first versioned index behind it. Run it once per cluster,
+	and again after changing the template.
+	- `reindex` builds a new versioned index from the meta/ objects in the
+	target bucket and swaps the alias over once it's full; searches keep
+	hitting the old index until then. Shapes the Lambda wrote meanwhile
+	were indexed into the old index, so it then re-reads the meta objects
+	modified since it started into the new one (tombstones deleted)
+	before deleting the old index, unless -keep. A failed document aborts
+	before the swap, unless -force; a failed catch-up keeps the old
+	index.
+	- `backfill` repopulates the index behind the alias in place from the
+	same meta/ objects, e.g. after Elasticsearch lost data; tombstoned
+	shapes are deleted. Interrupting it sends what's already queued.
+*/
+
+import (
+	"aws-lambda-viswal/pkg/manager"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const usage = `usage: esindex <command> [flags]
+
+commands:
+  ensure    put the index template and create the index behind the alias
+  reindex   rebuild the index from the meta/ objects and swap the alias
+  backfill  index the meta/ objects into the current index
+
+run "esindex <command> -h" for the command's flags
+`
+
+// catchUpMargin - How far before the rebuild started `reindex` catches up
+// from, for clock skew
+const catchUpMargin = time.Minute
+
+const bucketUsage = "target bucket holding meta/ (default S3_SHAPES_TARGET_BUCKET)"
+
+// storeFlags - The `-bucket` and `-concurrency` flags shared by the
+// commands reading meta/
+func storeFlags(fs *flag.FlagSet) (*string, *int) {
+	bucket := fs.String("bucket", os.Getenv("S3_SHAPES_TARGET_BUCKET"), bucketUsage)
+	concurrency := fs.Int("concurrency", 8, "meta objects downloaded at a time")
+	return bucket, concurrency
+}
+
+// logBackfill - Report a backfill's counts
+func logBackfill(index string, result manager.BackfillResult) {
+	log.WithFields(log.Fields{
+		"Index":   index,
+		"Listed":  result.Listed,
+		"Indexed": result.Indexed,
+		"Deleted": result.Deleted,
+		"Skipped": result.Skipped,
+		"Failed":  result.Failed,
+	}).Info("Backfill Complete")
+}
+
+func ensure(args []string) error {
+
+	fs := flag.NewFlagSet("ensure", flag.ExitOnError)
+	fs.Parse(args)
+
+	e := manager.NewElasticClient()
+	index, err := e.EnsureIndex()
+	if err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{"Alias": e.IndexName, "Index": index}).Info("Index Ready")
+	return nil
+}
+
+func reindex(args []string) error {
+
+	fs := flag.NewFlagSet("reindex", flag.ExitOnError)
+	bucket, concurrency := storeFlags(fs)
+	force := fs.Bool("force", false, "swap the alias even if some documents failed")
+	keep := fs.Bool("keep", false, "keep the old index after the swap")
+	fs.Parse(args)
+
+	if *bucket == "" {
+		return fmt.Errorf("no target bucket; set -bucket or S3_SHAPES_TARGET_BUCKET")
+	}
+
+	// Less a margin for the difference between our clock and S3's
+	var started = time.Now().Add(-catchUpMargin)
+
+	e := manager.NewElasticClient()
+	index, err := e.CreateVersionedIndex()
+	if err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{"Alias": e.IndexName, "Index": index}).Info("Created Index")
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	s := manager.NewS3Session()
+	result, err := manager.Backfill(ctx, s, *bucket, e.WithIndex(index), manager.BackfillOptions{
+		Concurrency: *concurrency,
+		Indexer:     manager.DefaultBulkIndexerConfig,
+	})
+	logBackfill(index, result)
+	if err != nil {
+		return fmt.Errorf("%v; the alias still points at the old index and %s is kept", err, index)
+	}
+
+	if result.Failed > 0 && !*force {
+		return fmt.Errorf("%d documents failed; the alias still points at the old index and %s is kept (rerun, or -force)", result.Failed, index)
+	}
+
+	if err = e.Refresh(index); err != nil {
+		return err
+	}
+	previous, err := e.SwapAlias(index)
+	if err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{"Alias": e.IndexName, "Index": index, "Previous": previous}).Info("Swapped Alias")
+
+	// A hand made index under the alias name went with the swap
+	var old []string
+	for _, p := range previous {
+		if p != e.IndexName {
+			old = append(old, p)
+		}
+	}
+
+	// Shapes written or tombstoned during the rebuild went to the old
+	// index (and may have been read before they changed); writes go to the
+	// new one now, so catch it up on everything modified since
+	catchUp, err := manager.Backfill(ctx, s, *bucket, e.WithIndex(index), manager.BackfillOptions{
+		Concurrency:      *concurrency,
+		DeleteTombstones: true,
+		ModifiedSince:    started,
+		Indexer:          manager.DefaultBulkIndexerConfig,
+	})
+	logBackfill(index, catchUp)
+	if err == nil && catchUp.Failed > 0 {
+		err = fmt.Errorf("%d documents failed", catchUp.Failed)
+	}
+	if err != nil {
+		return fmt.Errorf("catching up: %v; the old index %v is kept, run esindex backfill", err, old)
+	}
+
+	if *keep {
+		return nil
+	}
+	return e.DeleteIndices(old...)
+}
+
+func backfill(args []string) error {
+
+	fs := flag.NewFlagSet("backfill", flag.ExitOnError)
+	bucket, concurrency := storeFlags(fs)
+	fs.Parse(args)
+
+	if *bucket == "" {
+		return fmt.Errorf("no target bucket; set -bucket or S3_SHAPES_TARGET_BUCKET")
+	}
+
+	e := manager.NewElasticClient()
+	index, err := e.EnsureIndex()
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	result, err := manager.Backfill(ctx, manager.NewS3Session(), *bucket, &e, manager.BackfillOptions{
+		Concurrency:      *concurrency,
+		DeleteTombstones: true,
+		Indexer:          manager.DefaultBulkIndexerConfig,
+	})
+	logBackfill(index, result)
+	if err != nil {
+		return err
+	}
+	if result.Failed > 0 {
+		return fmt.Errorf("%d of %d meta objects failed", result.Failed, result.Listed)
+	}
+	return nil
+}
+
+func main() {
+
+	var commands = map[string]func([]string) error{
+		"ensure":   ensure,
+		"reindex":  reindex,
+		"backfill": backfill,
+	}
+
+	if len(os.Args) < 2 {
+		io.WriteString(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	command, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "esindex: unknown command %q\n\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+
+	if err := command(os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "esindex %s: %v\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+/*
+NOTES:
+	- Local runner, for debugging without deploying. Runs `handler` against
+	`manager.LocalStorage`; the source is either a file (-source) or a
+	sample S3 event (-event) whose buckets are directories under -root.
+	Reduced features, meta, manifests and checkpoints are written to -out.
+
+	go run ./cmd/lambda -source ./data/chicago.geojson -out ./build/out
+	go run ./cmd/lambda -event ./event.json -root ./buckets -out ./build/out
+*/
+
+import (
+	"aws-lambda-viswal/pkg/manager"
+	"context"
+	"crypto/md5"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	log "github.com/sirupsen/logrus"
+)
+
+// localSourceBucket - Bucket name given to a -source file's directory
+const localSourceBucket = "local-source"
+
+var (
+	localEvent   = flag.String("event", "", "run locally with this S3 (or SQS) event JSON file")
+	localSource  = flag.String("source", "", "run locally on this source file")
+	localRoot    = flag.String("root", ".", "directory holding one folder per bucket named in -event")
+	localOut     = flag.String("out", "./out", "directory receiving the target bucket's objects")
+	localTimeout = flag.Duration("timeout", 0, "simulated Lambda timeout per invocation, e.g. 15m (0 for none)")
+)
+
+// runLocal - Build the event, point the storage at the filesystem, and
+// run invocations until no continuation is left
+func runLocal() error {
+
+	var raw []byte
+	var err error
+
+	// Be chattier than the deployed function
+	log.SetLevel(log.InfoLevel)
+
+	local := manager.NewLocalStorage(*localRoot)
+	store = local
+
+	if s3TargetBucket == "" {
+		s3TargetBucket = "local-target"
+	}
+	local.Buckets[s3TargetBucket] = *localOut
+
+	if *localSource != "" {
+		raw, err = sourceEvent(local, *localSource)
+	} else {
+		raw, err = ioutil.ReadFile(*localEvent)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Continuations are queued and run here rather than invoking AWS
+	var pending []json.RawMessage
+	continueWith = func(event events.S3Event) error {
+		b, err := json.Marshal(event)
+		pending = append(pending, b)
+		return err
+	}
+
+	for invocation := 1; raw != nil; invocation++ {
+
+		ctx, cancel := context.Background(), func() {}
+		if *localTimeout > 0 {
+			ctx, cancel = context.WithTimeout(ctx, *localTimeout)
+		}
+
+		response, err := dispatch(ctx, raw)
+		cancel()
+
+		out, _ := json.MarshalIndent(response, "", "  ")
+		fmt.Printf("Invocation %d: %s\n", invocation, out)
+		if err != nil {
+			log.Error(err)
+		}
+
+		raw = nil
+		if len(pending) > 0 {
+			raw, pending = pending[0], pending[1:]
+		}
+	}
+
+	return nil
+}
+
+// sourceEvent - An S3 put event for a local file; its directory stands in
+// for the source bucket
+func sourceEvent(local *manager.LocalStorage, path string) ([]byte, error) {
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	local.Buckets[localSourceBucket] = filepath.Dir(abs)
+
+	var record = events.S3EventRecord{
+		EventVersion: "2.1",
+		EventSource:  "aws:s3",
+		EventTime:    time.Now().UTC(),
+		EventName:    "ObjectCreated:Put",
+		S3: events.S3Entity{
+			Bucket: events.S3Bucket{Name: localSourceBucket},
+			Object: events.S3Object{
+				Key:           url.QueryEscape(filepath.Base(abs)), // As S3 encodes it
+				URLDecodedKey: filepath.Base(abs),
+				Size:          int64(len(b)),
+				ETag:          fmt.Sprintf("%x", md5.Sum(b)),
+			},
+		},
+	}
+
+	return json.Marshal(events.S3Event{Records: []events.S3EventRecord{record}})
+}
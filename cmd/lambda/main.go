@@ -7,17 +7,28 @@ NOTES:
 */
 
 import (
+	"aws-lambda-viswal/pkg/crs"
+	"aws-lambda-viswal/pkg/formats"
 	"aws-lambda-viswal/pkg/manager"
 	"aws-lambda-viswal/pkg/viswal"
 	"context"
-	"crypto/md5"
+	"encoding/json"
+	"flag"
 	"fmt"
+	"math"
 	"os"
+	"runtime"
 	"strconv"
-	"sync"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	awslambda "github.com/aws/aws-sdk-go/service/lambda"
+	geojson "github.com/paulmach/go.geojson"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -26,74 +37,282 @@ var (
 	s3Region       string = os.Getenv("S3_SHAPES_DEFAULT_REGION")
 	s3SourceBucket string = os.Getenv("S3_SHAPES_SRC_BUCKET")
 	s3TargetBucket string = os.Getenv("S3_SHAPES_TARGET_BUCKET")
-)
+	hashProperties        = viswal.ParseHashProperties(os.Getenv("SHAPE_HASH_PROPERTIES"))
+	outputFormats         = parseOutputFormats(os.Getenv("SHAPE_OUTPUT_FORMATS"))
+	outputEncoding        = strings.ToLower(strings.TrimSpace(os.Getenv("SHAPE_OUTPUT_ENCODING")))
 
-func handler(ctx context.Context, s3Event events.S3Event) (string, error) {
+	// shapeCategory, stateProperties - Autocomplete contexts: every shape's
+	// category (default the source's file name) and the properties holding
+	// its state, first one set wins
+	shapeCategory   = strings.TrimSpace(os.Getenv("SHAPE_CATEGORY"))
+	stateProperties = parseProperties(os.Getenv("SHAPE_STATE_PROPERTIES"))
 
-	var s3 events.S3Entity
-	var s3Object manager.S3UploadObject
+	// quantizeGrid - Set in `main` from `SHAPE_DECIMALS` or `SHAPE_GRID`
+	quantizeGrid float64
 
-	// For each event, download to memory, decompose to features
-	// and upload as new source...
-	for _, record := range s3Event.Records {
+	// sourceCRS, rankCRS - Set in `main` from `SHAPE_SOURCE_CRS` (sources
+	// that don't declare a CRS) and `SHAPE_RANK_CRS` (ranking in a
+	// projected CRS rather than degrees); nil if unset
+	sourceCRS, rankCRS *crs.CRS
+)
 
-		s3 = record.S3
+// parseProperties - Comma separated list of feature properties
+func parseProperties(env string) []string {
+	var properties []string
+	for _, p := range strings.Split(env, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			properties = append(properties, p)
+		}
+	}
+	return properties
+}
 
-		// Download the object from S3...
-		b, err := s.DownloadFeatureFromS3(s3.Bucket.Name, s3.Object.Key)
+// parseOutputFormats - Comma separated data formats written per shape
+// (`geojson`, `geobuf`, `fgb`); defaults to GeoJSON alone
+func parseOutputFormats(env string) []string {
+	if strings.TrimSpace(env) == "" {
+		return []string{manager.FormatGeoJSON}
+	}
 
-		if err != nil {
-			log.WithFields(log.Fields{"Key": s3.Object.Key}).Fatal("Failed S3 Download")
+	var formats []string
+	for _, f := range strings.Split(env, ",") {
+		if f = strings.ToLower(strings.TrimSpace(f)); f != "" {
+			formats = append(formats, f)
 		}
+	}
+	return formats
+}
 
-		// Begin Feature Processing
-		fc, _ := viswal.BatchReduceGEOJSON(b)
+// parseGrid - The quantization grid from `SHAPE_DECIMALS` (decimal
+// places) or `SHAPE_GRID` (grid size, in source units); zero if neither
+// is set
+func parseGrid(decimals string, grid string) (float64, error) {
 
-		// Send results to S3 Upload Workers
-		for _, feature := range fc.Features {
+	decimals, grid = strings.TrimSpace(decimals), strings.TrimSpace(grid)
+	switch {
+	case decimals != "" && grid != "":
+		return 0, fmt.Errorf("set only one of SHAPE_DECIMALS and SHAPE_GRID")
+	case decimals != "":
+		d, err := strconv.Atoi(decimals)
+		if err != nil || d < 0 || d > 15 {
+			return 0, fmt.Errorf("SHAPE_DECIMALS: want 0 to 15, got %q", decimals)
+		}
+		return viswal.DecimalGrid(d), nil
+	case grid != "":
+		g, err := strconv.ParseFloat(grid, 64)
+		if err != nil || g <= 0 || math.IsInf(g, 0) {
+			return 0, fmt.Errorf("SHAPE_GRID: want a positive number, got %q", grid)
+		}
+		return g, nil
+	}
+	return 0, nil
+}
 
-			featureData, err := feature.MarshalJSON()
-			if err != nil {
-				log.Warn(err)
-			}
+// parseCRS - `crs.Parse`, or nil if `env` is empty
+func parseCRS(env string) (*crs.CRS, error) {
+	if strings.TrimSpace(env) == "" {
+		return nil, nil
+	}
+	return crs.Parse(env)
+}
 
-			// Get Name - Safely
-			featureName := feature.Properties["name"]
-			if featureName == nil {
-				featureName = ""
-			}
+// encodeShape - A reduced feature as a data object in `format`
+func encodeShape(format string, feature *geojson.Feature) ([]byte, error) {
+	switch format {
+	case manager.FormatGeoJSON:
+		return feature.MarshalJSON()
+	case manager.FormatGeobuf:
+		return formats.MarshalGeobufFeature(feature)
+	case manager.FormatFlatGeobuf:
+		return formats.MarshalFlatGeobuf(&geojson.FeatureCollection{Features: []*geojson.Feature{feature}}, "")
+	default:
+		return nil, fmt.Errorf("unknown output format %q", format)
+	}
+}
 
-			fmt.Printf("Reading Feature %s\n", featureName)
-			// Send object...
-			s3Object = manager.S3UploadObject{
-				Data: featureData,
-				Meta: manager.S3UploadMeta{
-					Hash: fmt.Sprintf("%x", md5.Sum(featureData)),
-					Name: featureName.(string),
-					Path: fmt.Sprintf("s3://%s/meta/%x.json", s3TargetBucket, md5.Sum(featureData)),
-				},
+// Result - Returned to the Lambda caller; `Succeeded` and `Failed` count
+// shapes, `Errors` describes every failure (shape or source level).
+// `Skipped` counts features that can never become shapes (e.g. no
+// geometry), each described in `Warnings`; retrying wouldn't help, so
+// they don't fail the invocation. `Continued` is set when the invocation
+// ran out of time and handed the rest of the event to a new invocation.
+type Result struct {
+	Sources   int      `json:"Sources"`
+	Succeeded int      `json:"Succeeded"`
+	Failed    int      `json:"Failed"`
+	Skipped   int      `json:"Skipped,omitempty"`
+	Continued bool     `json:"Continued,omitempty"`
+	Errors    []string `json:"Errors,omitempty"`
+	Warnings  []string `json:"Warnings,omitempty"`
+}
+
+func (r *Result) fail(err error) {
+	r.Failed++
+	r.Errors = append(r.Errors, err.Error())
+}
+
+func (r *Result) skip(err error) {
+	r.Skipped++
+	r.Warnings = append(r.Warnings, err.Error())
+}
+
+// nearDeadline - True once the invocation is within `deadlineMargin` of
+// its deadline; the margin has to cover draining in-flight uploads and
+// writing the checkpoint
+func nearDeadline(ctx context.Context) bool {
+	deadline, ok := ctx.Deadline()
+	return ok && time.Until(deadline) < deadlineMargin
+}
+
+// process - Process every record of `s3Event` with its own upload
+// pipeline; returns the records left unfinished at the deadline, which
+// the caller must arrange to retry (they resume from their checkpoints)
+func process(ctx context.Context, s3Event events.S3Event) (*Result, []events.S3EventRecord) {
+
+	var result Result
+	var jobs = make(map[string]*sourceJob)
+	var order []*sourceJob
+	var remaining []events.S3EventRecord
+
+	// Upload workers live for this invocation only
+	var pipeline = manager.NewUploadPipeline(store, s3TargetBucket, workerConcurrency)
+
+	// Stop near the deadline, but only once something has been done -
+	// otherwise a margin longer than the timeout would continue forever
+	var stop = func() bool {
+		if !nearDeadline(ctx) {
+			return false
+		}
+		for _, job := range order {
+			if job.next > job.start {
+				return true
 			}
+		}
+		return false
+	}
+
+	// For each event, download to memory, decompose to features
+	// and upload as new source...
+	for i, record := range s3Event.Records {
 
-			workerPool <- &s3Object
+		if stop() {
+			remaining = s3Event.Records[i:]
+			break
+		}
+
+		result.Sources++
+		job, err := newSourceJob(record)
+		if err != nil {
+			log.Error(err)
+			result.Errors = append(result.Errors, err.Error())
+			continue
+		}
+		jobs[job.source] = job
+		order = append(order, job)
+
+		if !job.run(ctx, pipeline, stop, &result) {
+			remaining = s3Event.Records[i:]
+			break
 		}
 	}
 
 	// Token Return - For "Fun"
 	fmt.Println("sent all jobs")
-	close(workerPool)
-	wg.Wait()
+	sent, uploadFailures := pipeline.Close()
+
+	for _, err := range uploadFailures {
+		log.WithFields(log.Fields{"Hash": err.Meta.Hash}).Warn(err)
+		if job, ok := jobs[err.Meta.Source]; ok {
+			job.failed[err.Meta.Hash] = true
+		}
+		result.fail(err)
+	}
+	result.Succeeded = sent - len(uploadFailures)
+
+	for _, job := range order {
+		job.finish(&result)
+	}
+
+	return &result, remaining
+}
+
+// err - Non-nil if anything in the result failed
+func (r *Result) err() error {
+	if len(r.Errors) > 0 {
+		return fmt.Errorf("%d of %d shapes failed, %d errors: %s",
+			r.Failed, r.Failed+r.Succeeded, len(r.Errors), r.Errors[0])
+	}
+	return nil
+}
+
+// handler - Entry point for S3 notifications delivered directly
+func handler(ctx context.Context, s3Event events.S3Event) (*Result, error) {
+
+	result, remaining := process(ctx, s3Event)
+
+	// Out of time - hand the unfinished records to a new invocation,
+	// which resumes from the checkpoints written above
+	if len(remaining) > 0 {
+		log.WithFields(log.Fields{"Records": len(remaining)}).Warn("Deadline Reached, Continuing In New Invocation")
+		if err := continueWith(events.S3Event{Records: remaining}); err != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("continuation: %v", err))
+		} else {
+			result.Continued = true
+		}
+	}
+
+	return result, result.err()
+}
+
+// continueWith - How `handler` hands off unfinished records; the local
+// runner replaces it to re-run them in process
+var continueWith = enqueueContinuation
 
-	return "Finished", nil
+// enqueueContinuation - Asynchronously invoke this function again with
+// `event`
+func enqueueContinuation(event events.S3Event) error {
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	svc := awslambda.New(session.Must(session.NewSession()))
+	_, err = svc.Invoke(&awslambda.InvokeInput{
+		FunctionName:   aws.String(lambdacontext.FunctionName),
+		InvocationType: aws.String(awslambda.InvocationTypeEvent),
+		Payload:        payload,
+	})
+	return err
 }
 
 var (
-	workerConcurrency, _ = strconv.Atoi(os.Getenv("S3_WORKER_CONCURRENCY"))
-	s                    = manager.NewS3Session()
-	workerPool           = make(chan *manager.S3UploadObject)
-	wg                   = sync.WaitGroup{}
+	workerConcurrency, _                     = strconv.Atoi(os.Getenv("S3_WORKER_CONCURRENCY"))
+	reduceConcurrency                        = parseConcurrency(os.Getenv("SHAPE_REDUCE_CONCURRENCY"), runtime.GOMAXPROCS(0))
+	deadlineMargin                           = parseDuration(os.Getenv("CHECKPOINT_MARGIN"), 30*time.Second)
+	store                manager.ObjectStore = manager.NewS3Session()
 )
 
-// Initialize S3 Connection && Pool to Communicate Uploads on...
+// parseConcurrency - A worker count from `env`, falling back to `def`
+func parseConcurrency(env string, def int) int {
+	n, err := strconv.Atoi(strings.TrimSpace(env))
+	if err != nil || n < 1 {
+		return def
+	}
+	return n
+}
+
+// parseDuration - Parse `env` (e.g. "30s"), falling back to `def`
+func parseDuration(env string, def time.Duration) time.Duration {
+	d, err := time.ParseDuration(env)
+	if err != nil {
+		return def
+	}
+	return d
+}
+
+// Initialize Logging; the S3 session is shared, upload workers are
+// started per invocation by `handler`
 func init() {
 	log.SetFormatter(&log.JSONFormatter{})
 	log.SetLevel(log.WarnLevel)
@@ -101,12 +320,43 @@ func init() {
 
 func main() {
 
-	//Set Feature S3 Upload Concurrency & Start N workers...
-	for i := 0; i < workerConcurrency; i++ {
-		wg.Add(1)
-		go s.StartS3UploadWorker(i, s3TargetBucket, workerPool, &wg)
+	for _, format := range outputFormats {
+		if !manager.IsDataFormat(format) {
+			log.Fatalf("SHAPE_OUTPUT_FORMATS: unknown format %q", format)
+		}
+	}
+	if _, err := formats.Compress(outputEncoding, nil); err != nil {
+		log.Fatalf("SHAPE_OUTPUT_ENCODING: %v", err)
+	}
+
+	var err error
+	if quantizeGrid, err = parseGrid(os.Getenv("SHAPE_DECIMALS"), os.Getenv("SHAPE_GRID")); err != nil {
+		log.Fatal(err)
+	}
+	if sourceCRS, err = parseCRS(os.Getenv("SHAPE_SOURCE_CRS")); err != nil {
+		log.Fatalf("SHAPE_SOURCE_CRS: %v", err)
+	}
+	if rankCRS, err = parseCRS(os.Getenv("SHAPE_RANK_CRS")); err != nil {
+		log.Fatalf("SHAPE_RANK_CRS: %v", err)
+	}
+	if max := strings.TrimSpace(os.Getenv("SHAPE_MAX_DECOMPRESSED_BYTES")); max != "" {
+		n, err := strconv.ParseInt(max, 10, 64)
+		if err != nil || n <= 0 {
+			log.Fatalf("SHAPE_MAX_DECOMPRESSED_BYTES: want a positive number of bytes, got %q", max)
+		}
+		formats.MaxDecompressedSize = n
+	}
+
+	// Run against the local filesystem instead of starting the Lambda runtime
+	flag.Parse()
+	if *localEvent != "" || *localSource != "" {
+		if err := runLocal(); err != nil {
+			log.Fatal(err)
+		}
+		return
 	}
 
-	// Make the handler available for Remote Procedure Call by AWS Lambda
-	lambda.Start(handler)
+	// Make the handler available for Remote Procedure Call by AWS Lambda;
+	// `dispatch` routes S3 and SQS events to the matching handler
+	lambda.Start(dispatch)
 }
@@ -0,0 +1,497 @@
+package main
+
+import (
+	"aws-lambda-viswal/pkg/formats"
+	"aws-lambda-viswal/pkg/manager"
+	"aws-lambda-viswal/pkg/viswal"
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-lambda-go/events"
+	geojson "github.com/paulmach/go.geojson"
+	log "github.com/sirupsen/logrus"
+)
+
+// sourceJob - One source file being processed. A job may span several
+// invocations: `run` stops when `stop` says so, and `finish` either
+// completes the source (tombstones + manifest) or saves a checkpoint.
+type sourceJob struct {
+	source   string
+	etag     string
+	features []*geojson.Feature
+	hashes   []string
+
+	manifest  *manager.SourceManifest
+	removed   []string
+	added     map[string]bool
+	completed map[string]bool
+
+	start, next   int
+	hadCheckpoint bool
+	sent          map[string]int // hash -> feature index, this invocation
+	failed        map[string]bool
+	skipped       map[string]bool
+	unhashed      map[int]error // feature index -> why it has no hash
+	interrupted   bool
+}
+
+// newSourceJob - Download and parse a source, diff it against its
+// manifest, and pick up any checkpoint left by an earlier invocation
+func newSourceJob(record events.S3EventRecord) (*sourceJob, error) {
+
+	// Keys in S3 notifications are URL encoded
+	var object = record.S3
+	var key = object.Object.URLDecodedKey
+	var job = sourceJob{
+		source:    fmt.Sprintf("%s/%s", object.Bucket.Name, key),
+		etag:      object.Object.ETag,
+		completed: make(map[string]bool),
+		sent:      make(map[string]int),
+		failed:    make(map[string]bool),
+		skipped:   make(map[string]bool),
+		unhashed:  make(map[int]error),
+	}
+
+	// Download the object from S3...
+	b, encoding, err := store.GetEncodedObject(object.Bucket.Name, key)
+	if err != nil {
+		return nil, fmt.Errorf("download %s: %v", job.source, err)
+	}
+
+	// GeoJSON, or a zipped shapefile etc. by extension
+	fc, err := formats.ReadSourceWithCRS(key, encoding, b, sourceCRS)
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %v", job.source, err)
+	}
+	job.features = fc.Features
+
+	// Previous version of this source, if any
+	previous, err := manager.ReadManifest(store, s3TargetBucket, job.source)
+	if err != nil {
+		return nil, fmt.Errorf("manifest %s: %v", job.source, err)
+	}
+
+	// Identity is the canonical geometry hash, not the marshaled bytes;
+	// it doesn't depend on `Order`, so it's computed before reducing
+	var produced []string
+	job.hashes = make([]string, len(job.features))
+	for i, feature := range job.features {
+		job.hashes[i], err = viswal.CanonicalHash(feature, hashProperties)
+		if err != nil {
+			job.unhashed[i] = err // Skipped, and reported, by `run`
+			continue
+		}
+		produced = append(produced, job.hashes[i])
+	}
+
+	// Only shapes this source didn't produce last time are uploaded
+	added, removed := previous.Diff(produced)
+	job.manifest = previous.Next(produced)
+	job.removed = removed
+	job.added = make(map[string]bool, len(added))
+	for _, h := range added {
+		job.added[h] = true
+	}
+
+	// Resume a checkpoint for this version of the source
+	checkpoint, err := manager.ReadCheckpoint(store, s3TargetBucket, job.source)
+	if err != nil {
+		return nil, fmt.Errorf("checkpoint %s: %v", job.source, err)
+	}
+	job.hadCheckpoint = checkpoint != nil
+	if checkpoint != nil && checkpoint.ETag == job.etag {
+		job.start = checkpoint.NextIndex
+		for _, h := range checkpoint.Completed {
+			job.completed[h] = true
+		}
+		for _, h := range checkpoint.Skipped {
+			job.skipped[h] = true
+		}
+		log.WithFields(log.Fields{"Source": job.source, "NextIndex": job.start}).Info("Resuming From Checkpoint")
+	}
+	job.next = job.start
+
+	log.WithFields(log.Fields{
+		"Source":  job.source,
+		"Version": job.manifest.Version,
+		"Added":   len(added),
+		"Removed": len(removed),
+	}).Info("Processed Source")
+
+	return &job, nil
+}
+
+// prepared - A feature made ready for upload by `prepare`; `err` is set
+// if it can never become a shape
+type prepared struct {
+	index  int
+	hash   string
+	upload *manager.S3UploadObject
+	err    error
+}
+
+// run - Reduce and send the job's new shapes to `pipeline`, checking
+// `stop` before each feature; returns false if it was interrupted.
+// Features are prepared by `reduceConcurrency` workers ahead of `Send`,
+// but sent (and counted) in index order so `next` stays a valid
+// checkpoint.
+func (j *sourceJob) run(ctx context.Context, pipeline *manager.UploadPipeline, stop func() bool, result *Result) bool {
+
+	var r = viswal.Reducer{Data: j.features}
+	if rankCRS != nil {
+		r.Project = rankCRS.FromWGS84
+	}
+
+	var workers = reduceConcurrency
+	if workers < 1 {
+		workers = 1
+	}
+
+	// Each feature gets its own result channel, queued in index order;
+	// `queue` bounds how far preparing runs ahead of sending
+	var queue = make(chan chan prepared, 2*workers)
+	var limit = make(chan struct{}, workers)
+	var done = make(chan struct{})
+	var wg sync.WaitGroup
+
+	go func() {
+		defer close(queue)
+		var queued = make(map[string]bool)
+
+		for index := j.next; index < len(j.features); index++ {
+
+			var out = make(chan prepared, 1)
+			if err, ok := j.unhashed[index]; ok {
+				out <- prepared{index: index, err: fmt.Errorf("hash %s feature %d: %v", j.source, index, err)}
+			} else {
+				var hash = j.hashes[index]
+				if !j.added[hash] || j.completed[hash] || queued[hash] {
+					continue // Upload duplicates once
+				}
+				queued[hash] = true
+
+				select {
+				case limit <- struct{}{}:
+				case <-done:
+					return
+				}
+				wg.Add(1)
+				go func(index int) {
+					defer wg.Done()
+					defer func() { <-limit }()
+					out <- j.prepare(&r, index, hash)
+				}(index)
+			}
+
+			select {
+			case queue <- out:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	// Don't leave the feeder or workers touching features once `run` has
+	// returned
+	defer func() {
+		close(done)
+		for range queue {
+		}
+		wg.Wait()
+	}()
+
+	for out := range queue {
+		p := <-out
+
+		if stop() {
+			j.next = p.index
+			j.interrupted = true
+			return false
+		}
+		j.next = p.index + 1
+
+		if p.err != nil {
+			j.skip(p.hash, p.index, p.err, result)
+			continue
+		}
+
+		if err := pipeline.Send(ctx, p.upload); err != nil {
+			j.fail(p.hash, p.index, fmt.Errorf("send %s: %v", p.hash, err), result)
+			continue
+		}
+		j.sent[p.hash] = p.index
+	}
+
+	j.next = len(j.features)
+	return true
+}
+
+// prepare - Quantize, reduce and encode one feature. Any error here is
+// permanent for this version of the source: a retry would fail the same
+// way, so the caller skips the feature instead of failing the source.
+func (j *sourceJob) prepare(r *viswal.Reducer, index int, hash string) prepared {
+
+	var p = prepared{index: index, hash: hash}
+
+	if err := j.quantize(index); err != nil {
+		p.err = fmt.Errorf("quantize %s: %v", hash, err)
+		return p
+	}
+
+	if err := r.ReduceFeature(index); err != nil {
+		p.err = fmt.Errorf("reduce %s: %v", hash, err)
+		return p
+	}
+
+	feature := j.features[index]
+
+	// Get Name - Safely
+	featureName, _ := feature.Properties["name"].(string)
+
+	s3Object, err := newShapeUpload(hash, featureName, feature)
+	if err != nil {
+		p.err = fmt.Errorf("marshal %s: %v", hash, err)
+		return p
+	}
+	s3Object.Meta.Source = j.source
+	s3Object.Meta.Version = j.manifest.Version
+	s3Object.Meta.Category = j.category()
+	s3Object.Meta.State = firstProperty(feature, stateProperties)
+	s3Object.Meta.Properties = manager.PropertyStrings(feature.Properties)
+
+	p.upload = s3Object
+	return p
+}
+
+// quantize - Snap a feature to `quantizeGrid` ahead of ranking, so
+// `Order` matches the vertices that are kept; the hash is still that of
+// the source geometry
+func (j *sourceJob) quantize(index int) error {
+
+	feature := j.features[index]
+	if quantizeGrid == 0 || feature.Geometry == nil {
+		return nil
+	}
+
+	geometry, err := viswal.QuantizeGeometry(feature.Geometry, quantizeGrid)
+	if err != nil {
+		return err
+	}
+	feature.Geometry = geometry
+	return nil
+}
+
+// newShapeUpload - The upload for a reduced feature in every one of
+// `outputFormats`, compressed per `outputEncoding`; the meta only lists
+// the formats when that's not GeoJSON alone
+func newShapeUpload(hash string, name string, feature *geojson.Feature) (*manager.S3UploadObject, error) {
+
+	upload := manager.NewS3UploadObject(s3TargetBucket, hash, name, nil)
+	upload.Meta.Encoding = outputEncoding
+	upload.Meta.Grid = quantizeGrid
+	upload.Meta.BBox = formats.BBox(feature.Geometry)
+	if feature.Geometry != nil {
+		upload.Meta.GeometryType = string(feature.Geometry.Type)
+	}
+
+	for _, format := range outputFormats {
+		data, err := encodeShape(format, feature)
+		if err == nil {
+			data, err = formats.Compress(outputEncoding, data)
+		}
+		if err != nil {
+			return nil, err
+		}
+		upload.SetFormat(format, data)
+	}
+
+	if len(outputFormats) == 1 && outputFormats[0] == manager.FormatGeoJSON {
+		upload.Meta.Formats = nil
+	}
+	return upload, nil
+}
+
+// category - `SHAPE_CATEGORY`, or the source's file name without its
+// extensions, e.g. "tl_2020_us_county" for ".../tl_2020_us_county.zip"
+func (j *sourceJob) category() string {
+	if shapeCategory != "" {
+		return shapeCategory
+	}
+	name := path.Base(j.source)
+	if i := strings.IndexByte(name, '.'); i > 0 {
+		name = name[:i]
+	}
+	return strings.ToLower(name)
+}
+
+// firstProperty - The first of `properties` the feature has, as a string
+func firstProperty(feature *geojson.Feature, properties []string) string {
+	for _, p := range properties {
+		switch v := feature.Properties[p].(type) {
+		case nil:
+		case string:
+			if v = strings.TrimSpace(v); v != "" {
+				return v
+			}
+		default:
+			return fmt.Sprint(v)
+		}
+	}
+	return ""
+}
+
+// skip - A feature that can never become a shape; logged and counted,
+// but the source still completes. Its hash (if any) is left out of the
+// manifest, so the next version of the source tries it again.
+func (j *sourceJob) skip(hash string, index int, err error, result *Result) {
+	log.WithFields(log.Fields{"Source": j.source, "Index": index}).Warn(err)
+	if hash != "" {
+		j.skipped[hash] = true
+	}
+	result.skip(err)
+}
+
+func (j *sourceJob) fail(hash string, index int, err error, result *Result) {
+	log.WithFields(log.Fields{"Source": j.source, "Index": index}).Warn(err)
+	j.failed[hash] = true
+	j.sent[hash] = index
+	result.fail(err)
+}
+
+// finish - Called once the pipeline is drained. A complete, clean run
+// updates the shapes' refs, retires removed shapes no other source
+// produces, records the new manifest version and clears the checkpoint; otherwise progress is checkpointed so the next invocation
+// (a continuation or a retry) picks up from the first unfinished feature.
+func (j *sourceJob) finish(result *Result) {
+
+	if j.interrupted || len(j.failed) > 0 {
+		var checkpoint = manager.Checkpoint{
+			Source:    j.source,
+			ETag:      j.etag,
+			NextIndex: j.next,
+		}
+
+		for hash, index := range j.sent {
+			if j.failed[hash] {
+				if index < checkpoint.NextIndex {
+					checkpoint.NextIndex = index
+				}
+				continue
+			}
+			j.completed[hash] = true
+		}
+		for hash := range j.completed {
+			checkpoint.Completed = append(checkpoint.Completed, hash)
+		}
+		for hash := range j.skipped {
+			checkpoint.Skipped = append(checkpoint.Skipped, hash)
+		}
+
+		if err := manager.WriteCheckpoint(store, s3TargetBucket, &checkpoint); err != nil {
+			log.WithFields(log.Fields{"Source": j.source}).Error("Failed To Write Checkpoint: ", err)
+			result.Errors = append(result.Errors, fmt.Sprintf("checkpoint %s: %v", j.source, err))
+		}
+		return
+	}
+
+	// With the new shapes in place, record this source against them before
+	// retiring the old ones, so a shape another source also produces is
+	// never tombstoned in between
+	var added []string
+	for h := range j.added {
+		if !j.skipped[h] {
+			added = append(added, h)
+		}
+	}
+	errs := eachHash(added, func(h string) error {
+		if err := manager.AddShapeRefs(store, s3TargetBucket, h, j.source); err != nil {
+			return fmt.Errorf("refs %s: %v", h, err)
+		}
+		return nil
+	})
+
+	// Tombstone a removed shape only once no source produces it; any
+	// error leaves the manifest as it was, so the retry redoes this
+	if len(errs) == 0 {
+		errs = eachHash(j.removed, func(h string) error {
+			remaining, err := manager.RemoveShapeRef(store, s3TargetBucket, h, j.source)
+			if err != nil {
+				return fmt.Errorf("refs %s: %v", h, err)
+			}
+			if remaining > 0 {
+				log.WithFields(log.Fields{"Hash": h, "Sources": remaining}).Info("Shape Still Referenced, Not Tombstoned")
+				return nil
+			}
+			if err := manager.TombstoneShape(store, s3TargetBucket, h, j.source, j.manifest.Version); err != nil {
+				return fmt.Errorf("tombstone %s: %v", h, err)
+			}
+			return nil
+		})
+	}
+	if len(errs) > 0 {
+		for _, err := range errs {
+			log.WithFields(log.Fields{"Source": j.source}).Warn(err)
+			result.Errors = append(result.Errors, err.Error())
+		}
+		return
+	}
+
+	// Skipped shapes were never uploaded; leaving them out of the manifest
+	// makes them new again next time
+	if len(j.skipped) > 0 {
+		var hashes = j.manifest.Hashes[:0]
+		for _, h := range j.manifest.Hashes {
+			if !j.skipped[h] {
+				hashes = append(hashes, h)
+			}
+		}
+		j.manifest.Hashes = hashes
+	}
+
+	if err := manager.WriteManifest(store, s3TargetBucket, j.manifest); err != nil {
+		log.WithFields(log.Fields{"Source": j.source}).Error("Failed To Write Manifest: ", err)
+		result.Errors = append(result.Errors, fmt.Sprintf("manifest %s: %v", j.source, err))
+		return
+	}
+
+	if j.hadCheckpoint {
+		if err := manager.DeleteCheckpoint(store, s3TargetBucket, j.source); err != nil {
+			log.WithFields(log.Fields{"Source": j.source}).Warn("Failed To Delete Checkpoint: ", err)
+		}
+	}
+}
+
+// eachHash - Run `fn` for every hash, `workerConcurrency` at a time;
+// returns the errors
+func eachHash(hashes []string, fn func(string) error) []error {
+
+	var workers = workerConcurrency
+	if workers < 1 {
+		workers = 1
+	}
+
+	var mu sync.Mutex
+	var errs []error
+	var wg sync.WaitGroup
+	var limit = make(chan struct{}, workers)
+
+	for _, h := range hashes {
+		limit <- struct{}{}
+		wg.Add(1)
+		go func(h string) {
+			defer wg.Done()
+			defer func() { <-limit }()
+			if err := fn(h); err != nil {
+				mu.Lock()
+				errs = append(errs, err)
+				mu.Unlock()
+			}
+		}(h)
+	}
+
+	wg.Wait()
+	return errs
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/aws/aws-lambda-go/events"
+	log "github.com/sirupsen/logrus"
+)
+
+// eventSource - Just enough of any Lambda event to tell S3 from SQS
+type eventSource struct {
+	Records []struct {
+		EventSource string `json:"eventSource"`
+	} `json:"Records"`
+}
+
+// dispatch - The function can be triggered by S3 directly or by an SQS
+// queue the bucket (or an SNS topic) notifies; route on `eventSource`
+func dispatch(ctx context.Context, raw json.RawMessage) (interface{}, error) {
+
+	var probe eventSource
+	if err := json.Unmarshal(raw, &probe); err != nil {
+		return nil, fmt.Errorf("unrecognized event: %v", err)
+	}
+
+	if len(probe.Records) > 0 && probe.Records[0].EventSource == "aws:sqs" {
+		var sqsEvent events.SQSEvent
+		if err := json.Unmarshal(raw, &sqsEvent); err != nil {
+			return nil, err
+		}
+		return sqsHandler(ctx, sqsEvent)
+	}
+
+	var s3Event events.S3Event
+	if err := json.Unmarshal(raw, &s3Event); err != nil {
+		return nil, err
+	}
+	return handler(ctx, s3Event)
+}
+
+// sqsHandler - Process each message independently and report the ones
+// that failed (or weren't reached before the deadline) as batch item
+// failures, so SQS redelivers only those. Requires
+// `ReportBatchItemFailures` on the event source mapping.
+func sqsHandler(ctx context.Context, sqsEvent events.SQSEvent) (events.SQSEventResponse, error) {
+
+	var response events.SQSEventResponse
+
+	for _, message := range sqsEvent.Records {
+
+		var fields = log.Fields{"MessageId": message.MessageId}
+
+		// Leave the rest of the batch for redelivery
+		if nearDeadline(ctx) {
+			log.WithFields(fields).Warn("Deadline Reached, Returning Message To Queue")
+			response.BatchItemFailures = append(response.BatchItemFailures, events.SQSBatchItemFailure{ItemIdentifier: message.MessageId})
+			continue
+		}
+
+		s3Event, err := unwrapS3Event(message.Body)
+		if err != nil {
+			// A malformed message will never succeed; drop it rather than
+			// redeliver it until it lands in the DLQ
+			log.WithFields(fields).Error("Unrecognized Message: ", err)
+			continue
+		}
+
+		result, remaining := process(ctx, s3Event)
+		if err = result.err(); err != nil || len(remaining) > 0 {
+			log.WithFields(fields).Warn("Message Failed: ", err)
+			response.BatchItemFailures = append(response.BatchItemFailures, events.SQSBatchItemFailure{ItemIdentifier: message.MessageId})
+		}
+	}
+
+	return response, nil
+}
+
+// snsEnvelope - The parts of an SNS notification we need; when SNS
+// delivers to SQS (without raw delivery) the S3 event is a JSON string
+// in `Message`
+type snsEnvelope struct {
+	Type    string `json:"Type"`
+	Message string `json:"Message"`
+}
+
+// unwrapS3Event - Parse an SQS message body holding either an S3 event or
+// an SNS envelope around one. S3's `s3:TestEvent` parses to no records.
+func unwrapS3Event(body string) (events.S3Event, error) {
+
+	var envelope snsEnvelope
+	var s3Event events.S3Event
+
+	if err := json.Unmarshal([]byte(body), &envelope); err != nil {
+		return s3Event, err
+	}
+
+	if envelope.Type == "Notification" {
+		body = envelope.Message
+	}
+
+	err := json.Unmarshal([]byte(body), &s3Event)
+	return s3Event, err
+}
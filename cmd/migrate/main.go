@@ -0,0 +1,193 @@
+// package comment...
+package main
+
+/*
+NOTES:
+	- Rewrites shapes written before canonical hashing. Old objects are keyed
+	by the MD5 of the marshaled feature and their meta `Path` points at
+	`meta/<md5>.json`, which was never written. For each meta object we
+	re-hash the shape (decoding its GeoJSON per the meta's `Encoding`),
+	copy every data object as stored, plus the meta with only `Hash` and
+	`Path` changed, to the keys from `manager.KeysForHash`, and (with
+	-delete) remove the old objects. Shapes whose hash is unchanged are
+	skipped.
+	- With -refs, instead records every manifest's source in the refs of
+	its shapes (`manager.RebuildShapeRefs`), for shapes written before refs
+	existed; see pkg/manager/refs.go.
+*/
+
+import (
+	"aws-lambda-viswal/pkg/formats"
+	"aws-lambda-viswal/pkg/manager"
+	"aws-lambda-viswal/pkg/viswal"
+	"encoding/json"
+	"flag"
+	"os"
+
+	geojson "github.com/paulmach/go.geojson"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	bucket         = flag.String("bucket", os.Getenv("S3_SHAPES_TARGET_BUCKET"), "target bucket holding shapes and meta/")
+	hashProperties = flag.String("hash-properties", os.Getenv("SHAPE_HASH_PROPERTIES"), "comma separated properties included in the shape hash")
+	dryRun         = flag.Bool("dry-run", false, "log the rewrites without writing anything")
+	deleteOld      = flag.Bool("delete", false, "delete the old data and meta objects after copying")
+	rebuildRefs    = flag.Bool("refs", false, "rebuild the shapes' source refs from the manifests, instead of migrating")
+)
+
+// migrateShape - Move a single shape (by its meta key) to its canonical
+// keys; returns true if anything was (or would be) rewritten
+func migrateShape(s manager.ObjectStore, metaKey string, properties []string) (bool, error) {
+
+	var meta manager.S3UploadMeta
+
+	oldHash, ok := manager.HashFromMetaKey(metaKey)
+	if !ok {
+		return false, nil
+	}
+	oldKeys := manager.KeysForHash(oldHash)
+
+	b, err := s.GetObject(*bucket, oldKeys.Meta)
+	if err != nil {
+		return false, err
+	}
+	if err = json.Unmarshal(b, &meta); err != nil {
+		return false, err
+	}
+
+	// Only GeoJSON can be hashed; shapes written without it were hashed
+	// canonically in the first place
+	var stored = meta.Formats
+	if len(stored) == 0 {
+		stored = []string{manager.FormatGeoJSON}
+	}
+	if !hasFormat(stored, manager.FormatGeoJSON) {
+		return false, nil
+	}
+
+	data, err := s.GetObject(*bucket, oldKeys.Data)
+	if err != nil {
+		return false, err
+	}
+	_, decoded, err := formats.Decompress(oldKeys.Data, meta.Encoding, data)
+	if err != nil {
+		return false, err
+	}
+
+	feature, err := geojson.UnmarshalFeature(decoded)
+	if err != nil {
+		return false, err
+	}
+
+	newHash, err := viswal.CanonicalHash(feature, properties)
+	if err != nil {
+		return false, err
+	}
+	if newHash == oldHash {
+		return false, nil // Already migrated
+	}
+
+	// Everything else in the meta still describes the (unchanged) data
+	newKeys := manager.KeysForHash(newHash)
+	meta.Hash = newHash
+	meta.Path = manager.URI(*bucket, newKeys.Meta)
+
+	log.WithFields(log.Fields{
+		"OldHash": oldHash,
+		"NewHash": newHash,
+		"Path":    meta.Path,
+	}).Info("Migrating Shape")
+
+	if *dryRun {
+		return true, nil
+	}
+
+	// Copied as stored, with the same Content-Encoding
+	for _, format := range stored {
+		if format != manager.FormatGeoJSON {
+			if data, err = s.GetObject(*bucket, oldKeys.DataKey(format)); err != nil {
+				return false, err
+			}
+		}
+		if err = s.PutEncodedObject(*bucket, newKeys.DataKey(format), data, meta.Encoding); err != nil {
+			return false, err
+		}
+	}
+
+	metaContent, _ := json.Marshal(meta)
+	if err = s.PutObject(*bucket, newKeys.Meta, metaContent); err != nil {
+		return false, err
+	}
+
+	// Only remove the old objects once the new ones are safely written
+	if *deleteOld {
+		for _, format := range stored {
+			if err = s.DeleteObject(*bucket, oldKeys.DataKey(format)); err != nil {
+				return true, err
+			}
+		}
+		if err = s.DeleteObject(*bucket, oldKeys.Meta); err != nil {
+			return true, err
+		}
+	}
+
+	return true, nil
+}
+
+// hasFormat - Whether `format` is one of `list`
+func hasFormat(list []string, format string) bool {
+	for _, f := range list {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+func main() {
+
+	flag.Parse()
+
+	if *bucket == "" {
+		log.Fatal("No target bucket; set -bucket or S3_SHAPES_TARGET_BUCKET")
+	}
+
+	var properties = viswal.ParseHashProperties(*hashProperties)
+
+	s := manager.NewS3Session()
+
+	if *rebuildRefs {
+		hashes, err := manager.RebuildShapeRefs(s, *bucket)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.WithFields(log.Fields{"Hashes": hashes}).Info("Refs Rebuilt")
+		return
+	}
+
+	keys, err := s.ListObjectKeys(*bucket, manager.MetaPrefix)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var migrated, failed int
+	for _, key := range keys {
+		ok, err := migrateShape(s, key, properties)
+		if err != nil {
+			log.WithFields(log.Fields{"Key": key}).Warn("Failed To Migrate Shape: ", err)
+			failed++
+			continue
+		}
+		if ok {
+			migrated++
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"Scanned":  len(keys),
+		"Migrated": migrated,
+		"Failed":   failed,
+		"DryRun":   *dryRun,
+	}).Info("Migration Complete")
+}
@@ -0,0 +1,463 @@
+// package comment...
+package main
+
+/*
+NOTES:
+	- Offline entry point to the reducer. Every subcommand reads a source
+	file (format by extension, see `formats.ReadSource`) or GeoJSON from
+	stdin (no argument, or `-`).
+
+	viswal rank [-o out.geojson] [-format geojson|csv|wkt|wkb|polyline|polyline-progressive|geobuf|fgb] [-precision 5] [-decimals d | -grid g] [-crs c] [-project c] [file]
+	viswal simplify (-ratio r | -points n | -area a | -zoom z [-pixels p]) [-o out.geojson] [-format ...] [-decimals d | -grid g] [-crs c] [-project c] [file]
+	viswal stats [-crs c] [file]
+	viswal split [-dir ./out] [-meta] [-bucket b] [-hash-properties name] [-crs c] [file]
+
+	- Input is reprojected to WGS84 from the CRS it declares, or `-crs`.
+	`-project` ranks or simplifies in another CRS (an EPSG code, or "utm"
+	for each shape's zone) rather than in degrees.
+*/
+
+import (
+	"aws-lambda-viswal/pkg/crs"
+	"aws-lambda-viswal/pkg/formats"
+	"aws-lambda-viswal/pkg/manager"
+	"aws-lambda-viswal/pkg/viswal"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	geojson "github.com/paulmach/go.geojson"
+)
+
+const usage = `usage: viswal <command> [flags] [file]
+
+commands:
+  rank      add the Visvalingam-Whyatt "Order" property to every feature
+  simplify  drop vertices below a ratio, point count, area or zoom level
+  stats     vertex counts and reduction curves per feature
+  split     write one file per feature, named by hash as the Lambda does
+
+run "viswal <command> -h" for the command's flags
+`
+
+// zoomCurve - Zoom levels reported by `stats`
+var zoomCurve = []float64{0, 2, 4, 6, 8, 10, 12, 14, 16, 18}
+
+// crsUsage - The `-crs` flag, on every command
+const crsUsage = "CRS of input that doesn't declare one, e.g. EPSG:26915 (default EPSG:4326)"
+
+// parseCRS - `crs.Parse`, or nil for an unset flag
+func parseCRS(name string) (*crs.CRS, error) {
+	if name == "" {
+		return nil, nil
+	}
+	return crs.Parse(name)
+}
+
+// readInput - Decode the file named by the first argument (any format
+// `formats.ReadSource` knows, by extension), or GeoJSON from stdin, in
+// WGS84; `sourceCRS` is assumed when the input doesn't declare one
+func readInput(args []string, sourceCRS string) (*geojson.FeatureCollection, error) {
+
+	source, err := parseCRS(sourceCRS)
+	if err != nil {
+		return nil, err
+	}
+
+	var b []byte
+	var name = "stdin.geojson"
+
+	if len(args) == 0 || args[0] == "-" {
+		b, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		name = args[0]
+		b, err = ioutil.ReadFile(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return formats.ReadSourceWithCRS(name, "", b, source)
+}
+
+// writeOutput - Write `b` to `path`, or stdout (newline terminated,
+// unless binary) if empty
+func writeOutput(path string, b []byte, binary bool) error {
+	if path == "" {
+		if !binary {
+			b = append(b, '\n')
+		}
+		_, err := os.Stdout.Write(b)
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0644)
+}
+
+// writeFeatures - `formats.WriteFeatures`, but at `precision` for polylines
+func writeFeatures(format string, precision int, fc *geojson.FeatureCollection) ([]byte, error) {
+	switch format {
+	case formats.OutputPolyline, formats.OutputProgressivePolyline:
+		return formats.WritePolylines(fc, precision, format == formats.OutputProgressivePolyline)
+	default:
+		return formats.WriteFeatures(format, fc)
+	}
+}
+
+// quantizeFlags - `-decimals` and `-grid`; the returned func gives the
+// grid to quantize to after parsing, zero for none
+func quantizeFlags(fs *flag.FlagSet) func() (float64, error) {
+
+	decimals := fs.Int("decimals", -1, "round coordinates to this many decimal places")
+	grid := fs.Float64("grid", 0, "snap coordinates to multiples of this grid size")
+
+	return func() (float64, error) {
+		switch {
+		case *decimals >= 0 && *grid != 0:
+			return 0, fmt.Errorf("set only one of -decimals and -grid")
+		case *decimals > 15:
+			return 0, fmt.Errorf("-decimals: want 0 to 15, got %d", *decimals)
+		case *decimals >= 0:
+			return viswal.DecimalGrid(*decimals), nil
+		case *grid < 0:
+			return 0, fmt.Errorf("-grid: want a positive number, got %g", *grid)
+		}
+		return *grid, nil
+	}
+}
+
+// quantize - Quantize every feature to `grid` (see `viswal.QuantizeGeometry`);
+// features with nothing left are dropped with a warning, as the Lambda
+// skips them, rather than failing the `command`
+func quantize(command string, fc *geojson.FeatureCollection, grid float64) error {
+	var kept = fc.Features[:0]
+	for i, feature := range fc.Features {
+		if feature.Geometry == nil {
+			kept = append(kept, feature)
+			continue
+		}
+		g, err := viswal.QuantizeGeometry(feature.Geometry, grid)
+		if errors.Is(err, viswal.ErrCollapsed) {
+			fmt.Fprintf(os.Stderr, "viswal %s: feature %d collapses when quantized, skipped\n", command, i)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("feature %d: %v", i, err)
+		}
+		feature.Geometry = g
+		kept = append(kept, feature)
+	}
+	fc.Features = kept
+	return nil
+}
+
+// reduce - `viswal.ReduceFeatureCollection`, ranking in `project` if set
+func reduce(fc *geojson.FeatureCollection, project *crs.CRS) error {
+
+	if project == nil {
+		_, err := viswal.ReduceFeatureCollection(fc)
+		return err
+	}
+
+	r := viswal.Reducer{Data: fc.Features, Project: project.FromWGS84}
+	for i, feature := range fc.Features {
+		if feature.Geometry == nil {
+			continue
+		}
+		if err := r.ReduceFeature(i); err != nil {
+			return fmt.Errorf("feature %d: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// simplifyIn - `viswal.SimplifyGeometry` on `geom` projected to
+// `project` (if set). Simplifying keeps vertices as they are, so each one
+// kept maps back to its source position exactly.
+func simplifyIn(geom *geojson.Geometry, t viswal.Threshold, project *crs.CRS) (*geojson.Geometry, error) {
+
+	if project == nil {
+		return viswal.SimplifyGeometry(geom, t)
+	}
+
+	projected, err := project.FromWGS84(geom)
+	if err != nil {
+		return nil, err
+	}
+
+	var positions [][]float64
+	crs.Transform(geom, func(p []float64) {
+		positions = append(positions, p)
+	})
+	var source = make(map[[2]float64][]float64, len(positions))
+	var i int
+	crs.Transform(projected, func(p []float64) {
+		source[[2]float64{p[0], p[1]}] = positions[i]
+		i++
+	})
+
+	simplified, err := viswal.SimplifyGeometry(projected, t)
+	if err != nil {
+		return nil, err
+	}
+	crs.Transform(simplified, func(p []float64) {
+		copy(p, source[[2]float64{p[0], p[1]}])
+	})
+	return simplified, nil
+}
+
+func rank(args []string) error {
+
+	fs := flag.NewFlagSet("rank", flag.ExitOnError)
+	out := fs.String("o", "", "output file (default stdout)")
+	format := fs.String("format", formats.OutputGeoJSON, "output format: geojson, csv, wkt, wkb (hex), polyline, polyline-progressive, geobuf or fgb")
+	precision := fs.Int("precision", formats.DefaultPolylinePrecision, "decimal places kept by the polyline formats")
+	quantizeGrid := quantizeFlags(fs)
+	sourceCRS := fs.String("crs", "", crsUsage)
+	projectCRS := fs.String("project", "", "rank in this CRS (e.g. EPSG:5070, or utm) rather than in degrees")
+	fs.Parse(args)
+
+	grid, err := quantizeGrid()
+	if err != nil {
+		return err
+	}
+	project, err := parseCRS(*projectCRS)
+	if err != nil {
+		return err
+	}
+
+	fc, err := readInput(fs.Args(), *sourceCRS)
+	if err != nil {
+		return err
+	}
+
+	// Quantize first, so `Order` ranks the vertices that are written
+	if err = quantize("rank", fc, grid); err != nil {
+		return err
+	}
+	if err = reduce(fc, project); err != nil {
+		return err
+	}
+
+	b, err := writeFeatures(*format, *precision, fc)
+	if err != nil {
+		return err
+	}
+	return writeOutput(*out, b, formats.IsBinaryOutput(*format))
+}
+
+func simplify(args []string) error {
+
+	var t viswal.Threshold
+
+	fs := flag.NewFlagSet("simplify", flag.ExitOnError)
+	out := fs.String("o", "", "output file (default stdout)")
+	format := fs.String("format", formats.OutputGeoJSON, "output format: geojson, csv, wkt, wkb (hex), polyline, polyline-progressive, geobuf or fgb")
+	precision := fs.Int("precision", formats.DefaultPolylinePrecision, "decimal places kept by the polyline formats")
+	fs.Float64Var(&t.Ratio, "ratio", 0, "keep this fraction of each path's vertices, on (0, 1]")
+	fs.IntVar(&t.Points, "points", 0, "keep this many vertices per path")
+	fs.Float64Var(&t.Area, "area", 0, "keep vertices with at least this effective area (squared degrees)")
+	zoom := fs.Float64("zoom", -1, "keep the detail visible at this web map zoom level")
+	pixels := fs.Float64("pixels", 1, "with -zoom, the smallest detail kept, in pixels")
+	quantizeGrid := quantizeFlags(fs)
+	sourceCRS := fs.String("crs", "", crsUsage)
+	projectCRS := fs.String("project", "", "simplify in this CRS (e.g. EPSG:5070, or utm); -area is then in its squared units")
+	fs.Parse(args)
+
+	grid, err := quantizeGrid()
+	if err != nil {
+		return err
+	}
+	project, err := parseCRS(*projectCRS)
+	if err != nil {
+		return err
+	}
+
+	if *zoom >= 0 {
+		if t.Area != 0 {
+			return fmt.Errorf("set only one of -area and -zoom")
+		}
+		t.Area = viswal.ZoomArea(*zoom, *pixels)
+		if project != nil {
+			t.Area *= math.Pow(project.DegreeLength(), 2)
+		}
+	}
+	if err := t.Validate(); err != nil {
+		return err
+	}
+
+	fc, err := readInput(fs.Args(), *sourceCRS)
+	if err != nil {
+		return err
+	}
+
+	for i, feature := range fc.Features {
+		if feature.Geometry == nil {
+			continue
+		}
+		if feature.Geometry, err = simplifyIn(feature.Geometry, t, project); err != nil {
+			return fmt.Errorf("feature %d: %v", i, err)
+		}
+	}
+	if err = quantize("simplify", fc, grid); err != nil {
+		return err
+	}
+
+	b, err := writeFeatures(*format, *precision, fc)
+	if err != nil {
+		return err
+	}
+	return writeOutput(*out, b, formats.IsBinaryOutput(*format))
+}
+
+// featureStats - One line of `stats` output; `Zoom` maps each zoom level
+// in `zoomCurve` to the vertices kept at that level
+type featureStats struct {
+	Index    int            `json:"index"`
+	Name     string         `json:"name,omitempty"`
+	Type     string         `json:"type"`
+	Vertices int            `json:"vertices"`
+	Zoom     map[string]int `json:"zoom"`
+}
+
+func stats(args []string) error {
+
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	pixels := fs.Float64("pixels", 1, "smallest detail kept at each zoom level, in pixels")
+	sourceCRS := fs.String("crs", "", crsUsage)
+	fs.Parse(args)
+
+	fc, err := readInput(fs.Args(), *sourceCRS)
+	if err != nil {
+		return err
+	}
+
+	var total = featureStats{Index: -1, Type: "Total", Zoom: make(map[string]int)}
+	var encoder = json.NewEncoder(os.Stdout)
+
+	for i, feature := range fc.Features {
+		if feature.Geometry == nil {
+			continue
+		}
+
+		s := featureStats{
+			Index:    i,
+			Type:     string(feature.Geometry.Type),
+			Vertices: viswal.CountVertices(feature.Geometry),
+			Zoom:     make(map[string]int, len(zoomCurve)),
+		}
+		s.Name, _ = feature.Properties["name"].(string)
+
+		for _, z := range zoomCurve {
+			simplified, err := viswal.SimplifyGeometry(feature.Geometry, viswal.Threshold{Area: viswal.ZoomArea(z, *pixels)})
+			if err != nil {
+				return fmt.Errorf("feature %d: %v", i, err)
+			}
+			key := fmt.Sprint(z)
+			s.Zoom[key] = viswal.CountVertices(simplified)
+			total.Zoom[key] += s.Zoom[key]
+		}
+		total.Vertices += s.Vertices
+
+		if err = encoder.Encode(s); err != nil {
+			return err
+		}
+	}
+
+	return encoder.Encode(total)
+}
+
+func split(args []string) error {
+
+	fs := flag.NewFlagSet("split", flag.ExitOnError)
+	dir := fs.String("dir", ".", "output directory")
+	meta := fs.Bool("meta", false, "also write meta/<hash>_meta.json")
+	bucket := fs.String("bucket", os.Getenv("S3_SHAPES_TARGET_BUCKET"), "bucket named in the meta Path")
+	hashProperties := fs.String("hash-properties", os.Getenv("SHAPE_HASH_PROPERTIES"), "comma separated properties included in the hash (default $SHAPE_HASH_PROPERTIES, else "+strings.Join(viswal.DefaultHashProperties, ",")+")")
+	sourceCRS := fs.String("crs", "", crsUsage)
+	fs.Parse(args)
+
+	fc, err := readInput(fs.Args(), *sourceCRS)
+	if err != nil {
+		return err
+	}
+	if _, err = viswal.ReduceFeatureCollection(fc); err != nil {
+		return err
+	}
+
+	var properties = viswal.ParseHashProperties(*hashProperties)
+	var store = manager.NewLocalStorage(*dir)
+	store.Buckets[*bucket] = *dir
+
+	for i, feature := range fc.Features {
+
+		// Nothing to write for a feature without geometry
+		if feature.Geometry == nil {
+			fmt.Fprintf(os.Stderr, "viswal split: feature %d has no geometry, skipped\n", i)
+			continue
+		}
+
+		hash, err := viswal.CanonicalHash(feature, properties)
+		if err != nil {
+			return fmt.Errorf("feature %d: %v", i, err)
+		}
+
+		data, err := feature.MarshalJSON()
+		if err != nil {
+			return fmt.Errorf("feature %d: %v", i, err)
+		}
+
+		name, _ := feature.Properties["name"].(string)
+		upload := manager.NewS3UploadObject(*bucket, hash, name, data)
+		upload.Meta.BBox = formats.BBox(feature.Geometry)
+		upload.Meta.Properties = manager.PropertyStrings(feature.Properties)
+		keys := manager.KeysForHash(hash)
+
+		if err = store.PutObject(*bucket, keys.Data, upload.Data); err != nil {
+			return err
+		}
+
+		if *meta {
+			metaContent, _ := json.Marshal(upload.Meta)
+			if err = store.PutObject(*bucket, keys.Meta, metaContent); err != nil {
+				return err
+			}
+		}
+
+		fmt.Println(filepath.Join(*dir, keys.Data))
+	}
+
+	return nil
+}
+
+func main() {
+
+	var commands = map[string]func([]string) error{
+		"rank":     rank,
+		"simplify": simplify,
+		"stats":    stats,
+		"split":    split,
+	}
+
+	if len(os.Args) < 2 {
+		io.WriteString(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	command, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "viswal: unknown command %q\n\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+
+	if err := command(os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "viswal %s: %v\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	manager "aws-lambda-viswal/pkg/manager"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// QueryMSG - An autocomplete request, as JSON from the frontend or as
+// query parameters (`q`, `size`, `fuzzy`, `category`, `state`)
+type QueryMSG struct {
+	QueryString string   `json:"queryString"`
+	Size        int      `json:"size"`
+	Fuzzy       *bool    `json:"fuzzy"` // Default true
+	Category    []string `json:"category"`
+	State       []string `json:"state"`
+}
+
+// ShapeResult - A shape in autocomplete and search responses
+type ShapeResult struct {
+	Hash         string            `json:"Hash"`
+	Name         string            `json:"Name"`
+	GeometryType string            `json:"GeometryType"`
+	BBox         []float64         `json:"BBox"`
+	Category     string            `json:"Category"`
+	State        string            `json:"State"`
+	Properties   map[string]string `json:"Properties"`
+	URL          string            `json:"URL"`
+}
+
+// AutocompleteResponse - `Results` is always a list, best match first
+type AutocompleteResponse struct {
+	Query   string        `json:"Query"`
+	Results []ShapeResult `json:"Results"`
+}
+
+// errorResponse - Body of every JSON error
+type errorResponse struct {
+	Error string `json:"Error"`
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+// newShapeResult - The public view of a shape's meta
+func (s *server) newShapeResult(meta *manager.S3UploadMeta) ShapeResult {
+	var properties = meta.Properties
+	if properties == nil {
+		properties = map[string]string{}
+	}
+	return ShapeResult{
+		Hash:         meta.Hash,
+		Name:         meta.Name,
+		GeometryType: meta.GeometryType,
+		BBox:         meta.BBox,
+		Category:     meta.Category,
+		State:        meta.State,
+		Properties:   properties,
+		URL:          s.shapeURL(meta),
+	}
+}
+
+// shapeURL - URL of the shape's data object, under `ShapesBaseURL` (e.g. a
+// CDN in front of the target bucket) or S3; GeoJSON, unless it was only
+// written in other formats
+func (s *server) shapeURL(meta *manager.S3UploadMeta) string {
+
+	keys := manager.KeysForHash(meta.Hash)
+	key := keys.Data
+	if len(meta.Formats) > 0 {
+		key = keys.DataKey(meta.Formats[0])
+		for _, format := range meta.Formats {
+			if format == manager.FormatGeoJSON {
+				key = keys.Data
+			}
+		}
+	}
+
+	if s.config.ShapesBaseURL != "" {
+		return s.config.ShapesBaseURL + "/" + key
+	}
+
+	// `Path` is s3://bucket/meta/..., see `manager.NewS3UploadObject`
+	bucket := strings.SplitN(strings.TrimPrefix(meta.Path, "s3://"), "/", 2)[0]
+	return fmt.Sprintf("https://%s.s3.amazonaws.com/%s", bucket, key)
+}
+
+// parseQueryMSG - From a GET's query string or a POST's JSON body
+func parseQueryMSG(r *http.Request) (QueryMSG, error) {
+
+	var q QueryMSG
+	if r.Method == http.MethodPost {
+		if err := json.NewDecoder(io.LimitReader(r.Body, 64<<10)).Decode(&q); err != nil {
+			return q, fmt.Errorf("malformed query: %v", err)
+		}
+		return q, nil
+	}
+
+	params := r.URL.Query()
+	q.QueryString = params.Get("q")
+	q.Category = splitParams(params["category"])
+	q.State = splitParams(params["state"])
+
+	if v := params.Get("size"); v != "" {
+		size, err := strconv.Atoi(v)
+		if err != nil {
+			return q, fmt.Errorf("size %q is not a number", v)
+		}
+		q.Size = size
+	}
+	if v := params.Get("fuzzy"); v != "" {
+		fuzzy, err := strconv.ParseBool(v)
+		if err != nil {
+			return q, fmt.Errorf("fuzzy %q is not true or false", v)
+		}
+		q.Fuzzy = &fuzzy
+	}
+	return q, nil
+}
+
+// splitParams - Repeated and/or comma separated values
+func splitParams(values []string) []string {
+	var split []string
+	for _, v := range values {
+		split = append(split, splitList(v)...)
+	}
+	return split
+}
+
+// autocomplete - Complete a prefix on shape names; see QueryMSG
+func (s *server) autocomplete(w http.ResponseWriter, r *http.Request) {
+
+	q, err := parseQueryMSG(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{err.Error()})
+		return
+	}
+
+	entries, err := s.search.Suggest(r.Context(), manager.SuggestQuery{
+		Prefix:     q.QueryString,
+		Size:       q.Size,
+		Fuzzy:      q.Fuzzy == nil || *q.Fuzzy,
+		Categories: q.Category,
+		States:     q.State,
+	})
+	if err != nil {
+		log.WithFields(log.Fields{"Query": q.QueryString}).Error(err)
+		writeJSON(w, http.StatusBadGateway, errorResponse{"search backend unavailable"})
+		return
+	}
+
+	s.stats.observeAutocomplete(len(entries))
+
+	var response = AutocompleteResponse{Query: q.QueryString, Results: make([]ShapeResult, 0, len(entries))}
+	for _, entry := range entries {
+		response.Results = append(response.Results, s.newShapeResult(entry))
+	}
+	writeJSON(w, http.StatusOK, response)
+}
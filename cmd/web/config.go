@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+/*
+NOTES:
+	- Settings are layered: defaults, then the JSON file named by -config
+	(or `WEB_CONFIG`), then environment variables, then flags; each layer
+	only overrides what it sets.
+	- Durations are Go durations ("15s", "1m30s") in all three.
+	- See docs/web.md for every setting.
+*/
+
+// duration - `time.Duration` as a JSON string and a flag
+type duration time.Duration
+
+func (d *duration) Set(s string) error {
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = duration(v)
+	return nil
+}
+
+func (d duration) String() string {
+	return time.Duration(d).String()
+}
+
+func (d *duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string, e.g. \"15s\": %v", err)
+	}
+	return d.Set(s)
+}
+
+// config - Everything the web service reads at startup
+type config struct {
+	Addr              string   `json:"Addr"`
+	ReadTimeout       duration `json:"ReadTimeout"`
+	ReadHeaderTimeout duration `json:"ReadHeaderTimeout"`
+	WriteTimeout      duration `json:"WriteTimeout"`
+	IdleTimeout       duration `json:"IdleTimeout"`
+	ShutdownTimeout   duration `json:"ShutdownTimeout"`
+	ReadyTimeout      duration `json:"ReadyTimeout"`
+
+	Bucket string `json:"Bucket"`
+
+	ElasticHost   string `json:"ElasticHost"`
+	ElasticIndex  string `json:"ElasticIndex"`
+	ShapesBaseURL string `json:"ShapesBaseURL"`
+
+	SNSCertHostPattern string `json:"SNSCertHostPattern"`
+	SNSTopicARNs       string `json:"SNSTopicARNs"`
+}
+
+// defaultConfig - Listen on :8081, against a local ElasticSearch
+var defaultConfig = config{
+	Addr:               ":8081",
+	ReadTimeout:        duration(15 * time.Second),
+	ReadHeaderTimeout:  duration(5 * time.Second),
+	WriteTimeout:       duration(30 * time.Second),
+	IdleTimeout:        duration(60 * time.Second),
+	ShutdownTimeout:    duration(20 * time.Second),
+	ReadyTimeout:       duration(2 * time.Second),
+	ElasticHost:        "http://127.0.0.1:9200",
+	ElasticIndex:       "shapes",
+	SNSCertHostPattern: defaultSNSCertHostPattern,
+}
+
+// bind - Register a flag for every setting on `fs`, writing to `c`
+func (c *config) bind(fs *flag.FlagSet) {
+	fs.StringVar(&c.Addr, "addr", c.Addr, "Listen address")
+	fs.Var(&c.ReadTimeout, "read-timeout", "Max time to read a request, body included")
+	fs.Var(&c.ReadHeaderTimeout, "read-header-timeout", "Max time to read a request's headers")
+	fs.Var(&c.WriteTimeout, "write-timeout", "Max time to write a response")
+	fs.Var(&c.IdleTimeout, "idle-timeout", "Max time to keep an idle connection open")
+	fs.Var(&c.ShutdownTimeout, "shutdown-timeout", "Max time to drain requests on SIGTERM")
+	fs.Var(&c.ReadyTimeout, "ready-timeout", "Max time for each /readyz check")
+	fs.StringVar(&c.Bucket, "bucket", c.Bucket, "Target bucket /readyz checks; storage is unchecked if empty")
+	fs.StringVar(&c.ElasticHost, "elastic-host", c.ElasticHost, "ElasticSearch URL")
+	fs.StringVar(&c.ElasticIndex, "elastic-index", c.ElasticIndex, "Index (or alias) to search and index into")
+	fs.StringVar(&c.ShapesBaseURL, "shapes-base-url", c.ShapesBaseURL, "Where shape data objects are served from; their S3 URL if empty")
+	fs.StringVar(&c.SNSCertHostPattern, "sns-cert-host-pattern", c.SNSCertHostPattern, "Regular expression matching the whole host SNS certificates and subscribe URLs may come from")
+	fs.StringVar(&c.SNSTopicARNs, "sns-topic-arns", c.SNSTopicARNs, "Comma separated SNS topics to accept; any if empty")
+}
+
+// env - Environment variable for each flag
+var env = map[string]string{
+	"addr":                  "WEB_ADDR",
+	"read-timeout":          "WEB_READ_TIMEOUT",
+	"read-header-timeout":   "WEB_READ_HEADER_TIMEOUT",
+	"write-timeout":         "WEB_WRITE_TIMEOUT",
+	"idle-timeout":          "WEB_IDLE_TIMEOUT",
+	"shutdown-timeout":      "WEB_SHUTDOWN_TIMEOUT",
+	"ready-timeout":         "WEB_READY_TIMEOUT",
+	"bucket":                "S3_SHAPES_TARGET_BUCKET",
+	"elastic-host":          "ELASTIC_HOST",
+	"elastic-index":         "ELASTIC_DEFAULT_INDEX",
+	"shapes-base-url":       "SHAPES_BASE_URL",
+	"sns-cert-host-pattern": "SNS_CERT_HOST_PATTERN",
+	"sns-topic-arns":        "SNS_TOPIC_ARNS",
+}
+
+// loadConfig - Layer defaults, file, environment and `args`; see NOTES
+func loadConfig(args []string) (*config, error) {
+
+	// Parse the flags on their own first, to find the file and to know
+	// which ones were set
+	var flags = defaultConfig
+	var path = os.Getenv("WEB_CONFIG")
+
+	fs := flag.NewFlagSet("web", flag.ContinueOnError)
+	fs.StringVar(&path, "config", path, "JSON config file")
+	flags.bind(fs)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	var c = defaultConfig
+	if path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err = json.Unmarshal(b, &c); err != nil {
+			return nil, fmt.Errorf("config %s: %v", path, err)
+		}
+	}
+
+	// Environment and flags go through the same setters
+	layer := flag.NewFlagSet("web", flag.ContinueOnError)
+	c.bind(layer)
+	for name, key := range env {
+		if v, ok := os.LookupEnv(key); ok {
+			if err := layer.Set(name, v); err != nil {
+				return nil, fmt.Errorf("%s: %v", key, err)
+			}
+		}
+	}
+
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name != "config" && err == nil {
+			err = layer.Set(f.Name, f.Value.String())
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &c, c.validate()
+}
+
+func (c *config) validate() error {
+	if strings.TrimSpace(c.Addr) == "" {
+		return fmt.Errorf("config: Addr is required")
+	}
+	if c.ElasticHost == "" || c.ElasticIndex == "" {
+		return fmt.Errorf("config: ElasticHost and ElasticIndex are required")
+	}
+	for name, d := range map[string]duration{
+		"ReadTimeout":       c.ReadTimeout,
+		"ReadHeaderTimeout": c.ReadHeaderTimeout,
+		"WriteTimeout":      c.WriteTimeout,
+		"IdleTimeout":       c.IdleTimeout,
+		"ShutdownTimeout":   c.ShutdownTimeout,
+		"ReadyTimeout":      c.ReadyTimeout,
+	} {
+		if d <= 0 {
+			return fmt.Errorf("config: %s must be positive", name)
+		}
+	}
+	if _, err := compileHostPattern(c.SNSCertHostPattern); err != nil {
+		return fmt.Errorf("config: SNSCertHostPattern: %v", err)
+	}
+	c.ShapesBaseURL = strings.TrimSuffix(c.ShapesBaseURL, "/")
+	return nil
+}
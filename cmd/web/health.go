@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+/*
+NOTES:
+	- `/healthz` only says the process is serving; a load balancer that
+	restarts on it shouldn't be restarting for a backend outage.
+	- `/readyz` checks the target bucket (if `Bucket` is set) and the
+	search index, concurrently, each within `ReadyTimeout`. Any failure is
+	a 503 so the load balancer stops routing here until it recovers.
+*/
+
+// readyResponse - Body of `/readyz`; `Checks` is "ok" or the error, by
+// backend
+type readyResponse struct {
+	Status string            `json:"Status"` // ready or unavailable
+	Checks map[string]string `json:"Checks"`
+}
+
+// readinessCheck - One backend `/readyz` must reach
+type readinessCheck struct {
+	name string
+	ping func(ctx context.Context) error
+}
+
+func (s *server) readinessChecks() []readinessCheck {
+	var checks = []readinessCheck{{"search", s.search.Ping}}
+	if s.config.Bucket != "" {
+		checks = append(checks, readinessCheck{"storage", func(ctx context.Context) error {
+			return s.s3.Ping(ctx, s.config.Bucket)
+		}})
+	}
+	return checks
+}
+
+// healthz - Alive, whatever the backends' state
+func (s *server) healthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte("ok\n"))
+}
+
+// readyz - See NOTES
+func (s *server) readyz(w http.ResponseWriter, r *http.Request) {
+
+	var checks = s.readinessChecks()
+	var errs = make([]error, len(checks))
+
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func(i int, check readinessCheck) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(r.Context(), time.Duration(s.config.ReadyTimeout))
+			defer cancel()
+			errs[i] = check.ping(ctx)
+		}(i, check)
+	}
+	wg.Wait()
+
+	var response = readyResponse{Status: "ready", Checks: make(map[string]string, len(checks))}
+	var code = http.StatusOK
+	for i, check := range checks {
+		response.Checks[check.name] = "ok"
+		if errs[i] != nil {
+			response.Checks[check.name] = errs[i].Error()
+			response.Status, code = "unavailable", http.StatusServiceUnavailable
+		}
+	}
+	writeJSON(w, code, response)
+}
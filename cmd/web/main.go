@@ -4,137 +4,222 @@ import (
 	manager "aws-lambda-viswal/pkg/manager"
 	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
-	"html/template"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 
 	"github.com/aws/aws-lambda-go/events"
-	elastic "github.com/olivere/elastic/v7"
 )
 
-var tpl *template.Template
+// subscription - Handler for the SNS subscription endpoint. Every
+// message must carry a valid SNS signature (see sns.go); subscriptions
+// are confirmed, notifications indexed
+func (s *server) subscription(w http.ResponseWriter, req *http.Request) {
 
-var (
-	elasticDefaultIndex string = os.Getenv("ELASTIC_DEFAULT_INDEX")
-	elasticHost         string = os.Getenv("ELASTIC_HOST")
-)
+	var msg snsMessage
+	content, err := ioutil.ReadAll(io.LimitReader(req.Body, 1<<20))
+	if err == nil {
+		err = json.Unmarshal(content, &msg)
+	}
+	if err != nil {
+		http.Error(w, "malformed SNS message", http.StatusBadRequest)
+		return
+	}
 
-// QueryMSG  - From frontend...
-type QueryMSG struct {
-	QueryString string `json:"queryString"`
-}
+	// The header isn't signed; it must agree with the signed `Type`
+	if msgType := req.Header.Get("X-Amz-Sns-Message-Type"); msgType != "" && msgType != msg.Type {
+		http.Error(w, "message type mismatch", http.StatusBadRequest)
+		return
+	}
 
-// Index - Handler for Index Page...
-func index(w http.ResponseWriter, req *http.Request) {
-	tpl.ExecuteTemplate(w, "index.html", nil)
-}
+	if err = s.sns.Verify(&msg); err != nil {
+		log.WithFields(log.Fields{"TopicArn": msg.TopicArn, "Type": msg.Type}).Warn(err)
+		http.Error(w, "signature verification failed", http.StatusForbidden)
+		return
+	}
 
-// _subscription - Handler for Subscribed Endpoint
-// a lot of notes about handling SNS vs S3 forwarded messages...
-// IDK If I need to verify anything here??
-func _subscription(w http.ResponseWriter, req *http.Request) {
+	switch msg.Type {
+	case snsSubscriptionConfirmation:
+		if err = s.sns.Confirm(&msg); err != nil {
+			log.WithFields(log.Fields{"TopicArn": msg.TopicArn}).Error(err)
+			http.Error(w, "subscription confirmation failed", http.StatusBadGateway)
+			return
+		}
+		log.WithFields(log.Fields{"TopicArn": msg.TopicArn}).Info("Confirmed SNS Subscription")
+		w.WriteHeader(http.StatusOK)
 
-	// Check Header "X-Amz-Sns-Message-Type" for Action...
-	msgType := req.Header.Get("X-Amz-Sns-Message-Type")
-	if msgType == "Notification" {
-		_handleS3Event(w, req)
-	}
+	case snsUnsubscribeConfirmation:
+		// Nothing to undo; resubscribing is a deliberate, manual step
+		log.WithFields(log.Fields{"TopicArn": msg.TopicArn}).Warn("SNS Subscription Removed")
+		w.WriteHeader(http.StatusOK)
 
-	// Only expect Notifications; If Request != Notification
-	// Either a subscription message or a mistake...
-	w.WriteHeader(http.StatusBadRequest) // TODO - Update this Error...
+	case snsNotification:
+		s.handleS3Event(w, req, msg.Message)
+	}
 }
 
-// get location of the metafile and insert into elastic - Treat as S3 Event
-func _handleS3Event(w http.ResponseWriter, req *http.Request) {
+// recordStatus - Outcome of one S3 record of a notification
+type recordStatus struct {
+	Key    string `json:"Key"`
+	Status string `json:"Status"` // indexed, deleted, skipped or failed
+	Error  string `json:"Error,omitempty"`
+}
 
-	var events events.S3Event
-	var e manager.S3UploadMeta
+// handleS3Event - `message` is the S3 event carried in an SNS
+// notification's `Message`. Each meta object is downloaded and indexed
+// (or deleted, for tombstones and removed meta objects); anything else in
+// the bucket is skipped. Responds once: 200, or 500 so SNS redelivers if
+// any record failed, with each record's status.
+func (s *server) handleS3Event(w http.ResponseWriter, req *http.Request, message string) {
+
+	var s3Event events.S3Event
+	if err := json.Unmarshal([]byte(message), &s3Event); err != nil {
+		log.Warn("Malformed S3 Event ", err)
+		http.Error(w, "malformed S3 event", http.StatusBadRequest)
+		return
+	}
 
-	// Unmarshall S3 Event...
-	content, _ := ioutil.ReadAll(req.Body)
-	_ = json.Unmarshal(content, &events)
+	var statuses = make([]recordStatus, 0, len(s3Event.Records))
+	var code = http.StatusOK
 
-	// Get Contents of S3 Meta, Download the Meta File, & Write to Elastic
-	for _, record := range events.Records {
-		b, _ := s3m.DownloadFeatureFromS3(record.S3.Bucket.Name, record.S3.Object.Key)
-		json.Unmarshal(b, &e)
-		log.Info("%+v\n", e)
+	var items = make([]*manager.BulkItem, 0, len(s3Event.Records))
 
-		esm.PrepareInsert(&e)
-		esm.ExecuteRequest()
-		w.WriteHeader(http.StatusOK)
+	for _, record := range s3Event.Records {
+		status, item := s.indexRecord(record)
+		statuses = append(statuses, status)
+		items = append(items, item)
 	}
 
-}
+	// Send this notification's actions now rather than on the interval
+	s.indexer.Flush()
 
-func _autocomplete(w http.ResponseWriter, r *http.Request) {
+	for i, item := range items {
+		if item == nil {
+			continue
+		}
+		if err := item.Wait(req.Context()); err != nil {
+			statuses[i].Status, statuses[i].Error = "failed", err.Error()
+		}
+	}
 
-	var (
-		q       QueryMSG
-		entry   manager.S3UploadMeta
-		err     error
-		entries = []string{}
-	)
+	s.stats.observeIngest(statuses)
 
-	decoder := json.NewDecoder(r.Body)
-	_ = decoder.Decode(&q)
+	for _, status := range statuses {
+		if status.Status == "failed" {
+			code = http.StatusInternalServerError
+			log.WithFields(log.Fields{"Key": status.Key}).Error(status.Error)
+		}
+	}
 
-	// TODO - Check if QueryString is null, Shouldn't matter,
-	// not even worth Info Logging, really...
-	ctx := context.Background()
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string][]recordStatus{"Records": statuses})
+}
 
-	searchSuggester := elastic.
-		NewCompletionSuggester(elasticDefaultIndex).
-		Text(q.QueryString).
-		Field("Name").
-		Size(5)
+// indexRecord - Queue one S3 record's action on the index; the item is
+// nil if nothing was queued (skipped, or failed before reaching it)
+func (s *server) indexRecord(record events.S3EventRecord) (recordStatus, *manager.BulkItem) {
 
-	searchSource := elastic.NewSearchSource().
-		Suggester(searchSuggester)
+	var key = record.S3.Object.URLDecodedKey
+	var status = recordStatus{Key: key}
 
-	searchResult, err := esm.Client.Search().
-		Index(elasticDefaultIndex).
-		SearchSource(searchSource).
-		Do(ctx)
+	hash, ok := manager.HashFromMetaKey(key)
+	if !ok {
+		status.Status = "skipped"
+		return status, nil
+	}
 
-	if err != nil {
-		// TODO: Differentiate between "NoResultsError" and Something Significant...
-		fmt.Println("Failed...", err)
+	var e manager.S3UploadMeta
+	if strings.HasPrefix(record.EventName, "ObjectRemoved") {
+		e.Hash = hash
+		e.Deleted = true
 	} else {
-		if searchResult.Suggest != nil {
-			// Get results and save to a slice...
-			for _, ops := range searchResult.Suggest[elasticDefaultIndex] {
-				for _, op := range ops.Options {
-					_ = json.Unmarshal(op.Source, &entry)
-					entries = append(entries, entry.Name)
-				}
-			}
+		b, err := s.store.GetObject(record.S3.Bucket.Name, key)
+		if err == nil {
+			err = json.Unmarshal(b, &e)
+		}
+		if err == nil && e.Hash != hash {
+			err = fmt.Errorf("meta hash %q doesn't match its key", e.Hash)
+		}
+		if err != nil {
+			status.Status, status.Error = "failed", err.Error()
+			return status, nil
 		}
 	}
 
-	// Send results back to responseWriter...
-	json.NewEncoder(w).Encode(entries)
-}
-
-// Start Elastic Manager and S3 Client Manager...
-var s3m = manager.NewS3Session()
-var esm = manager.NewElasticClient()
+	// Shapes dropped from their source are tombstoned, not removed
+	var item *manager.BulkItem
+	var err error
+	if e.Deleted {
+		item, err = s.indexer.Delete(e.Hash)
+		status.Status = "deleted"
+	} else {
+		item, err = s.indexer.Index(&e)
+		status.Status = "indexed"
+	}
 
-func init() {
-	fmt.Println("Init")
-	tpl = template.Must(template.ParseGlob("./api/templates/*"))
+	if err != nil {
+		status.Status, status.Error = "failed", err.Error()
+	}
+	return status, item
 }
 
 func main() {
-	// Add routes to serve home and download pages
-	http.HandleFunc("/", index)
-	http.HandleFunc("/search", _autocomplete)
-	http.HandleFunc("/_sub", _subscription)
-	http.Handle("/favicon.ico", http.NotFoundHandler())
-	http.ListenAndServe(":8081", nil)
+
+	c, err := loadConfig(os.Args[1:])
+	if err == flag.ErrHelp {
+		return
+	} else if err != nil {
+		log.Fatal(err)
+	}
+
+	// SIGTERM (or ^C) stops accepting connections; in-flight requests get
+	// `ShutdownTimeout` to finish before queued index actions are flushed
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	s, err := newServer(context.Background(), c)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	srv := s.httpServer(s.routes())
+	errs := make(chan error, 1)
+	go func() {
+		log.WithFields(log.Fields{"Addr": c.Addr}).Info("Listening")
+		errs <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err = <-errs:
+		log.Error(err)
+	case <-ctx.Done():
+		log.Info("Shutting Down")
+	}
+
+	shutdown, cancel := context.WithTimeout(context.Background(), time.Duration(c.ShutdownTimeout))
+	defer cancel()
+	if err := srv.Shutdown(shutdown); err != nil {
+		log.Error("Shutdown: ", err)
+	}
+
+	stats := s.indexer.Close()
+	log.WithFields(log.Fields{
+		"Indexed": stats.Indexed,
+		"Deleted": stats.Deleted,
+		"Failed":  stats.Failed,
+	}).Info("Stopped")
+
+	if err != nil && err != http.ErrServerClosed {
+		os.Exit(1)
+	}
 }
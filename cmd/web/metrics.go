@@ -0,0 +1,202 @@
+package main
+
+import (
+	manager "aws-lambda-viswal/pkg/manager"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+NOTES:
+	- `/metrics` is the Prometheus text format (version 0.0.4), written by
+	hand; the service only needs counters and one histogram.
+	- Request latencies are labelled by route pattern rather than path, so
+	query strings and unknown paths can't blow up the series count.
+	- Autocomplete hit rate is `viswal_autocomplete_hits_total /
+	viswal_autocomplete_requests_total`, a hit being at least one result.
+	- Bulk counters are the indexer's own `BulkStats`, read at scrape time.
+*/
+
+// latencyBuckets - Upper bounds, in seconds, of the latency histogram
+var latencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// histogram - Cumulative bucket counts, as Prometheus exposes them
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func (h *histogram) observe(v float64) {
+	for i, bound := range latencyBuckets {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+// requestLabels - One latency series
+type requestLabels struct {
+	route  string
+	method string
+	code   int
+}
+
+// metrics - Everything `/metrics` exposes, besides the bulk indexer's
+// stats
+type metrics struct {
+	mu        sync.Mutex
+	latencies map[requestLabels]*histogram
+	ingest    map[string]uint64 // By record status
+
+	autocompleteRequests uint64
+	autocompleteHits     uint64
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		latencies: make(map[requestLabels]*histogram),
+		ingest:    make(map[string]uint64),
+	}
+}
+
+func (m *metrics) observeRequest(l requestLabels, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	h, ok := m.latencies[l]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(latencyBuckets))}
+		m.latencies[l] = h
+	}
+	h.observe(d.Seconds())
+}
+
+func (m *metrics) observeAutocomplete(results int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.autocompleteRequests++
+	if results > 0 {
+		m.autocompleteHits++
+	}
+}
+
+// observeIngest - Count a notification's records by status
+func (m *metrics) observeIngest(statuses []recordStatus) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, status := range statuses {
+		m.ingest[status.Status]++
+	}
+}
+
+// statusRecorder - Remembers the response code for the latency labels
+type statusRecorder struct {
+	http.ResponseWriter
+	code int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	if r.code == 0 {
+		r.code = code
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.code == 0 {
+		r.code = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// instrument - Time `h` under the route `pattern` ("METHOD /path")
+func (m *metrics) instrument(pattern string, h http.HandlerFunc) http.HandlerFunc {
+
+	method, route, _ := strings.Cut(pattern, " ")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		h(rec, r)
+		if rec.code == 0 {
+			rec.code = http.StatusOK
+		}
+		m.observeRequest(requestLabels{route: route, method: method, code: rec.code}, time.Since(start))
+	}
+}
+
+// metrics - `GET /metrics`; see NOTES
+func (s *server) metrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	s.stats.writeTo(w, s.indexer.Stats())
+}
+
+func (m *metrics) writeTo(w io.Writer, bulk manager.BulkStats) {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	writeHeader(w, "viswal_http_request_duration_seconds", "histogram", "Time to serve a request, by route, method and response code.")
+	var labels = make([]requestLabels, 0, len(m.latencies))
+	for l := range m.latencies {
+		labels = append(labels, l)
+	}
+	sort.Slice(labels, func(i, j int) bool {
+		a, b := labels[i], labels[j]
+		if a.route != b.route {
+			return a.route < b.route
+		}
+		if a.method != b.method {
+			return a.method < b.method
+		}
+		return a.code < b.code
+	})
+	for _, l := range labels {
+		h := m.latencies[l]
+		base := fmt.Sprintf(`route=%q,method=%q,code="%d"`, l.route, l.method, l.code)
+		for i, bound := range latencyBuckets {
+			fmt.Fprintf(w, "viswal_http_request_duration_seconds_bucket{%s,le=\"%s\"} %d\n", base, formatFloat(bound), h.counts[i])
+		}
+		fmt.Fprintf(w, "viswal_http_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", base, h.count)
+		fmt.Fprintf(w, "viswal_http_request_duration_seconds_sum{%s} %s\n", base, formatFloat(h.sum))
+		fmt.Fprintf(w, "viswal_http_request_duration_seconds_count{%s} %d\n", base, h.count)
+	}
+
+	writeHeader(w, "viswal_autocomplete_requests_total", "counter", "Autocomplete queries answered.")
+	fmt.Fprintf(w, "viswal_autocomplete_requests_total %d\n", m.autocompleteRequests)
+	writeHeader(w, "viswal_autocomplete_hits_total", "counter", "Autocomplete queries with at least one result.")
+	fmt.Fprintf(w, "viswal_autocomplete_hits_total %d\n", m.autocompleteHits)
+
+	writeHeader(w, "viswal_ingest_records_total", "counter", "S3 records received over SNS, by outcome.")
+	for _, status := range []string{"indexed", "deleted", "skipped", "failed"} {
+		fmt.Fprintf(w, "viswal_ingest_records_total{status=%q} %d\n", status, m.ingest[status])
+	}
+
+	writeHeader(w, "viswal_bulk_items_total", "counter", "Bulk index actions completed, by result.")
+	fmt.Fprintf(w, "viswal_bulk_items_total{result=\"indexed\"} %d\n", bulk.Indexed)
+	fmt.Fprintf(w, "viswal_bulk_items_total{result=\"deleted\"} %d\n", bulk.Deleted)
+	fmt.Fprintf(w, "viswal_bulk_items_total{result=\"failed\"} %d\n", bulk.Failed)
+	writeHeader(w, "viswal_bulk_retries_total", "counter", "Bulk index actions retried after a retryable error.")
+	fmt.Fprintf(w, "viswal_bulk_retries_total %d\n", bulk.Retried)
+	writeHeader(w, "viswal_bulk_flushes_total", "counter", "Bulk requests sent.")
+	fmt.Fprintf(w, "viswal_bulk_flushes_total %d\n", bulk.Flushes)
+}
+
+func writeHeader(w io.Writer, name string, kind string, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
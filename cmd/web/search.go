@@ -0,0 +1,113 @@
+package main
+
+import (
+	manager "aws-lambda-viswal/pkg/manager"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+NOTES:
+	- GET /search?q=springfield&geometry_type=Polygon,MultiPolygon&state=IL&prop.GEOID=1772000&page=1&per_page=20
+	- `q` is free text over the name and property values, every word must
+	match. Filters take repeated or comma separated values, any of which
+	matches; different filters must all match. `prop.<name>` filters a
+	source property on its exact value.
+*/
+
+// propertyParam - Prefix of the property filter parameters
+const propertyParam = "prop."
+
+// SearchResponse - One page of `GET /search`; `Results` is always a list
+type SearchResponse struct {
+	Query   string        `json:"Query"`
+	Total   int64         `json:"Total"`
+	Page    int           `json:"Page"`
+	PerPage int           `json:"PerPage"`
+	Results []ShapeResult `json:"Results"`
+}
+
+// parseSearchQuery - See NOTES; also returns the page and page size
+func parseSearchQuery(params url.Values) (manager.SearchQuery, int, int, error) {
+
+	var q = manager.SearchQuery{
+		Text:          params.Get("q"),
+		GeometryTypes: splitParams(params["geometry_type"]),
+		Categories:    splitParams(params["category"]),
+		States:        splitParams(params["state"]),
+		Properties:    make(map[string][]string),
+	}
+
+	for name, values := range params {
+		if strings.HasPrefix(name, propertyParam) && len(name) > len(propertyParam) {
+			q.Properties[strings.TrimPrefix(name, propertyParam)] = splitParams(values)
+		}
+	}
+
+	page, err := intParam(params, "page", 1)
+	if err != nil {
+		return q, 0, 0, err
+	}
+	perPage, err := intParam(params, "per_page", manager.DefaultSearchSize)
+	if err != nil {
+		return q, 0, 0, err
+	}
+	if page < 1 {
+		return q, 0, 0, fmt.Errorf("page must be 1 or more")
+	}
+	if perPage < 1 || perPage > manager.MaxSearchSize {
+		return q, 0, 0, fmt.Errorf("per_page must be 1 to %d", manager.MaxSearchSize)
+	}
+	if page*perPage > manager.MaxSearchWindow {
+		return q, 0, 0, fmt.Errorf("only the first %d results can be paged to; narrow the query", manager.MaxSearchWindow)
+	}
+
+	q.From, q.Size = (page-1)*perPage, perPage
+	return q, page, perPage, nil
+}
+
+func intParam(params url.Values, name string, def int) (int, error) {
+	v := params.Get(name)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("%s %q is not a number", name, v)
+	}
+	return n, nil
+}
+
+// searchShapes - `GET /search`; see NOTES
+func (s *server) searchShapes(w http.ResponseWriter, r *http.Request) {
+
+	q, page, perPage, err := parseSearchQuery(r.URL.Query())
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{err.Error()})
+		return
+	}
+
+	result, err := s.search.Search(r.Context(), q)
+	if err != nil {
+		log.WithFields(log.Fields{"Query": r.URL.RawQuery}).Error(err)
+		writeJSON(w, http.StatusBadGateway, errorResponse{"search backend unavailable"})
+		return
+	}
+
+	var response = SearchResponse{
+		Query:   q.Text,
+		Total:   result.Total,
+		Page:    page,
+		PerPage: perPage,
+		Results: make([]ShapeResult, 0, len(result.Shapes)),
+	}
+	for _, shape := range result.Shapes {
+		response.Results = append(response.Results, s.newShapeResult(shape))
+	}
+	writeJSON(w, http.StatusOK, response)
+}
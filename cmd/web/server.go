@@ -0,0 +1,88 @@
+package main
+
+import (
+	manager "aws-lambda-viswal/pkg/manager"
+	"context"
+	"html/template"
+	"net/http"
+	"time"
+
+	"aws-lambda-viswal/web"
+)
+
+// server - The web service's dependencies, built once from its config
+type server struct {
+	config  *config
+	tpl     *template.Template
+	store   manager.ObjectStore
+	s3      *manager.S3Session
+	indexer *manager.BulkIndexer
+	search  manager.SearchBackend
+	sns     *snsVerifier
+	stats   *metrics
+}
+
+// newServer - Parse the embedded templates and connect to S3 and
+// ElasticSearch; `ctx` bounds the bulk indexer
+func newServer(ctx context.Context, c *config) (*server, error) {
+
+	tpl, err := template.ParseFS(web.Templates, "templates/*.html")
+	if err != nil {
+		return nil, err
+	}
+
+	sns, err := newSNSVerifier(c.SNSCertHostPattern, c.SNSTopicARNs)
+	if err != nil {
+		return nil, err
+	}
+
+	esm := manager.NewElasticClientFor(c.ElasticHost, c.ElasticIndex)
+	s3m := manager.NewS3Session()
+
+	return &server{
+		config:  c,
+		tpl:     tpl,
+		store:   s3m,
+		s3:      s3m,
+		indexer: esm.NewBulkIndexer(ctx, manager.DefaultBulkIndexerConfig),
+		search:  &esm,
+		sns:     sns,
+		stats:   newMetrics(),
+	}, nil
+}
+
+// routes - Anything not listed is a 404, a listed path with the wrong
+// method a 405. Every route is timed for `/metrics`.
+func (s *server) routes() http.Handler {
+	mux := http.NewServeMux()
+	handle := func(pattern string, h http.HandlerFunc) {
+		mux.HandleFunc(pattern, s.stats.instrument(pattern, h))
+	}
+	handle("GET /{$}", s.index)
+	handle("GET /autocomplete", s.autocomplete)
+	handle("POST /autocomplete", s.autocomplete)
+	handle("GET /search", s.searchShapes)
+	handle("POST /search", s.autocomplete) // For older frontends
+	handle("POST /_sub", s.subscription)
+	handle("GET /healthz", s.healthz)
+	handle("GET /readyz", s.readyz)
+	handle("GET /metrics", s.metrics)
+	return mux
+}
+
+// httpServer - `handler` on the configured address, with its timeouts
+func (s *server) httpServer(handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              s.config.Addr,
+		Handler:           handler,
+		ReadTimeout:       time.Duration(s.config.ReadTimeout),
+		ReadHeaderTimeout: time.Duration(s.config.ReadHeaderTimeout),
+		WriteTimeout:      time.Duration(s.config.WriteTimeout),
+		IdleTimeout:       time.Duration(s.config.IdleTimeout),
+	}
+}
+
+// index - The search page
+func (s *server) index(w http.ResponseWriter, req *http.Request) {
+	s.tpl.ExecuteTemplate(w, "index.html", nil)
+}
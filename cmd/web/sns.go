@@ -0,0 +1,292 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+NOTES:
+	- SNS signs every message it POSTs with the key of a certificate it
+	hosts at `SigningCertURL`. The URL is attacker controlled, so it must
+	be https, end in .pem and have a host matching `SNSCertHostPattern`
+	before it's fetched; certificates are cached by URL until they expire.
+	The same goes for a confirmation's `SubscribeURL`.
+	- The pattern is a regular expression matched against the whole
+	(lowercased) host, default the regional SNS endpoints. Globs were too
+	loose: `sns.*.amazonaws.com` also matched S3 virtual-hosted buckets
+	such as `sns.attacker-bucket.s3.amazonaws.com`.
+	- A valid signature only proves the message came from *some* SNS
+	topic. `SNSTopicARNs` (comma separated) limits which topics are
+	confirmed and accepted; leave it unset only in development.
+	- See https://docs.aws.amazon.com/sns/latest/dg/sns-verify-signature-of-message.html
+*/
+
+// SNS message types, from the `Type` field (and the
+// X-Amz-Sns-Message-Type header)
+const (
+	snsNotification             = "Notification"
+	snsSubscriptionConfirmation = "SubscriptionConfirmation"
+	snsUnsubscribeConfirmation  = "UnsubscribeConfirmation"
+)
+
+// defaultSNSCertHostPattern - Where SNS serves its signing certificates
+const defaultSNSCertHostPattern = `^sns\.[a-z0-9-]+\.amazonaws\.com(\.cn)?$`
+
+// snsMessage - An SNS HTTP(S) delivery; which fields are set (and
+// signed) depends on `Type`
+type snsMessage struct {
+	Type             string `json:"Type"`
+	MessageID        string `json:"MessageId"`
+	Token            string `json:"Token"`
+	TopicArn         string `json:"TopicArn"`
+	Subject          string `json:"Subject"`
+	Message          string `json:"Message"`
+	Timestamp        string `json:"Timestamp"`
+	SignatureVersion string `json:"SignatureVersion"`
+	Signature        string `json:"Signature"`
+	SigningCertURL   string `json:"SigningCertURL"`
+	SubscribeURL     string `json:"SubscribeURL"`
+	UnsubscribeURL   string `json:"UnsubscribeURL"`
+}
+
+// stringToSign - The canonical "Name\nValue\n" form SNS signs, fields in
+// the documented order
+func (m *snsMessage) stringToSign() (string, error) {
+
+	var fields [][2]string
+	switch m.Type {
+	case snsNotification:
+		fields = [][2]string{{"Message", m.Message}, {"MessageId", m.MessageID}}
+		if m.Subject != "" {
+			fields = append(fields, [2]string{"Subject", m.Subject})
+		}
+		fields = append(fields, [][2]string{{"Timestamp", m.Timestamp}, {"TopicArn", m.TopicArn}, {"Type", m.Type}}...)
+	case snsSubscriptionConfirmation, snsUnsubscribeConfirmation:
+		fields = [][2]string{
+			{"Message", m.Message}, {"MessageId", m.MessageID}, {"SubscribeURL", m.SubscribeURL},
+			{"Timestamp", m.Timestamp}, {"Token", m.Token}, {"TopicArn", m.TopicArn}, {"Type", m.Type},
+		}
+	default:
+		return "", fmt.Errorf("sns: unknown message type %q", m.Type)
+	}
+
+	var b strings.Builder
+	for _, f := range fields {
+		b.WriteString(f[0])
+		b.WriteByte('\n')
+		b.WriteString(f[1])
+		b.WriteByte('\n')
+	}
+	return b.String(), nil
+}
+
+// snsVerifier - Checks SNS signatures and confirms subscriptions; see
+// NOTES. `client` fetches certificates and subscribe URLs.
+type snsVerifier struct {
+	certHost *regexp.Regexp
+	topics   map[string]bool
+	client   *http.Client
+
+	mu    sync.Mutex
+	certs map[string]*x509.Certificate
+}
+
+// newSNSVerifier - From a certificate host pattern (default
+// `defaultSNSCertHostPattern`) and comma separated topic ARNs (any, if
+// empty)
+func newSNSVerifier(certHostPattern string, topicARNs string) (*snsVerifier, error) {
+	certHost, err := compileHostPattern(certHostPattern)
+	if err != nil {
+		return nil, err
+	}
+	return &snsVerifier{
+		certHost: certHost,
+		topics:   topicSet(splitList(topicARNs)),
+		client:   &http.Client{Timeout: 10 * time.Second},
+		certs:    make(map[string]*x509.Certificate),
+	}, nil
+}
+
+// compileHostPattern - `pattern`, anchored so it has to match the whole
+// host
+func compileHostPattern(pattern string) (*regexp.Regexp, error) {
+	if strings.TrimSpace(pattern) == "" {
+		pattern = defaultSNSCertHostPattern
+	}
+	re, err := regexp.Compile(`^(?:` + pattern + `)$`)
+	if err != nil {
+		return nil, fmt.Errorf("sns: bad certificate host pattern: %v", err)
+	}
+	return re, nil
+}
+
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func topicSet(arns []string) map[string]bool {
+	var topics = make(map[string]bool, len(arns))
+	for _, arn := range arns {
+		topics[arn] = true
+	}
+	return topics
+}
+
+// allowedURL - An https URL on a host matching the pattern
+func (v *snsVerifier) allowedURL(raw string) (*url.URL, error) {
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("sns: bad URL %q: %v", raw, err)
+	}
+	if u.Scheme != "https" {
+		return nil, fmt.Errorf("sns: %q is not https", raw)
+	}
+
+	host := strings.ToLower(u.Hostname())
+	if !v.certHost.MatchString(host) {
+		return nil, fmt.Errorf("sns: host %q is not allow-listed", host)
+	}
+	return u, nil
+}
+
+// Verify - Check `m`'s topic and signature
+func (v *snsVerifier) Verify(m *snsMessage) error {
+
+	if len(v.topics) > 0 && !v.topics[m.TopicArn] {
+		return fmt.Errorf("sns: topic %q is not allow-listed", m.TopicArn)
+	}
+
+	var hash crypto.Hash
+	switch m.SignatureVersion {
+	case "1":
+		hash = crypto.SHA1
+	case "2":
+		hash = crypto.SHA256
+	default:
+		return fmt.Errorf("sns: unsupported SignatureVersion %q", m.SignatureVersion)
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(m.Signature)
+	if err != nil {
+		return fmt.Errorf("sns: bad signature encoding: %v", err)
+	}
+
+	text, err := m.stringToSign()
+	if err != nil {
+		return err
+	}
+
+	cert, err := v.certificate(m.SigningCertURL)
+	if err != nil {
+		return err
+	}
+	key, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("sns: signing certificate doesn't hold an RSA key")
+	}
+
+	var digest []byte
+	if hash == crypto.SHA1 {
+		sum := sha1.Sum([]byte(text))
+		digest = sum[:]
+	} else {
+		sum := sha256.Sum256([]byte(text))
+		digest = sum[:]
+	}
+
+	if err = rsa.VerifyPKCS1v15(key, hash, digest, signature); err != nil {
+		return fmt.Errorf("sns: signature mismatch: %v", err)
+	}
+	return nil
+}
+
+// certificate - Fetch (or reuse) the certificate at `raw`
+func (v *snsVerifier) certificate(raw string) (*x509.Certificate, error) {
+
+	u, err := v.allowedURL(raw)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(u.Path, ".pem") {
+		return nil, fmt.Errorf("sns: signing certificate URL %q is not a .pem", raw)
+	}
+
+	now := time.Now()
+
+	v.mu.Lock()
+	cert, ok := v.certs[raw]
+	v.mu.Unlock()
+	if ok && now.Before(cert.NotAfter) {
+		return cert, nil
+	}
+
+	b, err := v.get(u)
+	if err != nil {
+		return nil, fmt.Errorf("sns: fetch signing certificate: %v", err)
+	}
+
+	block, _ := pem.Decode(b)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("sns: %q is not a PEM certificate", raw)
+	}
+	if cert, err = x509.ParseCertificate(block.Bytes); err != nil {
+		return nil, fmt.Errorf("sns: parse signing certificate: %v", err)
+	}
+	if now.Before(cert.NotBefore) || now.After(cert.NotAfter) {
+		return nil, fmt.Errorf("sns: signing certificate %q has expired or isn't valid yet", raw)
+	}
+
+	v.mu.Lock()
+	v.certs[raw] = cert
+	v.mu.Unlock()
+	return cert, nil
+}
+
+// Confirm - Visit a (verified) SubscriptionConfirmation's SubscribeURL
+func (v *snsVerifier) Confirm(m *snsMessage) error {
+	u, err := v.allowedURL(m.SubscribeURL)
+	if err != nil {
+		return err
+	}
+	if _, err = v.get(u); err != nil {
+		return fmt.Errorf("sns: confirm subscription to %s: %v", m.TopicArn, err)
+	}
+	return nil
+}
+
+func (v *snsVerifier) get(u *url.URL) ([]byte, error) {
+
+	resp, err := v.client.Get(u.String())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(ioutil.Discard, resp.Body)
+		return nil, fmt.Errorf("%s: %s", u.Host, resp.Status)
+	}
+	return ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
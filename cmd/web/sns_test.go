@@ -0,0 +1,247 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// snsFixture - An SNS stand-in: a TLS server hosting a valid and an
+// expired signing certificate, and a subscribe URL that counts visits
+type snsFixture struct {
+	srv      *httptest.Server
+	key      *rsa.PrivateKey
+	fetches  int32
+	confirms int32
+}
+
+func newSNSFixture(t *testing.T) *snsFixture {
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var f = snsFixture{key: key}
+	var now = time.Now()
+	var valid = selfSigned(t, key, now.Add(-time.Hour), now.Add(time.Hour))
+	var expired = selfSigned(t, key, now.Add(-48*time.Hour), now.Add(-24*time.Hour))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/cert.pem", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&f.fetches, 1)
+		w.Write(valid)
+	})
+	mux.HandleFunc("/expired.pem", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&f.fetches, 1)
+		w.Write(expired)
+	})
+	mux.HandleFunc("/confirm", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&f.confirms, 1)
+	})
+
+	f.srv = httptest.NewTLSServer(mux)
+	t.Cleanup(f.srv.Close)
+	return &f
+}
+
+func selfSigned(t *testing.T, key *rsa.PrivateKey, notBefore time.Time, notAfter time.Time) []byte {
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "sns.us-east-1.amazonaws.com"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+// verifier - Trusting the fixture's TLS certificate and its host only
+func (f *snsFixture) verifier(t *testing.T) *snsVerifier {
+	v, err := newSNSVerifier(`127\.0\.0\.1`, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.client = f.srv.Client()
+	return v
+}
+
+// sign - Set `m`'s signature for its SignatureVersion
+func (f *snsFixture) sign(t *testing.T, m *snsMessage) {
+
+	text, err := m.stringToSign()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var hash = crypto.SHA256
+	var digest []byte
+	if m.SignatureVersion == "1" {
+		sum := sha1.Sum([]byte(text))
+		hash, digest = crypto.SHA1, sum[:]
+	} else {
+		sum := sha256.Sum256([]byte(text))
+		digest = sum[:]
+	}
+
+	signature, err := rsa.SignPKCS1v15(rand.Reader, f.key, hash, digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Signature = base64.StdEncoding.EncodeToString(signature)
+}
+
+func (f *snsFixture) notification(t *testing.T, version string, certPath string) *snsMessage {
+	m := &snsMessage{
+		Type:             snsNotification,
+		MessageID:        "165545c9-2a5c-472c-8df2-7ff2be2b3b1b",
+		TopicArn:         "arn:aws:sns:us-east-1:123456789012:shapes-meta",
+		Subject:          "Amazon S3 Notification",
+		Message:          `{"Records":[]}`,
+		Timestamp:        "2026-01-01T00:00:00.000Z",
+		SignatureVersion: version,
+		SigningCertURL:   f.srv.URL + certPath,
+	}
+	f.sign(t, m)
+	return m
+}
+
+func TestSNSVerify(t *testing.T) {
+
+	f := newSNSFixture(t)
+	v := f.verifier(t)
+
+	for _, version := range []string{"1", "2"} {
+		if err := v.Verify(f.notification(t, version, "/cert.pem")); err != nil {
+			t.Errorf("SignatureVersion %s: %v", version, err)
+		}
+	}
+	if n := atomic.LoadInt32(&f.fetches); n != 1 {
+		t.Errorf("certificate fetched %d times, want 1 (cached)", n)
+	}
+
+	tampered := f.notification(t, "2", "/cert.pem")
+	tampered.Message = `{"Records":[{"s3":{}}]}`
+	if err := v.Verify(tampered); err == nil || !strings.Contains(err.Error(), "signature mismatch") {
+		t.Errorf("tampered Message: got %v, want a signature mismatch", err)
+	}
+
+	if err := v.Verify(f.notification(t, "2", "/expired.pem")); err == nil || !strings.Contains(err.Error(), "expired") {
+		t.Errorf("expired certificate: got %v, want it rejected", err)
+	}
+
+	unknown := f.notification(t, "3", "/cert.pem")
+	if err := v.Verify(unknown); err == nil {
+		t.Error("SignatureVersion 3 was accepted")
+	}
+}
+
+func TestSNSVerifyDisallowedHost(t *testing.T) {
+
+	f := newSNSFixture(t)
+	v := f.verifier(t)
+
+	// Same server, but by a name the pattern doesn't allow; the
+	// certificate mustn't even be fetched
+	m := f.notification(t, "2", "/cert.pem")
+	m.SigningCertURL = strings.Replace(m.SigningCertURL, "127.0.0.1", "localhost", 1)
+	f.sign(t, m)
+	if err := v.Verify(m); err == nil || !strings.Contains(err.Error(), "not allow-listed") {
+		t.Errorf("disallowed host: got %v, want it rejected", err)
+	}
+	if n := atomic.LoadInt32(&f.fetches); n != 0 {
+		t.Errorf("certificate fetched %d times from a disallowed host", n)
+	}
+}
+
+func TestSNSDefaultHostPattern(t *testing.T) {
+
+	v, err := newSNSVerifier("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for raw, allowed := range map[string]bool{
+		"https://sns.us-east-1.amazonaws.com/SimpleNotificationService-abc.pem":     true,
+		"https://SNS.eu-west-2.amazonaws.com/SimpleNotificationService-abc.pem":     true,
+		"https://sns.cn-north-1.amazonaws.com.cn/SimpleNotificationService-abc.pem": true,
+		"https://sns.attacker-bucket.s3.amazonaws.com/cert.pem":                     false,
+		"https://sns.us-east-1.amazonaws.com.attacker.net/cert.pem":                 false,
+		"https://attacker.net/sns.us-east-1.amazonaws.com/cert.pem":                 false,
+		"https://xsns.us-east-1.amazonaws.com/cert.pem":                             false,
+		"http://sns.us-east-1.amazonaws.com/SimpleNotificationService-abc.pem":      false,
+	} {
+		_, err := v.allowedURL(raw)
+		if allowed && err != nil {
+			t.Errorf("%s: %v, want allowed", raw, err)
+		}
+		if !allowed && err == nil {
+			t.Errorf("%s: allowed", raw)
+		}
+	}
+
+	if _, err := newSNSVerifier("sns.(", ""); err == nil {
+		t.Error("bad host pattern was accepted")
+	}
+}
+
+func TestSNSConfirm(t *testing.T) {
+
+	f := newSNSFixture(t)
+	v := f.verifier(t)
+
+	m := &snsMessage{
+		Type:         snsSubscriptionConfirmation,
+		TopicArn:     "arn:aws:sns:us-east-1:123456789012:shapes-meta",
+		SubscribeURL: f.srv.URL + "/confirm?Action=ConfirmSubscription&Token=t",
+	}
+	if err := v.Confirm(m); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&f.confirms); n != 1 {
+		t.Fatalf("subscribe URL visited %d times, want 1", n)
+	}
+
+	for _, raw := range []string{
+		strings.Replace(m.SubscribeURL, "127.0.0.1", "localhost", 1),
+		strings.Replace(m.SubscribeURL, "https://", "http://", 1),
+	} {
+		m.SubscribeURL = raw
+		if err := v.Confirm(m); err == nil {
+			t.Errorf("%s: confirmed", raw)
+		}
+	}
+	if n := atomic.LoadInt32(&f.confirms); n != 1 {
+		t.Errorf("subscribe URL visited %d times, want 1", n)
+	}
+}
+
+func TestSNSTopics(t *testing.T) {
+
+	f := newSNSFixture(t)
+	v, err := newSNSVerifier(`127\.0\.0\.1`, "arn:aws:sns:us-east-1:123456789012:other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.client = f.srv.Client()
+
+	if err := v.Verify(f.notification(t, "2", "/cert.pem")); err == nil || !strings.Contains(err.Error(), "topic") {
+		t.Errorf("other topic: got %v, want it rejected", err)
+	}
+}
@@ -1,12 +1,22 @@
 module aws-lambda-viswal
 
-go 1.12
+go 1.22
 
 require (
-	github.com/aws/aws-lambda-go v1.22.0
+	github.com/andybalholm/brotli v1.0.6
+	github.com/aws/aws-lambda-go v1.28.0
 	github.com/aws/aws-sdk-go v1.37.1
+	github.com/klauspost/compress v1.18.0
 	//github.com/olivere/elastic v6.2.35+incompatible
 	github.com/olivere/elastic/v7 v7.0.22
 	github.com/paulmach/go.geojson v1.4.0
 	github.com/sirupsen/logrus v1.7.0
 )
+
+require (
+	github.com/jmespath/go-jmespath v0.4.0 // indirect
+	github.com/josharian/intern v1.0.0 // indirect
+	github.com/mailru/easyjson v0.7.6 // indirect
+	github.com/pkg/errors v0.9.1 // indirect
+	golang.org/x/sys v0.0.0-20200930185726-fdedc70b468f // indirect
+)
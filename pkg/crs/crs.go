@@ -0,0 +1,380 @@
+// Package crs - Coordinate reference systems sources arrive in, and
+// reprojection between them and WGS84 longitude/latitude
+package crs
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	geojson "github.com/paulmach/go.geojson"
+)
+
+/*
+NOTES:
+	- Only the projection is undone; datum shifts are not applied. NAD83,
+	ETRS89 and GDA94 sit within a couple of meters of WGS84, which is well
+	under what the shapes are reduced to, but NAD27 and other older datums
+	can be off by up to a few hundred meters.
+	- Built in EPSG codes: 4326, 4269, 4258, 4283, 7844 (geographic); 3857,
+	900913, 3395 (Mercator); 326xx / 327xx (WGS84 UTM), 269xx (NAD83 UTM),
+	258xx (ETRS89 UTM); 6933 (EASE-Grid 2.0), 3035 (LAEA Europe), 5070
+	(CONUS Albers), 3577 (Australian Albers). Anything else needs a .prj,
+	which is read by its parameters (state plane included).
+	- "utm" is not a CRS but picks each geometry's UTM zone when
+	projecting out of WGS84; see `FromWGS84`.
+*/
+
+// CRS - A coordinate reference system; the zero value isn't usable, see
+// `Parse`, `FromEPSG`, `FromPRJ` and `FromGeoJSON`
+type CRS struct {
+	Name string
+
+	proj projection // nil for geographic
+
+	// Geographic: radians per unit and the prime meridian (degrees)
+	angular float64
+	primem  float64
+
+	// Projected: meters per unit, central meridian (radians), and false
+	// easting/northing (meters)
+	unit   float64
+	lam0   float64
+	fe, fn float64
+
+	autoUTM bool
+}
+
+// WGS84 - Longitude/latitude in degrees, as GeoJSON is written
+var WGS84 = geographic("EPSG:4326")
+
+func geographic(name string) *CRS {
+	return &CRS{Name: name, angular: math.Pi / 180}
+}
+
+func projected(name string, proj projection, lon0 float64, fe, fn float64) *CRS {
+	return &CRS{Name: name, proj: proj, unit: 1, lam0: lon0 * math.Pi / 180, fe: fe, fn: fn}
+}
+
+// UTM - The UTM zone (on WGS84) holding lon, lat
+func UTM(lon, lat float64) *CRS {
+	zone := int(math.Floor((lon+180)/6)) + 1
+	if zone < 1 {
+		zone = 1
+	}
+	if zone > 60 {
+		zone = 60
+	}
+	code := 32600 + zone
+	if lat < 0 {
+		code = 32700 + zone
+	}
+	c, _ := FromEPSG(code)
+	return c
+}
+
+func utm(name string, el ellipsoid, zone int, south bool) *CRS {
+	var fn float64
+	if south {
+		fn = 10000000
+	}
+	return projected(name, transverseMercator{el: el, k0: 0.9996}, float64(zone*6-183), 500000, fn)
+}
+
+// FromEPSG - A built in CRS by EPSG code
+func FromEPSG(code int) (*CRS, error) {
+
+	name := fmt.Sprintf("EPSG:%d", code)
+
+	switch {
+	case code == 4326:
+		return WGS84, nil
+	case code == 4269, code == 4258, code == 4283, code == 7844:
+		return geographic(name), nil
+
+	case code == 3857, code == 900913:
+		return projected(name, mercator{el: wgs84Ellipsoid, k0: 1, spherical: true}, 0, 0, 0), nil
+	case code == 3395:
+		return projected(name, mercator{el: wgs84Ellipsoid, k0: 1}, 0, 0, 0), nil
+
+	case code > 32600 && code <= 32660:
+		return utm(name, wgs84Ellipsoid, code-32600, false), nil
+	case code > 32700 && code <= 32760:
+		return utm(name, wgs84Ellipsoid, code-32700, true), nil
+	case code > 26900 && code <= 26923:
+		return utm(name, grs80Ellipsoid, code-26900, false), nil
+	case code >= 25828 && code <= 25838:
+		return utm(name, grs80Ellipsoid, code-25800, false), nil
+
+	case code == 6933:
+		return projected(name, newCylindricalEqualArea(wgs84Ellipsoid, radians(30)), 0, 0, 0), nil
+	case code == 3035:
+		return projected(name, newLambertAzimuthalEqualArea(grs80Ellipsoid, radians(52)), 10, 4321000, 3210000), nil
+	case code == 5070:
+		return projected(name, newAlbersEqualArea(grs80Ellipsoid, radians(23), radians(29.5), radians(45.5)), -96, 0, 0), nil
+	case code == 3577:
+		return projected(name, newAlbersEqualArea(grs80Ellipsoid, 0, radians(-18), radians(-36)), 132, 0, 0), nil
+	}
+
+	return nil, fmt.Errorf("crs: EPSG:%d is not built in; supply a .prj", code)
+}
+
+var epsgPattern = regexp.MustCompile(`(?i)^(?:EPSG:{1,2}|urn:ogc:def:crs:EPSG:[^:]*:|https?://www\.opengis\.net/def/crs/EPSG/[^/]*/)?(\d+)$`)
+
+// Parse - A CRS by name: "EPSG:3857", a bare code, the URN and URL forms
+// GeoJSON uses, "CRS84"/"WGS84", or "utm"
+func Parse(name string) (*CRS, error) {
+
+	name = strings.TrimSpace(name)
+	switch strings.ToLower(name) {
+	case "":
+		return nil, fmt.Errorf("crs: empty name")
+	case "crs84", "wgs84", "urn:ogc:def:crs:ogc:1.3:crs84", "urn:ogc:def:crs:ogc::crs84", "http://www.opengis.net/def/crs/ogc/1.3/crs84":
+		return WGS84, nil
+	case "utm":
+		return &CRS{Name: "utm", unit: 1, autoUTM: true}, nil
+	}
+
+	m := epsgPattern.FindStringSubmatch(name)
+	if m == nil {
+		return nil, fmt.Errorf("crs: unrecognized CRS %q", name)
+	}
+	code, err := strconv.Atoi(m[1])
+	if err != nil {
+		return nil, fmt.Errorf("crs: unrecognized CRS %q", name)
+	}
+	return FromEPSG(code)
+}
+
+// FromGeoJSON - The CRS named by a (2008 spec) GeoJSON `crs` member, of
+// type "name" or "EPSG"; nil for an empty member
+func FromGeoJSON(member map[string]interface{}) (*CRS, error) {
+
+	if len(member) == 0 {
+		return nil, nil
+	}
+
+	properties, _ := member["properties"].(map[string]interface{})
+	kind, _ := member["type"].(string)
+
+	switch strings.ToLower(kind) {
+	case "name":
+		if name, ok := properties["name"].(string); ok {
+			return Parse(name)
+		}
+	case "epsg":
+		switch code := properties["code"].(type) {
+		case float64:
+			return FromEPSG(int(code))
+		case string:
+			return Parse(code)
+		}
+	default:
+		return nil, fmt.Errorf("crs: unsupported crs member type %q", kind)
+	}
+	return nil, fmt.Errorf("crs: malformed %q crs member", kind)
+}
+
+// IsGeographic - Whether coordinates are longitude/latitude
+func (c *CRS) IsGeographic() bool {
+	return c.proj == nil && !c.autoUTM
+}
+
+// IsWGS84 - Whether coordinates need no reprojection
+func (c *CRS) IsWGS84() bool {
+	return c.IsGeographic() && c.primem == 0 && c.angular == math.Pi/180
+}
+
+// DegreeLength - Units per degree of longitude at the equator; 1 for
+// geographic CRSs. Converts areas in squared degrees (e.g.
+// `viswal.ZoomArea`) to the CRS.
+func (c *CRS) DegreeLength() float64 {
+	if c.IsGeographic() {
+		return math.Pi / 180 / c.angular
+	}
+	return 2 * math.Pi * wgs84Ellipsoid.a / 360 / math.Max(c.unit, 1e-12)
+}
+
+func (c *CRS) String() string {
+	return c.Name
+}
+
+// Inverse - A position in this CRS as WGS84 longitude, latitude
+func (c *CRS) Inverse(x, y float64) (float64, float64) {
+	if c.autoUTM {
+		return math.NaN(), math.NaN()
+	}
+	if c.IsGeographic() {
+		return x*c.angular*180/math.Pi + c.primem, y * c.angular * 180 / math.Pi
+	}
+	lam, phi := c.proj.inverse(x*c.unit-c.fe, y*c.unit-c.fn)
+	return degrees(normalizeLongitude(lam + c.lam0)), degrees(phi)
+}
+
+// Forward - WGS84 longitude, latitude as a position in this CRS ("utm"
+// uses the zone holding the position)
+func (c *CRS) Forward(lon, lat float64) (float64, float64) {
+	if c.autoUTM {
+		return UTM(lon, lat).Forward(lon, lat)
+	}
+	if c.IsGeographic() {
+		return (lon - c.primem) / (c.angular * 180 / math.Pi), lat / (c.angular * 180 / math.Pi)
+	}
+	x, y := c.proj.forward(normalizeLongitude(radians(lon)-c.lam0), radians(lat))
+	return (x + c.fe) / c.unit, (y + c.fn) / c.unit
+}
+
+// ToWGS84 - Reproject `geom` (in this CRS) to WGS84, in place
+func (c *CRS) ToWGS84(geom *geojson.Geometry) error {
+	if c.autoUTM {
+		return fmt.Errorf("crs: \"utm\" only projects out of WGS84")
+	}
+	if c.IsWGS84() {
+		return nil
+	}
+
+	var err error
+	Transform(geom, func(p []float64) {
+		lon, lat := c.Inverse(p[0], p[1])
+		if err == nil && (math.IsNaN(lon) || math.IsNaN(lat) || math.Abs(lat) > 90) {
+			err = fmt.Errorf("crs: (%v, %v) is outside %s", p[0], p[1], c.Name)
+		}
+		p[0], p[1] = lon, lat
+	})
+	return err
+}
+
+// FromWGS84 - A copy of `geom` (in WGS84) projected to this CRS; "utm"
+// picks the zone holding the middle of the geometry's bounding box
+func (c *CRS) FromWGS84(geom *geojson.Geometry) (*geojson.Geometry, error) {
+
+	var target = c
+	if c.autoUTM {
+		target = UTM(center(geom))
+	}
+
+	out := copyGeometry(geom)
+	Transform(out, func(p []float64) {
+		p[0], p[1] = target.Forward(p[0], p[1])
+	})
+	return out, nil
+}
+
+// transform - Apply `fn` to every position of `geom`
+func Transform(geom *geojson.Geometry, fn func([]float64)) {
+	switch geom.Type {
+	case geojson.GeometryPoint:
+		if len(geom.Point) >= 2 {
+			fn(geom.Point)
+		}
+	case geojson.GeometryMultiPoint:
+		transformPath(geom.MultiPoint, fn)
+	case geojson.GeometryLineString:
+		transformPath(geom.LineString, fn)
+	case geojson.GeometryMultiLineString:
+		for _, line := range geom.MultiLineString {
+			transformPath(line, fn)
+		}
+	case geojson.GeometryPolygon:
+		for _, ring := range geom.Polygon {
+			transformPath(ring, fn)
+		}
+	case geojson.GeometryMultiPolygon:
+		for _, polygon := range geom.MultiPolygon {
+			for _, ring := range polygon {
+				transformPath(ring, fn)
+			}
+		}
+	case geojson.GeometryCollection:
+		for _, g := range geom.Geometries {
+			Transform(g, fn)
+		}
+	}
+}
+
+func transformPath(path [][]float64, fn func([]float64)) {
+	for _, p := range path {
+		if len(p) >= 2 {
+			fn(p)
+		}
+	}
+}
+
+// copyGeometry - A deep copy of the coordinates
+func copyGeometry(geom *geojson.Geometry) *geojson.Geometry {
+
+	path := func(path [][]float64) [][]float64 {
+		var out = make([][]float64, len(path))
+		for i, p := range path {
+			out[i] = append([]float64(nil), p...)
+		}
+		return out
+	}
+	paths := func(paths [][][]float64) [][][]float64 {
+		var out = make([][][]float64, len(paths))
+		for i, p := range paths {
+			out[i] = path(p)
+		}
+		return out
+	}
+
+	switch geom.Type {
+	case geojson.GeometryPoint:
+		return geojson.NewPointGeometry(append([]float64(nil), geom.Point...))
+	case geojson.GeometryMultiPoint:
+		return geojson.NewMultiPointGeometry(path(geom.MultiPoint)...)
+	case geojson.GeometryLineString:
+		return geojson.NewLineStringGeometry(path(geom.LineString))
+	case geojson.GeometryMultiLineString:
+		return geojson.NewMultiLineStringGeometry(paths(geom.MultiLineString)...)
+	case geojson.GeometryPolygon:
+		return geojson.NewPolygonGeometry(paths(geom.Polygon))
+	case geojson.GeometryMultiPolygon:
+		var polygons = make([][][][]float64, len(geom.MultiPolygon))
+		for i, polygon := range geom.MultiPolygon {
+			polygons[i] = paths(polygon)
+		}
+		return geojson.NewMultiPolygonGeometry(polygons...)
+	case geojson.GeometryCollection:
+		var geometries = make([]*geojson.Geometry, len(geom.Geometries))
+		for i, g := range geom.Geometries {
+			geometries[i] = copyGeometry(g)
+		}
+		return geojson.NewCollectionGeometry(geometries...)
+	}
+	return &geojson.Geometry{Type: geom.Type}
+}
+
+// center - The middle of the geometry's bounding box
+func center(geom *geojson.Geometry) (float64, float64) {
+	var minX, minY, maxX, maxY = math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)
+	Transform(geom, func(p []float64) {
+		minX, maxX = math.Min(minX, p[0]), math.Max(maxX, p[0])
+		minY, maxY = math.Min(minY, p[1]), math.Max(maxY, p[1])
+	})
+	if minX > maxX {
+		return 0, 0
+	}
+	return (minX + maxX) / 2, (minY + maxY) / 2
+}
+
+func radians(deg float64) float64 {
+	return deg * math.Pi / 180
+}
+
+func degrees(rad float64) float64 {
+	return rad * 180 / math.Pi
+}
+
+// normalizeLongitude - Wrap to [-pi, pi]
+func normalizeLongitude(lam float64) float64 {
+	for lam > math.Pi {
+		lam -= 2 * math.Pi
+	}
+	for lam < -math.Pi {
+		lam += 2 * math.Pi
+	}
+	return lam
+}
@@ -0,0 +1,205 @@
+package crs
+
+import (
+	"math"
+	"testing"
+
+	geojson "github.com/paulmach/go.geojson"
+)
+
+// dms - Degrees, minutes and seconds as decimal degrees
+func dms(d, m, s float64) float64 {
+	if d < 0 {
+		return d - m/60 - s/3600
+	}
+	return d + m/60 + s/3600
+}
+
+func mustEPSG(t *testing.T, code int) *CRS {
+	t.Helper()
+	c, err := FromEPSG(code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func mustPRJ(t *testing.T, prj string) *CRS {
+	t.Helper()
+	c, err := FromPRJ([]byte(prj))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// Worked examples from EPSG Guidance Note 7-2, plus projection origins
+// that land on the false easting and northing
+func TestForwardReference(t *testing.T) {
+
+	var cases = []struct {
+		name     string
+		crs      *CRS
+		lon, lat float64
+		x, y     float64
+		within   float64
+	}{
+		{"Pseudo-Mercator", mustEPSG(t, 3857), dms(-100, 20, 0), dms(24, 22, 54.433), -11169055.58, 2800000.00, 0.01},
+		{"Pseudo-Mercator edge", mustEPSG(t, 3857), 180, 0, 20037508.34, 0, 0.01},
+		{"ETRS89 / LAEA Europe", mustEPSG(t, 3035), 5, 50, 3962799.45, 2999718.85, 0.01},
+		{"LAEA Europe origin", mustEPSG(t, 3035), 10, 52, 4321000, 3210000, 1e-6},
+		{"British National Grid", mustPRJ(t, `PROJCS["OSGB 1936 / British National Grid",
+			GEOGCS["OSGB 1936",DATUM["OSGB_1936",SPHEROID["Airy 1830",6377563.396,299.3249646]],
+				PRIMEM["Greenwich",0],UNIT["degree",0.0174532925199433]],
+			PROJECTION["Transverse_Mercator"],
+			PARAMETER["latitude_of_origin",49],PARAMETER["central_meridian",-2],
+			PARAMETER["scale_factor",0.9996012717],
+			PARAMETER["false_easting",400000],PARAMETER["false_northing",-100000],
+			UNIT["metre",1]]`), dms(0, 30, 0), dms(50, 30, 0), 577274.99, 69740.50, 0.01},
+		{"Texas South Central (US feet)", mustPRJ(t, `PROJCS["NAD27 / Texas South Central",
+			GEOGCS["NAD27",DATUM["North_American_Datum_1927",SPHEROID["Clarke 1866",6378206.4,294.9786982138982]],
+				PRIMEM["Greenwich",0],UNIT["degree",0.0174532925199433]],
+			PROJECTION["Lambert_Conformal_Conic_2SP"],
+			PARAMETER["standard_parallel_1",28.38333333333333],PARAMETER["standard_parallel_2",30.28333333333333],
+			PARAMETER["latitude_of_origin",27.83333333333333],PARAMETER["central_meridian",-99],
+			PARAMETER["false_easting",2000000],PARAMETER["false_northing",0],
+			UNIT["US survey foot",0.3048006096012192]]`), -96, 28.5, 2963503.91, 254759.80, 0.01},
+		{"UTM 33N central meridian", mustEPSG(t, 32633), 15, 0, 500000, 0, 1e-6},
+		{"UTM 56S central meridian", mustEPSG(t, 32756), 153, 0, 500000, 10000000, 1e-6},
+		{"CONUS Albers origin", mustEPSG(t, 5070), -96, 23, 0, 0, 1e-6},
+		{"Australian Albers origin", mustEPSG(t, 3577), 132, 0, 0, 0, 1e-6},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			x, y := tc.crs.Forward(tc.lon, tc.lat)
+			if math.Abs(x-tc.x) > tc.within || math.Abs(y-tc.y) > tc.within {
+				t.Errorf("Forward(%v, %v) = %.3f, %.3f, want %.3f, %.3f", tc.lon, tc.lat, x, y, tc.x, tc.y)
+			}
+		})
+	}
+}
+
+// Forward then Inverse gets back to where it started, for every built in
+// projected CRS, at a position in its area of use
+func TestRoundTrip(t *testing.T) {
+
+	var cases = []struct {
+		code     int
+		lon, lat float64
+	}{
+		{3857, 151.2093, -33.8688},
+		{900913, -0.1276, 51.5072},
+		{3395, 139.6917, 35.6895},
+		{32633, 16.3738, 48.2082},
+		{32756, 151.2093, -33.8688},
+		{26915, -93.265, 44.9778},
+		{25832, 9.9937, 53.5511},
+		{6933, -47.8825, -15.7942},
+		{3035, 2.3522, 48.8566},
+		{5070, -87.6298, 41.8781},
+		{3577, 144.9631, -37.8136},
+		{4283, 115.8605, -31.9505},
+	}
+
+	for _, tc := range cases {
+		c := mustEPSG(t, tc.code)
+		x, y := c.Forward(tc.lon, tc.lat)
+		lon, lat := c.Inverse(x, y)
+		if math.Abs(lon-tc.lon) > 1e-8 || math.Abs(lat-tc.lat) > 1e-8 {
+			t.Errorf("%s: %v, %v came back as %v, %v", c, tc.lon, tc.lat, lon, lat)
+		}
+	}
+}
+
+func TestFromWGS84RoundTrip(t *testing.T) {
+
+	polygon := geojson.NewPolygonGeometry([][][]float64{{
+		{16.30, 48.15, 170}, {16.45, 48.15, 171}, {16.45, 48.25, 172}, {16.30, 48.25, 173}, {16.30, 48.15, 170},
+	}})
+	original := copyGeometry(polygon)
+
+	for _, name := range []string{"EPSG:32633", "EPSG:3035", "utm"} {
+		c, err := Parse(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		projected, err := c.FromWGS84(polygon)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !sameGeometry(polygon, original, 0) {
+			t.Fatalf("%s: FromWGS84 changed its input", name)
+		}
+
+		// "utm" picks zone 33N (Vienna), and only projects one way
+		back := c
+		if name == "utm" {
+			if err := c.ToWGS84(copyGeometry(projected)); err == nil {
+				t.Error("utm: ToWGS84 succeeded")
+			}
+			back = mustEPSG(t, 32633)
+			if want, _ := mustEPSG(t, 32633).FromWGS84(polygon); !sameGeometry(projected, want, 0) {
+				t.Errorf("utm: not projected to zone 33N")
+			}
+		}
+
+		if err := back.ToWGS84(projected); err != nil {
+			t.Fatal(err)
+		}
+		if !sameGeometry(projected, original, 1e-8) {
+			t.Errorf("%s: %v came back as %v", name, original.Polygon, projected.Polygon)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	for name, want := range map[string]string{
+		"EPSG:3857":                  "EPSG:3857",
+		"epsg::32756":                "EPSG:32756",
+		"4326":                       "EPSG:4326",
+		"urn:ogc:def:crs:EPSG::3035": "EPSG:3035",
+		"http://www.opengis.net/def/crs/EPSG/0/5070": "EPSG:5070",
+		"urn:ogc:def:crs:OGC:1.3:CRS84":              "EPSG:4326",
+		"utm":                                        "utm",
+	} {
+		c, err := Parse(name)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if c.Name != want {
+			t.Errorf("%s: parsed as %s, want %s", name, c.Name, want)
+		}
+	}
+
+	for _, name := range []string{"", "EPSG:2193", "mercator"} {
+		if _, err := Parse(name); err == nil {
+			t.Errorf("%q: parsed", name)
+		}
+	}
+}
+
+// sameGeometry - Same type and positions, each coordinate within `within`
+func sameGeometry(a *geojson.Geometry, b *geojson.Geometry, within float64) bool {
+
+	var pa, pb [][]float64
+	Transform(a, func(p []float64) { pa = append(pa, p) })
+	Transform(b, func(p []float64) { pb = append(pb, p) })
+
+	if a.Type != b.Type || len(pa) != len(pb) {
+		return false
+	}
+	for i := range pa {
+		if len(pa[i]) != len(pb[i]) {
+			return false
+		}
+		for j := range pa[i] {
+			if math.Abs(pa[i][j]-pb[i][j]) > within {
+				return false
+			}
+		}
+	}
+	return true
+}
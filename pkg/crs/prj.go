@@ -0,0 +1,266 @@
+// Package crs -
+package crs
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+/*
+NOTES:
+	- A .prj is WKT1, in either the OGC or the ESRI dialect; both are
+	read by the projection name and parameters rather than by any
+	AUTHORITY code, so uncommon zones (state plane in US feet, say) work
+	as long as their projection does.
+	- Supported projections: Transverse Mercator, Lambert Conformal Conic
+	(1 and 2 SP), Albers, Lambert Azimuthal Equal Area, Mercator (1 and 2
+	SP, and the Web Mercator variants) and Cylindrical Equal Area.
+*/
+
+// wktNode - KEYWORD["value", 1.0, CHILD[...], ...]
+type wktNode struct {
+	keyword string
+	strings []string
+	numbers []float64
+	nodes   []*wktNode
+}
+
+func (n *wktNode) child(keyword string) *wktNode {
+	for _, c := range n.nodes {
+		if strings.EqualFold(c.keyword, keyword) {
+			return c
+		}
+	}
+	return nil
+}
+
+func (n *wktNode) name() string {
+	if len(n.strings) > 0 {
+		return n.strings[0]
+	}
+	return ""
+}
+
+func (n *wktNode) number(i int, def float64) float64 {
+	if n != nil && i < len(n.numbers) {
+		return n.numbers[i]
+	}
+	return def
+}
+
+type wktReader struct {
+	s   string
+	pos int
+}
+
+func (r *wktReader) skipSpace() {
+	for r.pos < len(r.s) && unicode.IsSpace(rune(r.s[r.pos])) {
+		r.pos++
+	}
+}
+
+func (r *wktReader) node() (*wktNode, error) {
+
+	r.skipSpace()
+	start := r.pos
+	for r.pos < len(r.s) && (unicode.IsLetter(rune(r.s[r.pos])) || unicode.IsDigit(rune(r.s[r.pos])) || r.s[r.pos] == '_') {
+		r.pos++
+	}
+	n := &wktNode{keyword: r.s[start:r.pos]}
+
+	r.skipSpace()
+	if r.pos >= len(r.s) || (r.s[r.pos] != '[' && r.s[r.pos] != '(') {
+		return nil, fmt.Errorf("crs: malformed .prj at offset %d", r.pos)
+	}
+	r.pos++
+
+	for {
+		r.skipSpace()
+		if r.pos >= len(r.s) {
+			return nil, fmt.Errorf("crs: unterminated %s in .prj", n.keyword)
+		}
+
+		switch c := r.s[r.pos]; {
+		case c == ']' || c == ')':
+			r.pos++
+			return n, nil
+		case c == ',':
+			r.pos++
+		case c == '"':
+			end := strings.IndexByte(r.s[r.pos+1:], '"')
+			if end < 0 {
+				return nil, fmt.Errorf("crs: unterminated string in .prj")
+			}
+			n.strings = append(n.strings, r.s[r.pos+1:r.pos+1+end])
+			r.pos += end + 2
+		case c == '-' || c == '+' || c == '.' || unicode.IsDigit(rune(c)):
+			start := r.pos
+			for r.pos < len(r.s) && strings.IndexByte("+-.eE0123456789", r.s[r.pos]) >= 0 {
+				r.pos++
+			}
+			v, err := strconv.ParseFloat(r.s[start:r.pos], 64)
+			if err != nil {
+				return nil, fmt.Errorf("crs: bad number %q in .prj", r.s[start:r.pos])
+			}
+			n.numbers = append(n.numbers, v)
+		case unicode.IsLetter(rune(c)):
+			child, err := r.node()
+			if err != nil {
+				return nil, err
+			}
+			n.nodes = append(n.nodes, child)
+		default:
+			return nil, fmt.Errorf("crs: unexpected %q in .prj", c)
+		}
+	}
+}
+
+// FromPRJ - The CRS described by a shapefile's .prj
+func FromPRJ(prj []byte) (*CRS, error) {
+
+	r := wktReader{s: strings.TrimPrefix(string(prj), "\ufeff")}
+	root, err := r.node()
+	if err != nil {
+		return nil, err
+	}
+
+	switch strings.ToUpper(root.keyword) {
+	case "GEOGCS":
+		return geographicPRJ(root)
+	case "PROJCS":
+		return projectedPRJ(root)
+	default:
+		return nil, fmt.Errorf("crs: unsupported .prj %s", root.keyword)
+	}
+}
+
+func geographicPRJ(n *wktNode) (*CRS, error) {
+	c := geographic(n.name())
+	c.primem = n.child("PRIMEM").number(0, 0)
+	c.angular = n.child("UNIT").number(0, math.Pi/180)
+	if c.angular <= 0 {
+		return nil, fmt.Errorf("crs: bad angular unit in %s", c.Name)
+	}
+	return c, nil
+}
+
+// prjEllipsoid - The GEOGCS's SPHEROID, WGS84 if missing
+func prjEllipsoid(geogcs *wktNode) ellipsoid {
+	if geogcs == nil {
+		return wgs84Ellipsoid
+	}
+	datum := geogcs.child("DATUM")
+	if datum == nil || datum.child("SPHEROID") == nil {
+		return wgs84Ellipsoid
+	}
+	spheroid := datum.child("SPHEROID")
+	a, invf := spheroid.number(0, wgs84Ellipsoid.a), spheroid.number(1, 0)
+	if invf == 0 {
+		return ellipsoid{a: a}
+	}
+	return ellipsoid{a: a, f: 1 / invf}
+}
+
+// normalize - Lower case, letters and digits only, so "False_Easting"
+// and "false easting" match
+func normalize(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(s) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func projectedPRJ(n *wktNode) (*CRS, error) {
+
+	var params = make(map[string]float64)
+	for _, c := range n.nodes {
+		if strings.EqualFold(c.keyword, "PARAMETER") && len(c.numbers) > 0 {
+			params[normalize(c.name())] = c.numbers[0]
+		}
+	}
+	param := func(def float64, names ...string) float64 {
+		for _, name := range names {
+			if v, ok := params[name]; ok {
+				return v
+			}
+		}
+		return def
+	}
+
+	projNode := n.child("PROJECTION")
+	if projNode == nil {
+		return nil, fmt.Errorf("crs: %s has no PROJECTION", n.name())
+	}
+
+	el := prjEllipsoid(n.child("GEOGCS"))
+	lon0 := param(0, "centralmeridian", "longitudeofcenter", "longitudeoforigin", "longitudeofnaturalorigin")
+	lat0 := radians(param(0, "latitudeoforigin", "latitudeofcenter", "latitudeofnaturalorigin"))
+	k0 := param(1, "scalefactor", "scalefactoratnaturalorigin")
+	sp1 := param(math.NaN(), "standardparallel1", "latitudeoffirststandardparallel")
+	sp2 := param(math.NaN(), "standardparallel2", "latitudeofsecondstandardparallel")
+
+	var proj projection
+	switch name := normalize(projNode.name()); name {
+
+	case "transversemercator", "gausskruger":
+		proj = transverseMercator{el: el, k0: k0, phi0: lat0}
+
+	case "lambertconformalconic", "lambertconformalconic2sp", "lambertconformalconic1sp":
+		switch {
+		case !math.IsNaN(sp1) && !math.IsNaN(sp2):
+			proj = newLambertConformalConic(el, lat0, radians(sp1), radians(sp2), k0)
+		case !math.IsNaN(sp1):
+			proj = newLambertConformalConic(el, lat0, radians(sp1), radians(sp1), k0)
+		default:
+			proj = newLambertConformalConic(el, lat0, lat0, lat0, k0)
+		}
+
+	case "albers", "albersconicequalarea":
+		if math.IsNaN(sp1) || math.IsNaN(sp2) {
+			return nil, fmt.Errorf("crs: %s needs two standard parallels", n.name())
+		}
+		proj = newAlbersEqualArea(el, lat0, radians(sp1), radians(sp2))
+
+	case "lambertazimuthalequalarea":
+		if math.Abs(math.Abs(lat0)-math.Pi/2) < 1e-10 {
+			return nil, fmt.Errorf("crs: %s: polar Lambert Azimuthal Equal Area is not supported", n.name())
+		}
+		proj = newLambertAzimuthalEqualArea(el, lat0)
+
+	case "mercator", "mercator1sp", "mercator2sp", "mercatorauxiliarysphere", "popularvisualisationpseudomercator":
+		spherical := name == "mercatorauxiliarysphere" || name == "popularvisualisationpseudomercator" ||
+			strings.Contains(strings.ToLower(n.name()), "pseudo") || strings.Contains(strings.ToLower(n.name()), "web_mercator")
+		if !math.IsNaN(sp1) {
+			k0 = msfn(el.e2(), radians(sp1))
+		}
+		if spherical {
+			el, k0 = ellipsoid{a: el.a}, 1
+		}
+		proj = mercator{el: el, k0: k0, spherical: spherical}
+
+	case "cylindricalequalarea", "lambertcylindricalequalarea":
+		var ts float64
+		if !math.IsNaN(sp1) {
+			ts = radians(sp1)
+		}
+		proj = newCylindricalEqualArea(el, ts)
+
+	default:
+		return nil, fmt.Errorf("crs: %s: unsupported projection %q", n.name(), projNode.name())
+	}
+
+	unit := n.child("UNIT").number(0, 1)
+	if unit <= 0 {
+		return nil, fmt.Errorf("crs: bad linear unit in %s", n.name())
+	}
+
+	c := projected(n.name(), proj, lon0, param(0, "falseeasting")*unit, param(0, "falsenorthing")*unit)
+	c.unit = unit
+	return c, nil
+}
@@ -0,0 +1,349 @@
+// Package crs -
+package crs
+
+import (
+	"math"
+)
+
+/*
+NOTES:
+	- Ellipsoidal forms from Snyder, "Map Projections - A Working Manual"
+	(USGS PP 1395); chapter numbers are noted on each projection. Angles
+	are radians and distances meters here; `CRS` handles degrees, units,
+	false easting/northing and the central meridian.
+	- Transverse Mercator uses Snyder's series, good to a millimeter or so
+	within a UTM zone (or state plane zone) and degrading far off the
+	central meridian.
+*/
+
+// projection - Between (longitude from the central meridian, latitude)
+// and (x, y), both in radians / meters
+type projection interface {
+	forward(lam, phi float64) (float64, float64)
+	inverse(x, y float64) (float64, float64)
+}
+
+// ellipsoid - Semi-major axis `a` (meters) and flattening `f`
+type ellipsoid struct {
+	a float64
+	f float64
+}
+
+var (
+	wgs84Ellipsoid = ellipsoid{a: 6378137, f: 1 / 298.257223563}
+	grs80Ellipsoid = ellipsoid{a: 6378137, f: 1 / 298.257222101}
+)
+
+func (el ellipsoid) e2() float64 {
+	return el.f * (2 - el.f)
+}
+
+func (el ellipsoid) e() float64 {
+	return math.Sqrt(el.e2())
+}
+
+// msfn - Snyder's m, (14-15)
+func msfn(e2, phi float64) float64 {
+	s := math.Sin(phi)
+	return math.Cos(phi) / math.Sqrt(1-e2*s*s)
+}
+
+// tsfn - Snyder's t, (15-9)
+func tsfn(e, phi float64) float64 {
+	s := e * math.Sin(phi)
+	return math.Tan(math.Pi/4-phi/2) / math.Pow((1-s)/(1+s), e/2)
+}
+
+// phiFromT - Invert `tsfn`, (7-9)
+func phiFromT(e, t float64) float64 {
+	phi := math.Pi/2 - 2*math.Atan(t)
+	for i := 0; i < 15; i++ {
+		s := e * math.Sin(phi)
+		next := math.Pi/2 - 2*math.Atan(t*math.Pow((1-s)/(1+s), e/2))
+		if math.Abs(next-phi) < 1e-12 {
+			return next
+		}
+		phi = next
+	}
+	return phi
+}
+
+// qsfn - Snyder's q, (3-12)
+func qsfn(e, phi float64) float64 {
+	s := math.Sin(phi)
+	if e == 0 {
+		return 2 * s
+	}
+	es := e * s
+	return (1 - e*e) * (s/(1-es*es) - 1/(2*e)*math.Log((1-es)/(1+es)))
+}
+
+// phiFromQ - Invert `qsfn`, (3-16)
+func phiFromQ(e, q float64) float64 {
+	e2 := e * e
+	phi := math.Asin(math.Max(-1, math.Min(1, q/2)))
+	if e == 0 {
+		return phi
+	}
+	for i := 0; i < 15; i++ {
+		s := math.Sin(phi)
+		c := math.Cos(phi)
+		if math.Abs(c) < 1e-12 {
+			return phi
+		}
+		es := e * s
+		w := 1 - es*es
+		d := w * w / (2 * c) * (q/(1-e2) - s/w + 1/(2*e)*math.Log((1-es)/(1+es)))
+		phi += d
+		if math.Abs(d) < 1e-12 {
+			break
+		}
+	}
+	return phi
+}
+
+// transverseMercator - Chapter 8
+type transverseMercator struct {
+	el   ellipsoid
+	k0   float64
+	phi0 float64
+}
+
+// mlfn - Meridian distance from the equator, (3-21)
+func (p transverseMercator) mlfn(phi float64) float64 {
+	e2 := p.el.e2()
+	e4, e6 := e2*e2, e2*e2*e2
+	return p.el.a * ((1-e2/4-3*e4/64-5*e6/256)*phi -
+		(3*e2/8+3*e4/32+45*e6/1024)*math.Sin(2*phi) +
+		(15*e4/256+45*e6/1024)*math.Sin(4*phi) -
+		(35*e6/3072)*math.Sin(6*phi))
+}
+
+func (p transverseMercator) forward(lam, phi float64) (float64, float64) {
+	e2 := p.el.e2()
+	ep2 := e2 / (1 - e2)
+
+	s, c := math.Sin(phi), math.Cos(phi)
+	n := p.el.a / math.Sqrt(1-e2*s*s)
+	t := math.Tan(phi) * math.Tan(phi)
+	cc := ep2 * c * c
+	a := lam * c
+
+	x := p.k0 * n * (a + (1-t+cc)*math.Pow(a, 3)/6 +
+		(5-18*t+t*t+72*cc-58*ep2)*math.Pow(a, 5)/120)
+	y := p.k0 * (p.mlfn(phi) - p.mlfn(p.phi0) + n*math.Tan(phi)*(a*a/2+
+		(5-t+9*cc+4*cc*cc)*math.Pow(a, 4)/24+
+		(61-58*t+t*t+600*cc-330*ep2)*math.Pow(a, 6)/720))
+	return x, y
+}
+
+func (p transverseMercator) inverse(x, y float64) (float64, float64) {
+	e2 := p.el.e2()
+	e4, e6 := e2*e2, e2*e2*e2
+	ep2 := e2 / (1 - e2)
+
+	m := p.mlfn(p.phi0) + y/p.k0
+	mu := m / (p.el.a * (1 - e2/4 - 3*e4/64 - 5*e6/256))
+	e1 := (1 - math.Sqrt(1-e2)) / (1 + math.Sqrt(1-e2))
+
+	phi1 := mu + (3*e1/2-27*math.Pow(e1, 3)/32)*math.Sin(2*mu) +
+		(21*e1*e1/16-55*math.Pow(e1, 4)/32)*math.Sin(4*mu) +
+		(151*math.Pow(e1, 3)/96)*math.Sin(6*mu) +
+		(1097*math.Pow(e1, 4)/512)*math.Sin(8*mu)
+
+	s, c := math.Sin(phi1), math.Cos(phi1)
+	c1 := ep2 * c * c
+	t1 := math.Tan(phi1) * math.Tan(phi1)
+	n1 := p.el.a / math.Sqrt(1-e2*s*s)
+	r1 := p.el.a * (1 - e2) / math.Pow(1-e2*s*s, 1.5)
+	d := x / (n1 * p.k0)
+
+	phi := phi1 - (n1*math.Tan(phi1)/r1)*(d*d/2-
+		(5+3*t1+10*c1-4*c1*c1-9*ep2)*math.Pow(d, 4)/24+
+		(61+90*t1+298*c1+45*t1*t1-252*ep2-3*c1*c1)*math.Pow(d, 6)/720)
+	lam := (d - (1+2*t1+c1)*math.Pow(d, 3)/6 +
+		(5-2*c1+28*t1-3*c1*c1+8*ep2+24*t1*t1)*math.Pow(d, 5)/120) / c
+	return lam, phi
+}
+
+// lambertConformalConic - Chapter 15; one standard parallel (with a
+// scale factor) when `phi1 == phi2`
+type lambertConformalConic struct {
+	el   ellipsoid
+	k0   float64
+	n    float64
+	f    float64
+	rho0 float64
+}
+
+func newLambertConformalConic(el ellipsoid, phi0, phi1, phi2, k0 float64) lambertConformalConic {
+	e, e2 := el.e(), el.e2()
+	m1, t1 := msfn(e2, phi1), tsfn(e, phi1)
+
+	var n = math.Sin(phi1)
+	if math.Abs(phi1-phi2) > 1e-10 {
+		m2, t2 := msfn(e2, phi2), tsfn(e, phi2)
+		n = (math.Log(m1) - math.Log(m2)) / (math.Log(t1) - math.Log(t2))
+	}
+	f := m1 / (n * math.Pow(t1, n))
+
+	return lambertConformalConic{
+		el:   el,
+		k0:   k0,
+		n:    n,
+		f:    f,
+		rho0: el.a * f * k0 * math.Pow(tsfn(e, phi0), n),
+	}
+}
+
+func (p lambertConformalConic) forward(lam, phi float64) (float64, float64) {
+	var rho float64
+	if math.Abs(math.Abs(phi)-math.Pi/2) > 1e-10 || phi*p.n <= 0 {
+		rho = p.el.a * p.f * p.k0 * math.Pow(tsfn(p.el.e(), phi), p.n)
+	}
+	theta := p.n * lam
+	return rho * math.Sin(theta), p.rho0 - rho*math.Cos(theta)
+}
+
+func (p lambertConformalConic) inverse(x, y float64) (float64, float64) {
+	sign := math.Copysign(1, p.n)
+	rho := sign * math.Hypot(x, p.rho0-y)
+	theta := math.Atan2(sign*x, sign*(p.rho0-y))
+	if rho == 0 {
+		return theta / p.n, sign * math.Pi / 2
+	}
+	t := math.Pow(rho/(p.el.a*p.k0*p.f), 1/p.n)
+	return theta / p.n, phiFromT(p.el.e(), t)
+}
+
+// albersEqualArea - Chapter 14
+type albersEqualArea struct {
+	el   ellipsoid
+	n    float64
+	c    float64
+	rho0 float64
+}
+
+func newAlbersEqualArea(el ellipsoid, phi0, phi1, phi2 float64) albersEqualArea {
+	e, e2 := el.e(), el.e2()
+	m1, q1 := msfn(e2, phi1), qsfn(e, phi1)
+
+	var n = math.Sin(phi1)
+	if math.Abs(phi1-phi2) > 1e-10 {
+		m2, q2 := msfn(e2, phi2), qsfn(e, phi2)
+		n = (m1*m1 - m2*m2) / (q2 - q1)
+	}
+	c := m1*m1 + n*q1
+
+	return albersEqualArea{
+		el:   el,
+		n:    n,
+		c:    c,
+		rho0: el.a * math.Sqrt(c-n*qsfn(e, phi0)) / n,
+	}
+}
+
+func (p albersEqualArea) forward(lam, phi float64) (float64, float64) {
+	rho := p.el.a * math.Sqrt(math.Max(0, p.c-p.n*qsfn(p.el.e(), phi))) / p.n
+	theta := p.n * lam
+	return rho * math.Sin(theta), p.rho0 - rho*math.Cos(theta)
+}
+
+func (p albersEqualArea) inverse(x, y float64) (float64, float64) {
+	sign := math.Copysign(1, p.n)
+	rho := math.Hypot(x, p.rho0-y)
+	theta := math.Atan2(sign*x, sign*(p.rho0-y))
+	q := (p.c - rho*rho*p.n*p.n/(p.el.a*p.el.a)) / p.n
+	return theta / p.n, phiFromQ(p.el.e(), q)
+}
+
+// lambertAzimuthalEqualArea - Chapter 24, oblique and equatorial aspects
+type lambertAzimuthalEqualArea struct {
+	el    ellipsoid
+	qp    float64
+	rq    float64
+	d     float64
+	beta1 float64
+	phi0  float64
+}
+
+func newLambertAzimuthalEqualArea(el ellipsoid, phi0 float64) lambertAzimuthalEqualArea {
+	e := el.e()
+	qp := qsfn(e, math.Pi/2)
+	beta1 := math.Asin(qsfn(e, phi0) / qp)
+	rq := el.a * math.Sqrt(qp/2)
+
+	return lambertAzimuthalEqualArea{
+		el:    el,
+		qp:    qp,
+		rq:    rq,
+		d:     el.a * msfn(el.e2(), phi0) / (rq * math.Cos(beta1)),
+		beta1: beta1,
+		phi0:  phi0,
+	}
+}
+
+func (p lambertAzimuthalEqualArea) forward(lam, phi float64) (float64, float64) {
+	beta := math.Asin(math.Max(-1, math.Min(1, qsfn(p.el.e(), phi)/p.qp)))
+	sb1, cb1 := math.Sin(p.beta1), math.Cos(p.beta1)
+	sb, cb := math.Sin(beta), math.Cos(beta)
+
+	b := p.rq * math.Sqrt(2/(1+sb1*sb+cb1*cb*math.Cos(lam)))
+	return b * p.d * cb * math.Sin(lam), (b / p.d) * (cb1*sb - sb1*cb*math.Cos(lam))
+}
+
+func (p lambertAzimuthalEqualArea) inverse(x, y float64) (float64, float64) {
+	rho := math.Hypot(x/p.d, p.d*y)
+	if rho < 1e-10 {
+		return 0, p.phi0
+	}
+	ce := 2 * math.Asin(math.Min(1, rho/(2*p.rq)))
+	sb1, cb1 := math.Sin(p.beta1), math.Cos(p.beta1)
+	sce, cce := math.Sin(ce), math.Cos(ce)
+
+	q := p.qp * (cce*sb1 + p.d*y*sce*cb1/rho)
+	lam := math.Atan2(x*sce, p.d*rho*cb1*cce-p.d*p.d*y*sb1*sce)
+	return lam, phiFromQ(p.el.e(), q)
+}
+
+// mercator - Chapter 7; `spherical` is Web Mercator's sphere of radius
+// `a`, used on WGS84 coordinates as they are
+type mercator struct {
+	el        ellipsoid
+	k0        float64
+	spherical bool
+}
+
+func (p mercator) e() float64 {
+	if p.spherical {
+		return 0
+	}
+	return p.el.e()
+}
+
+func (p mercator) forward(lam, phi float64) (float64, float64) {
+	return p.el.a * p.k0 * lam, -p.el.a * p.k0 * math.Log(tsfn(p.e(), phi))
+}
+
+func (p mercator) inverse(x, y float64) (float64, float64) {
+	return x / (p.el.a * p.k0), phiFromT(p.e(), math.Exp(-y/(p.el.a*p.k0)))
+}
+
+// cylindricalEqualArea - Chapter 10, normal aspect; `k0` from the
+// standard parallel
+type cylindricalEqualArea struct {
+	el ellipsoid
+	k0 float64
+}
+
+func newCylindricalEqualArea(el ellipsoid, phiTS float64) cylindricalEqualArea {
+	return cylindricalEqualArea{el: el, k0: msfn(el.e2(), phiTS)}
+}
+
+func (p cylindricalEqualArea) forward(lam, phi float64) (float64, float64) {
+	return p.el.a * p.k0 * lam, p.el.a * qsfn(p.el.e(), phi) / (2 * p.k0)
+}
+
+func (p cylindricalEqualArea) inverse(x, y float64) (float64, float64) {
+	return x / (p.el.a * p.k0), phiFromQ(p.el.e(), 2*y*p.k0/p.el.a)
+}
@@ -0,0 +1,198 @@
+// Package formats -
+package formats
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Content-Encodings understood by `Decompress` and written by `Compress`
+const (
+	EncodingGzip   = "gzip"
+	EncodingZstd   = "zstd"
+	EncodingBrotli = "br"
+)
+
+// MaxDecompressedSize - The most bytes `Decompress` and the zip readers
+// (shapefile, KMZ) will unpack from one source, so a small compressed
+// upload can't exhaust memory; set from `SHAPE_MAX_DECOMPRESSED_BYTES` by
+// the Lambda
+var MaxDecompressedSize int64 = 1 << 30
+
+// ErrTooLarge - A source unpacks to more than `MaxDecompressedSize`
+var ErrTooLarge = errors.New("formats: source decompresses to more than the size limit")
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	zipMagic  = []byte("PK\x03\x04")
+)
+
+// sourceExtensions - Extensions `DetectSourceType` reads, for picking the
+// source out of a zip archive
+var sourceExtensions = map[string]bool{
+	".geojson": true, ".json": true, ".wkt": true, ".wkb": true, ".csv": true,
+	".kml": true, ".kmz": true, ".gpx": true, ".gz": true, ".zst": true,
+}
+
+// Decompress - Undo gzip or zstd compression, found from
+// `contentEncoding`, `name`'s extension (.gz, .zst) or the data's magic
+// bytes, or brotli from `contentEncoding` alone, and return `name`
+// without the compression extension. A zip archive that isn't a shapefile
+// (or KMZ) is unpacked to the one source file it holds. Anything else is
+// returned as is.
+func Decompress(name string, contentEncoding string, b []byte) (string, []byte, error) {
+
+	// Layers, e.g. a .geojson.gz inside a .zip
+	for layer := 0; layer < 3; layer++ {
+
+		var encoding = strings.ToLower(strings.TrimSpace(contentEncoding))
+		contentEncoding = ""
+
+		ext := strings.ToLower(path.Ext(name))
+		switch {
+		case encoding == "x-gzip", ext == ".gz", encoding == "" && bytes.HasPrefix(b, gzipMagic):
+			encoding = EncodingGzip
+		case ext == ".zst", encoding == "" && bytes.HasPrefix(b, zstdMagic):
+			encoding = EncodingZstd
+		case encoding == "" && bytes.HasPrefix(b, zipMagic) && ext != ".kmz":
+			encoding = "zip"
+		}
+		if ext == ".gz" || ext == ".zst" {
+			name = strings.TrimSuffix(name, path.Ext(name))
+		}
+
+		// Already decoded along the way (e.g. by an HTTP client)
+		if encoding == EncodingGzip && !bytes.HasPrefix(b, gzipMagic) || encoding == EncodingZstd && !bytes.HasPrefix(b, zstdMagic) {
+			return name, b, nil
+		}
+
+		var err error
+		switch encoding {
+		case "", "identity":
+			return name, b, nil
+		case EncodingGzip:
+			b, err = gunzip(b)
+		case EncodingZstd:
+			b, err = unzstd(b)
+		case EncodingBrotli:
+			b, err = readLimited(brotli.NewReader(bytes.NewReader(b)), MaxDecompressedSize)
+		case "zip":
+			var unpacked bool
+			if name, b, unpacked, err = unzipSource(name, b); err == nil && !unpacked {
+				return name, b, nil
+			}
+		default:
+			return "", nil, fmt.Errorf("formats: unsupported Content-Encoding %q", encoding)
+		}
+		if err != nil {
+			return "", nil, fmt.Errorf("formats: %s %s: %w", encoding, name, err)
+		}
+	}
+
+	return name, b, nil
+}
+
+func gunzip(b []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return readLimited(r, MaxDecompressedSize)
+}
+
+func unzstd(b []byte) ([]byte, error) {
+	d, err := zstd.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer d.Close()
+	return readLimited(d, MaxDecompressedSize)
+}
+
+// readLimited - `ioutil.ReadAll`, but `ErrTooLarge` past `limit` bytes
+func readLimited(r io.Reader, limit int64) ([]byte, error) {
+	b, err := ioutil.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) > limit {
+		return nil, ErrTooLarge
+	}
+	return b, nil
+}
+
+// unzipSource - A zip with a .shp is left for the shapefile reader (named
+// .zip so it's detected as one); otherwise the archive must hold exactly
+// one file with a source extension, which is returned
+func unzipSource(name string, b []byte) (string, []byte, bool, error) {
+
+	archive, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		return "", nil, false, err
+	}
+
+	var sources []*zip.File
+	for _, f := range archive.File {
+		ext := strings.ToLower(path.Ext(f.Name))
+		if ext == ".shp" {
+			if !strings.EqualFold(path.Ext(name), ".zip") {
+				name += ".zip"
+			}
+			return name, b, false, nil
+		}
+		if sourceExtensions[ext] && !strings.HasPrefix(path.Base(f.Name), ".") {
+			sources = append(sources, f)
+		}
+	}
+	if len(sources) != 1 {
+		return "", nil, false, fmt.Errorf("archive holds %d source files, expected 1", len(sources))
+	}
+
+	r, err := sources[0].Open()
+	if err != nil {
+		return "", nil, false, err
+	}
+	defer r.Close()
+
+	content, err := readLimited(r, MaxDecompressedSize)
+	return sources[0].Name, content, true, err
+}
+
+// Compress - Encode `b` for storage with Content-Encoding `encoding`
+// (`EncodingGzip` or `EncodingBrotli`); an empty encoding returns `b`
+func Compress(encoding string, b []byte) ([]byte, error) {
+
+	var buf bytes.Buffer
+	var w io.WriteCloser
+
+	switch encoding {
+	case "":
+		return b, nil
+	case EncodingGzip:
+		w = gzip.NewWriter(&buf)
+	case EncodingBrotli:
+		w = brotli.NewWriterLevel(&buf, brotli.DefaultCompression)
+	default:
+		return nil, fmt.Errorf("formats: unsupported output encoding %q", encoding)
+	}
+
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
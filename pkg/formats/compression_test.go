@@ -0,0 +1,81 @@
+package formats
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// withMaxDecompressedSize - Lower the limit for the rest of the test
+func withMaxDecompressedSize(t *testing.T, max int64) {
+	old := MaxDecompressedSize
+	MaxDecompressedSize = max
+	t.Cleanup(func() { MaxDecompressedSize = old })
+}
+
+func zipped(t *testing.T, files map[string][]byte) []byte {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for name, data := range files {
+		f, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write(data)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDecompressLimit(t *testing.T) {
+
+	withMaxDecompressedSize(t, 1000)
+
+	var fits = bytes.Repeat([]byte(" "), 1000)
+	var bomb = bytes.Repeat([]byte(" "), 1001)
+
+	gz, _ := Compress(EncodingGzip, bomb)
+	if _, _, err := Decompress("a.geojson.gz", "", gz); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("gzip: got %v, want ErrTooLarge", err)
+	}
+	gz, _ = Compress(EncodingGzip, fits)
+	if _, b, err := Decompress("a.geojson.gz", "", gz); err != nil || len(b) != len(fits) {
+		t.Errorf("gzip at the limit: %d bytes, %v", len(b), err)
+	}
+
+	enc, _ := zstd.NewWriter(nil)
+	if _, _, err := Decompress("a.geojson.zst", "", enc.EncodeAll(bomb, nil)); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("zstd: got %v, want ErrTooLarge", err)
+	}
+	if _, b, err := Decompress("a.geojson.zst", "", enc.EncodeAll(fits, nil)); err != nil || len(b) != len(fits) {
+		t.Errorf("zstd at the limit: %d bytes, %v", len(b), err)
+	}
+
+	br, _ := Compress(EncodingBrotli, bomb)
+	if _, _, err := Decompress("a.geojson", EncodingBrotli, br); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("brotli: got %v, want ErrTooLarge", err)
+	}
+	br, _ = Compress(EncodingBrotli, fits)
+	if _, b, err := Decompress("a.geojson", EncodingBrotli, br); err != nil || len(b) != len(fits) {
+		t.Errorf("brotli at the limit: %d bytes, %v", len(b), err)
+	}
+
+	if _, _, err := Decompress("a.zip", "", zipped(t, map[string][]byte{"a.geojson": bomb})); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("zip: got %v, want ErrTooLarge", err)
+	}
+
+	// A shapefile's files count together
+	shp := zipped(t, map[string][]byte{"a.shp": fits[:600], "a.dbf": fits[:600]})
+	if _, err := ShapefilesFromZip(shp); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("shapefile: got %v, want ErrTooLarge", err)
+	}
+
+	if _, err := ReadKMZ(zipped(t, map[string][]byte{"doc.kml": bomb})); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("KMZ: got %v, want ErrTooLarge", err)
+	}
+}
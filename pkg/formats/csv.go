@@ -0,0 +1,152 @@
+// Package formats -
+package formats
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"sort"
+	"strings"
+
+	geojson "github.com/paulmach/go.geojson"
+)
+
+// csvGeometryColumns - Header names recognized as the geometry column,
+// in order of preference
+var csvGeometryColumns = []string{"wkt", "geometry", "geom", "the_geom", "wkb_geometry", "shape"}
+
+// ReadCSV - One feature per row. The geometry column (named `column`, or
+// found from `csvGeometryColumns` when empty) holds WKT, EWKT or hex
+// (E)WKB; every other column becomes a string property. Rows with an
+// empty geometry get a nil geometry.
+func ReadCSV(b []byte, column string) (*geojson.FeatureCollection, error) {
+
+	r := csv.NewReader(bytes.NewReader(b))
+	r.FieldsPerRecord = -1
+
+	rows, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("formats: empty CSV")
+	}
+
+	header := rows[0]
+	if len(header) > 0 {
+		header[0] = strings.TrimPrefix(header[0], "\ufeff") // Excel's BOM
+	}
+
+	var geomIndex = -1
+	if column != "" {
+		for i, h := range header {
+			if h == column {
+				geomIndex = i
+			}
+		}
+	} else {
+	search:
+		for _, name := range csvGeometryColumns {
+			for i, h := range header {
+				if strings.EqualFold(strings.TrimSpace(h), name) {
+					geomIndex = i
+					break search
+				}
+			}
+		}
+	}
+	if geomIndex < 0 {
+		return nil, fmt.Errorf("formats: no geometry column in CSV header %v", header)
+	}
+
+	fc := geojson.NewFeatureCollection()
+	for n, row := range rows[1:] {
+
+		var geom *geojson.Geometry
+		if geomIndex < len(row) && strings.TrimSpace(row[geomIndex]) != "" {
+			if geom, err = parseGeometryText(row[geomIndex]); err != nil {
+				return nil, fmt.Errorf("formats: CSV row %d: %v", n+2, err)
+			}
+		}
+
+		f := geojson.NewFeature(geom)
+		for i, value := range row {
+			if i != geomIndex && i < len(header) {
+				f.Properties[header[i]] = value
+			}
+		}
+		fc.AddFeature(f)
+	}
+
+	return fc, nil
+}
+
+// parseGeometryText - WKT/EWKT, or hex (E)WKB if it's all hex digits
+func parseGeometryText(s string) (*geojson.Geometry, error) {
+	s = strings.TrimSpace(s)
+	if isHex(s) {
+		g, _, err := ParseWKBHex(s)
+		return g, err
+	}
+	g, _, err := ParseWKT(s)
+	return g, err
+}
+
+func isHex(s string) bool {
+	if len(s) == 0 || len(s)%2 != 0 {
+		return false
+	}
+	for _, c := range s {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", c) {
+			return false
+		}
+	}
+	return true
+}
+
+// WriteCSV - One row per feature: the union of all property names
+// (sorted) and a trailing WKT column named `wkt`. Non-string properties
+// are written with fmt's %v.
+func WriteCSV(fc *geojson.FeatureCollection) ([]byte, error) {
+
+	var names = make(map[string]bool)
+	for _, f := range fc.Features {
+		for k := range f.Properties {
+			names[k] = true
+		}
+	}
+	delete(names, "wkt")
+
+	var header []string
+	for k := range names {
+		header = append(header, k)
+	}
+	sort.Strings(header)
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write(append(header, "wkt"))
+
+	for i, f := range fc.Features {
+		row := make([]string, 0, len(header)+1)
+		for _, k := range header {
+			if v, ok := f.Properties[k]; ok && v != nil {
+				row = append(row, fmt.Sprint(v))
+			} else {
+				row = append(row, "")
+			}
+		}
+
+		var wkt string
+		if f.Geometry != nil {
+			var err error
+			if wkt, err = MarshalWKT(f.Geometry); err != nil {
+				return nil, fmt.Errorf("formats: feature %d: %v", i, err)
+			}
+		}
+		w.Write(append(row, wkt))
+	}
+
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
@@ -0,0 +1,68 @@
+// Package formats -
+package formats
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// windows1252 - Code points for bytes 0x80-0x9F; the rest of the code
+// page matches ISO-8859-1. Undefined bytes map to U+FFFD.
+var windows1252 = [32]rune{
+	'€', '�', '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', '�', 'Ž', '�',
+	'�', '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', '�', 'ž', 'Ÿ',
+}
+
+// decoderFor - Text decoder for the encoding named in a .cpg file. The
+// names seen in the wild vary ("UTF-8", "utf8", "1252", "ANSI 1252",
+// "ISO-8859-1", ...). With no (or an unknown) name, bytes that are valid
+// UTF-8 are taken as such and anything else as Windows-1252.
+func decoderFor(name string) func([]byte) string {
+
+	name = strings.ToUpper(strings.TrimSpace(name))
+	name = strings.NewReplacer("-", "", "_", "", " ", "").Replace(name)
+
+	switch name {
+	case "UTF8", "65001":
+		return decodeUTF8
+	case "1252", "ANSI1252", "CP1252", "WINDOWS1252":
+		return decodeWindows1252
+	case "88591", "ISO88591", "LATIN1", "28591":
+		return decodeLatin1
+	}
+
+	return func(b []byte) string {
+		if utf8.Valid(b) {
+			return string(b)
+		}
+		return decodeWindows1252(b)
+	}
+}
+
+// decodeUTF8 - Invalid bytes become U+FFFD
+func decodeUTF8(b []byte) string {
+	if utf8.Valid(b) {
+		return string(b)
+	}
+	return string([]rune(string(b)))
+}
+
+func decodeLatin1(b []byte) string {
+	var runes = make([]rune, len(b))
+	for i, c := range b {
+		runes[i] = rune(c)
+	}
+	return string(runes)
+}
+
+func decodeWindows1252(b []byte) string {
+	var runes = make([]rune, len(b))
+	for i, c := range b {
+		if c >= 0x80 && c < 0xA0 {
+			runes[i] = windows1252[c-0x80]
+		} else {
+			runes[i] = rune(c)
+		}
+	}
+	return string(runes)
+}
@@ -0,0 +1,750 @@
+// Package formats -
+package formats
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+
+	geojson "github.com/paulmach/go.geojson"
+)
+
+/*
+NOTES:
+	- FlatGeobuf (https://flatgeobuf.org, spec v3) is: magic bytes, a size
+	prefixed `Header` flatbuffer, a packed Hilbert R-tree of feature
+	bounding boxes, then size prefixed `Feature` flatbuffers in Hilbert
+	order. The R-tree lets readers fetch features in a bbox with HTTP
+	range requests.
+	- Columns are inferred across all features: bool, integer (Long),
+	number (Double), string, and Json for anything else or mixed types.
+	- Features without a geometry can't be indexed; when there are any the
+	index is left out (`index_node_size` 0).
+	- Coordinates are assumed to be EPSG:4326, as every source is once
+	read (see `ReadSourceWithCRS`).
+*/
+
+var fgbMagic = []byte{0x66, 0x67, 0x62, 0x03, 0x66, 0x67, 0x62, 0x00}
+
+// fgbNodeSize - Branching factor of the R-tree (the spec's default)
+const fgbNodeSize = 16
+
+// FlatGeobuf geometry types
+var fgbGeometryTypes = map[geojson.GeometryType]uint8{
+	geojson.GeometryPoint:           1,
+	geojson.GeometryLineString:      2,
+	geojson.GeometryPolygon:         3,
+	geojson.GeometryMultiPoint:      4,
+	geojson.GeometryMultiLineString: 5,
+	geojson.GeometryMultiPolygon:    6,
+	geojson.GeometryCollection:      7,
+}
+
+// FlatGeobuf column types
+const (
+	fgbBool   uint8 = 2
+	fgbLong   uint8 = 7
+	fgbDouble uint8 = 10
+	fgbString uint8 = 11
+	fgbJSON   uint8 = 12
+)
+
+// MarshalFlatGeobuf - A collection as an indexed FlatGeobuf file; `name`
+// is the dataset name written in the header (may be empty)
+func MarshalFlatGeobuf(fc *geojson.FeatureCollection, name string) ([]byte, error) {
+
+	features := fc.Features
+	columns := fgbColumns(features)
+
+	// Header: geometry type if every feature agrees, extent and dimension
+	var dims = 2
+	var geometryType uint8
+	var extent = emptyBBox()
+	var indexed = len(features) > 0
+	var typed bool
+
+	for i, f := range features {
+		if f.Geometry == nil {
+			indexed = false
+			continue
+		}
+		kind, ok := fgbGeometryTypes[f.Geometry.Type]
+		if !ok {
+			return nil, fmt.Errorf("formats: feature %d: cannot write %q as FlatGeobuf", i, f.Geometry.Type)
+		}
+		if !typed {
+			geometryType, typed = kind, true
+		} else if kind != geometryType {
+			geometryType = 0 // Unknown; each feature's geometry says
+		}
+		if d := maxDimensions(f.Geometry); d > dims {
+			dims = d
+		}
+		extent.expand(geometryBBox(f.Geometry))
+	}
+
+	// Hilbert sort, then encode in that order
+	var items = make([]fgbNode, len(features))
+	for i, f := range features {
+		items[i] = fgbNode{bbox: emptyBBox(), offset: uint64(i)}
+		if f.Geometry != nil {
+			items[i].bbox = geometryBBox(f.Geometry)
+		}
+	}
+	if indexed {
+		hilbertSort(items, extent)
+	}
+
+	// Leaves point at their feature's byte offset from here on
+	var body bytes.Buffer
+	for i := range items {
+		index := items[i].offset
+
+		feature, err := fgbFeature(features[index], columns, dims)
+		if err != nil {
+			return nil, fmt.Errorf("formats: feature %d: %v", index, err)
+		}
+		b := marshalFlatbuffer(feature)
+
+		items[i].offset = uint64(body.Len())
+		writeUint32LE(&body, uint32(len(b)))
+		body.Write(b)
+	}
+
+	var nodeSize uint16
+	if indexed {
+		nodeSize = fgbNodeSize
+	}
+
+	var columnTables = make([]fbTable, len(columns))
+	for i, c := range columns {
+		columnTables[i] = fbTable{c.name, c.kind}
+	}
+
+	var envelope interface{}
+	if typed {
+		envelope = []float64{extent[0], extent[1], extent[2], extent[3]}
+	}
+
+	header := fbTable{
+		nilIfEmpty(name),          // name
+		envelope,                  // envelope
+		geometryType,              // geometry_type
+		dims >= 3,                 // has_z
+		dims >= 4,                 // has_m
+		nil,                       // has_t
+		nil,                       // has_tm
+		columnTables,              // columns
+		uint64(len(features)),     // features_count
+		nodeSize,                  // index_node_size
+		fbTable{nil, int32(4326)}, // crs
+	}
+
+	var out bytes.Buffer
+	out.Write(fgbMagic)
+	h := marshalFlatbuffer(header)
+	writeUint32LE(&out, uint32(len(h)))
+	out.Write(h)
+	if indexed {
+		writePackedRTree(&out, items, nodeSize)
+	}
+	out.Write(body.Bytes())
+
+	return out.Bytes(), nil
+}
+
+func nilIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+type fgbColumn struct {
+	name string
+	kind uint8
+}
+
+// fgbColumns - One column per property name (sorted), typed from every
+// non-null value it takes
+func fgbColumns(features []*geojson.Feature) []fgbColumn {
+
+	var kinds = make(map[string]uint8)
+	for _, f := range features {
+		for k, v := range f.Properties {
+			if v == nil {
+				if _, ok := kinds[k]; !ok {
+					kinds[k] = 0
+				}
+				continue
+			}
+
+			var kind uint8
+			switch v := v.(type) {
+			case bool:
+				kind = fgbBool
+			case float64:
+				kind = fgbDouble
+				if v == math.Trunc(v) && math.Abs(v) < 1<<63 {
+					kind = fgbLong
+				}
+			case int:
+				kind = fgbLong
+			case string:
+				kind = fgbString
+			default:
+				kind = fgbJSON
+			}
+
+			switch seen := kinds[k]; {
+			case seen == 0 || seen == kind:
+				kinds[k] = kind
+			case (seen == fgbLong || seen == fgbDouble) && (kind == fgbLong || kind == fgbDouble):
+				kinds[k] = fgbDouble
+			default:
+				kinds[k] = fgbJSON
+			}
+		}
+	}
+
+	var columns []fgbColumn
+	for k, kind := range kinds {
+		if kind == 0 {
+			kind = fgbString // Only ever null
+		}
+		columns = append(columns, fgbColumn{k, kind})
+	}
+	sort.Slice(columns, func(i, j int) bool { return columns[i].name < columns[j].name })
+	return columns
+}
+
+func fgbFeature(f *geojson.Feature, columns []fgbColumn, dims int) (fbTable, error) {
+
+	var props bytes.Buffer
+	for i, c := range columns {
+		v, ok := f.Properties[c.name]
+		if !ok || v == nil {
+			continue
+		}
+
+		var b [8]byte
+		binary.LittleEndian.PutUint16(b[:], uint16(i))
+		props.Write(b[:2])
+
+		switch c.kind {
+		case fgbBool:
+			if v.(bool) {
+				props.WriteByte(1)
+			} else {
+				props.WriteByte(0)
+			}
+		case fgbLong:
+			var n int64
+			switch v := v.(type) {
+			case float64:
+				n = int64(v)
+			case int:
+				n = int64(v)
+			}
+			binary.LittleEndian.PutUint64(b[:], uint64(n))
+			props.Write(b[:])
+		case fgbDouble:
+			var x float64
+			switch v := v.(type) {
+			case float64:
+				x = v
+			case int:
+				x = float64(v)
+			}
+			binary.LittleEndian.PutUint64(b[:], math.Float64bits(x))
+			props.Write(b[:])
+		case fgbString:
+			s := v.(string)
+			writeUint32LE(&props, uint32(len(s)))
+			props.WriteString(s)
+		case fgbJSON:
+			s, err := json.Marshal(v)
+			if err != nil {
+				return nil, fmt.Errorf("property %q: %v", c.name, err)
+			}
+			writeUint32LE(&props, uint32(len(s)))
+			props.Write(s)
+		}
+	}
+
+	var feature = fbTable{nil, nil}
+	if f.Geometry != nil {
+		geometry, err := fgbGeometry(f.Geometry, dims)
+		if err != nil {
+			return nil, err
+		}
+		feature[0] = geometry
+	}
+	if props.Len() > 0 {
+		feature[1] = props.Bytes()
+	}
+	return feature, nil
+}
+
+// fgbGeometry - Flat coordinate arrays; `ends` (cumulative vertex counts)
+// split rings and lines when there's more than one, MultiPolygons and
+// collections are `parts`
+func fgbGeometry(g *geojson.Geometry, dims int) (fbTable, error) {
+
+	kind, ok := fgbGeometryTypes[g.Type]
+	if !ok {
+		return nil, fmt.Errorf("cannot write %q as FlatGeobuf", g.Type)
+	}
+
+	var xy, z, m []float64
+	var ends []uint32
+
+	add := func(path [][]float64) {
+		for _, pos := range path {
+			xy = append(xy, pos[0], pos[1])
+			if dims >= 3 {
+				z = append(z, coordinate(pos, 2))
+			}
+			if dims >= 4 {
+				m = append(m, coordinate(pos, 3))
+			}
+		}
+		ends = append(ends, uint32(len(xy)/2))
+	}
+
+	var parts []fbTable
+	switch g.Type {
+	case geojson.GeometryPoint:
+		add([][]float64{g.Point})
+	case geojson.GeometryMultiPoint:
+		add(g.MultiPoint)
+	case geojson.GeometryLineString:
+		add(g.LineString)
+	case geojson.GeometryMultiLineString:
+		for _, line := range g.MultiLineString {
+			add(line)
+		}
+	case geojson.GeometryPolygon:
+		for _, ring := range g.Polygon {
+			add(ring)
+		}
+	case geojson.GeometryMultiPolygon:
+		for _, polygon := range g.MultiPolygon {
+			part, err := fgbGeometry(geojson.NewPolygonGeometry(polygon), dims)
+			if err != nil {
+				return nil, err
+			}
+			parts = append(parts, part)
+		}
+	case geojson.GeometryCollection:
+		for _, child := range g.Geometries {
+			part, err := fgbGeometry(child, dims)
+			if err != nil {
+				return nil, err
+			}
+			parts = append(parts, part)
+		}
+	}
+	if len(ends) < 2 || g.Type == geojson.GeometryMultiPoint {
+		ends = nil
+	}
+
+	var geometry = fbTable{nil, nil, nil, nil, nil, nil, kind, nil}
+	if ends != nil {
+		geometry[0] = ends
+	}
+	if xy != nil {
+		geometry[1] = xy
+	}
+	if z != nil {
+		geometry[2] = z
+	}
+	if m != nil {
+		geometry[3] = m
+	}
+	if parts != nil {
+		geometry[7] = parts
+	}
+	return geometry, nil
+}
+
+func coordinate(pos []float64, i int) float64 {
+	if i < len(pos) {
+		return pos[i]
+	}
+	return 0
+}
+
+// bbox - minX, minY, maxX, maxY
+type bbox [4]float64
+
+func emptyBBox() bbox {
+	return bbox{math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)}
+}
+
+func (b *bbox) expand(o bbox) {
+	b[0], b[1] = math.Min(b[0], o[0]), math.Min(b[1], o[1])
+	b[2], b[3] = math.Max(b[2], o[2]), math.Max(b[3], o[3])
+}
+
+func geometryBBox(g *geojson.Geometry) bbox {
+
+	var b = emptyBBox()
+	add := func(path [][]float64) {
+		for _, pos := range path {
+			b.expand(bbox{pos[0], pos[1], pos[0], pos[1]})
+		}
+	}
+
+	switch g.Type {
+	case geojson.GeometryPoint:
+		add([][]float64{g.Point})
+	case geojson.GeometryMultiPoint:
+		add(g.MultiPoint)
+	case geojson.GeometryLineString:
+		add(g.LineString)
+	case geojson.GeometryMultiLineString:
+		for _, line := range g.MultiLineString {
+			add(line)
+		}
+	case geojson.GeometryPolygon:
+		for _, ring := range g.Polygon {
+			add(ring)
+		}
+	case geojson.GeometryMultiPolygon:
+		for _, polygon := range g.MultiPolygon {
+			for _, ring := range polygon {
+				add(ring)
+			}
+		}
+	case geojson.GeometryCollection:
+		for _, child := range g.Geometries {
+			b.expand(geometryBBox(child))
+		}
+	}
+	return b
+}
+
+// BBox - `[minX, minY, maxX, maxY]` of a geometry, nil if it's empty
+func BBox(g *geojson.Geometry) []float64 {
+	if g == nil {
+		return nil
+	}
+	b := geometryBBox(g)
+	if b[0] > b[2] || b[1] > b[3] {
+		return nil
+	}
+	return b[:]
+}
+
+// fgbNode - An R-tree node: a leaf's `offset` is its feature's byte
+// offset in the feature section, a branch's the index of its first child
+type fgbNode struct {
+	bbox   bbox
+	offset uint64
+}
+
+// hilbertSort - Order items by the Hilbert value of their bbox centers
+// over `extent`, as the reference implementation does (descending)
+func hilbertSort(items []fgbNode, extent bbox) {
+
+	const hilbertMax = (1 << 16) - 1
+	width, height := extent[2]-extent[0], extent[3]-extent[1]
+
+	var keys = make([]uint32, len(items))
+	for i, item := range items {
+		b := item.bbox
+		var x, y uint32
+		if width > 0 {
+			x = uint32(math.Floor(hilbertMax * ((b[0]+b[2])/2 - extent[0]) / width))
+		}
+		if height > 0 {
+			y = uint32(math.Floor(hilbertMax * ((b[1]+b[3])/2 - extent[1]) / height))
+		}
+		keys[i] = hilbert(x, y)
+	}
+	sort.Sort(&hilbertOrder{items, keys})
+}
+
+type hilbertOrder struct {
+	items []fgbNode
+	keys  []uint32
+}
+
+func (h *hilbertOrder) Len() int           { return len(h.items) }
+func (h *hilbertOrder) Less(i, j int) bool { return h.keys[i] > h.keys[j] }
+func (h *hilbertOrder) Swap(i, j int) {
+	h.items[i], h.items[j] = h.items[j], h.items[i]
+	h.keys[i], h.keys[j] = h.keys[j], h.keys[i]
+}
+
+// hilbert - Position of (x, y) along a 16 bit Hilbert curve, from
+// https://github.com/rawrunprotected/hilbert_curves (public domain)
+func hilbert(x, y uint32) uint32 {
+
+	a := x ^ y
+	b := 0xFFFF ^ a
+	c := 0xFFFF ^ (x | y)
+	d := x & (y ^ 0xFFFF)
+
+	A := a | (b >> 1)
+	B := (a >> 1) ^ a
+	C := ((c >> 1) ^ (b & (d >> 1))) ^ c
+	D := ((a & (c >> 1)) ^ (d >> 1)) ^ d
+
+	a, b, c, d = A, B, C, D
+	A = (a & (a >> 2)) ^ (b & (b >> 2))
+	B = (a & (b >> 2)) ^ (b & ((a ^ b) >> 2))
+	C ^= (a & (c >> 2)) ^ (b & (d >> 2))
+	D ^= (b & (c >> 2)) ^ ((a ^ b) & (d >> 2))
+
+	a, b, c, d = A, B, C, D
+	A = (a & (a >> 4)) ^ (b & (b >> 4))
+	B = (a & (b >> 4)) ^ (b & ((a ^ b) >> 4))
+	C ^= (a & (c >> 4)) ^ (b & (d >> 4))
+	D ^= (b & (c >> 4)) ^ ((a ^ b) & (d >> 4))
+
+	a, b, c, d = A, B, C, D
+	C ^= (a & (c >> 8)) ^ (b & (d >> 8))
+	D ^= (b & (c >> 8)) ^ ((a ^ b) & (d >> 8))
+
+	a = C ^ (C >> 1)
+	b = D ^ (D >> 1)
+
+	i0 := x ^ y
+	i1 := b | (0xFFFF ^ (i0 | a))
+
+	i0 = (i0 | (i0 << 8)) & 0x00FF00FF
+	i0 = (i0 | (i0 << 4)) & 0x0F0F0F0F
+	i0 = (i0 | (i0 << 2)) & 0x33333333
+	i0 = (i0 | (i0 << 1)) & 0x55555555
+
+	i1 = (i1 | (i1 << 8)) & 0x00FF00FF
+	i1 = (i1 | (i1 << 4)) & 0x0F0F0F0F
+	i1 = (i1 | (i1 << 2)) & 0x33333333
+	i1 = (i1 | (i1 << 1)) & 0x55555555
+
+	return (i1 << 1) | i0
+}
+
+// writePackedRTree - Levels are stored root first, leaves (`items`, in
+// feature order) last; each branch covers up to `nodeSize` consecutive
+// nodes of the level below
+func writePackedRTree(out *bytes.Buffer, items []fgbNode, nodeSize uint16) {
+
+	// Node count per level, leaves first
+	var levels = []int{len(items)}
+	for n := len(items); n != 1; {
+		n = (n + int(nodeSize) - 1) / int(nodeSize)
+		levels = append(levels, n)
+	}
+	if len(levels) == 1 {
+		levels = append(levels, 1) // A lone leaf still gets a root
+	}
+
+	var total int
+	for _, n := range levels {
+		total += n
+	}
+
+	// Start of each level in the node array
+	var starts = make([]int, len(levels))
+	for i, n, end := 0, 0, total; i < len(levels); i++ {
+		n = levels[i]
+		starts[i] = end - n
+		end -= n
+	}
+
+	var nodes = make([]fgbNode, total)
+	copy(nodes[starts[0]:], items)
+
+	for level := 0; level < len(levels)-1; level++ {
+		pos, end := starts[level], starts[level]+levels[level]
+		parent := starts[level+1]
+		for pos < end {
+			node := fgbNode{bbox: emptyBBox(), offset: uint64(pos)}
+			for j := 0; j < int(nodeSize) && pos < end; j++ {
+				node.bbox.expand(nodes[pos].bbox)
+				pos++
+			}
+			nodes[parent] = node
+			parent++
+		}
+	}
+
+	var b [8]byte
+	for _, node := range nodes {
+		for _, v := range node.bbox {
+			binary.LittleEndian.PutUint64(b[:], math.Float64bits(v))
+			out.Write(b[:])
+		}
+		binary.LittleEndian.PutUint64(b[:], node.offset)
+		out.Write(b[:])
+	}
+}
+
+func writeUint32LE(buf *bytes.Buffer, v uint32) {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], v)
+	buf.Write(b[:])
+}
+
+// fbTable - A flatbuffers table, by field id; nil fields are absent.
+// Values are uint8, bool, uint16, int32, uint64 (inline), or string,
+// []byte, []uint32, []float64, fbTable, []fbTable (referenced).
+type fbTable []interface{}
+
+// marshalFlatbuffer - Serialize `root` front to back: each table's vtable
+// just before it, the objects it references after it (uoffsets must
+// point forward)
+func marshalFlatbuffer(root fbTable) []byte {
+	w := &fbWriter{buf: make([]byte, 4)}
+	binary.LittleEndian.PutUint32(w.buf, uint32(w.table(root)))
+	return w.buf
+}
+
+type fbWriter struct {
+	buf []byte
+}
+
+func (w *fbWriter) pad(align int) {
+	for len(w.buf)%align != 0 {
+		w.buf = append(w.buf, 0)
+	}
+}
+
+func (w *fbWriter) uint32At(pos int, v uint32) {
+	binary.LittleEndian.PutUint32(w.buf[pos:], v)
+}
+
+func fbInlineSize(v interface{}) int {
+	switch v.(type) {
+	case uint8, bool:
+		return 1
+	case uint16:
+		return 2
+	case int32:
+		return 4
+	case uint64:
+		return 8
+	default:
+		return 4 // uoffset
+	}
+}
+
+func (w *fbWriter) table(t fbTable) int {
+
+	// Lay out fields largest first so each is naturally aligned
+	var ids []int
+	for id, v := range t {
+		if v != nil {
+			ids = append(ids, id)
+		}
+	}
+	sort.SliceStable(ids, func(i, j int) bool { return fbInlineSize(t[ids[i]]) > fbInlineSize(t[ids[j]]) })
+
+	var offsets = make([]int, len(t))
+	var size, align = 4, 4
+	for _, id := range ids {
+		n := fbInlineSize(t[id])
+		size = (size + n - 1) / n * n
+		offsets[id] = size
+		size += n
+		if n > align {
+			align = n
+		}
+	}
+
+	// vtable: its size, the table's size, then each field's offset
+	w.pad(2)
+	vtable := len(w.buf)
+	var b [2]byte
+	for _, v := range append([]int{4 + 2*len(t), size}, offsets...) {
+		binary.LittleEndian.PutUint16(b[:], uint16(v))
+		w.buf = append(w.buf, b[:]...)
+	}
+
+	w.pad(align)
+	start := len(w.buf)
+	w.buf = append(w.buf, make([]byte, size)...)
+	w.uint32At(start, uint32(int32(start-vtable)))
+
+	for _, id := range ids {
+		pos := start + offsets[id]
+		switch v := t[id].(type) {
+		case uint8:
+			w.buf[pos] = v
+		case bool:
+			if v {
+				w.buf[pos] = 1
+			}
+		case uint16:
+			binary.LittleEndian.PutUint16(w.buf[pos:], v)
+		case int32:
+			w.uint32At(pos, uint32(v))
+		case uint64:
+			binary.LittleEndian.PutUint64(w.buf[pos:], v)
+		default:
+			child := w.reference(v)
+			w.uint32At(pos, uint32(child-pos))
+		}
+	}
+	return start
+}
+
+// reference - Write a string, vector or table; returns its position
+func (w *fbWriter) reference(v interface{}) int {
+
+	var b [8]byte
+	vector := func(n int, elementSize int) int {
+		w.pad(4)
+		for (len(w.buf)+4)%elementSize != 0 {
+			w.buf = append(w.buf, 0)
+		}
+		pos := len(w.buf)
+		binary.LittleEndian.PutUint32(b[:], uint32(n))
+		w.buf = append(w.buf, b[:4]...)
+		return pos
+	}
+
+	switch v := v.(type) {
+	case string:
+		pos := vector(len(v), 1)
+		w.buf = append(append(w.buf, v...), 0)
+		return pos
+	case []byte:
+		pos := vector(len(v), 1)
+		w.buf = append(w.buf, v...)
+		return pos
+	case []uint32:
+		pos := vector(len(v), 4)
+		for _, x := range v {
+			binary.LittleEndian.PutUint32(b[:], x)
+			w.buf = append(w.buf, b[:4]...)
+		}
+		return pos
+	case []float64:
+		pos := vector(len(v), 8)
+		for _, x := range v {
+			binary.LittleEndian.PutUint64(b[:], math.Float64bits(x))
+			w.buf = append(w.buf, b[:]...)
+		}
+		return pos
+	case fbTable:
+		return w.table(v)
+	case []fbTable:
+		pos := vector(len(v), 4)
+		w.buf = append(w.buf, make([]byte, 4*len(v))...)
+		for i, t := range v {
+			element := pos + 4 + 4*i
+			child := w.table(t)
+			w.uint32At(element, uint32(child-element))
+		}
+		return pos
+	default:
+		panic(fmt.Sprintf("formats: no flatbuffer encoding for %T", v))
+	}
+}
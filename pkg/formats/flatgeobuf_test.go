@@ -0,0 +1,470 @@
+package formats
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"sort"
+	"testing"
+
+	geojson "github.com/paulmach/go.geojson"
+)
+
+// fbReader - Just enough flatbuffers to read FlatGeobuf's schema back
+type fbReader struct {
+	t   *testing.T
+	buf []byte
+}
+
+func (r fbReader) slice(pos int, n int) []byte {
+	if pos < 0 || n < 0 || pos+n > len(r.buf) {
+		r.t.Fatalf("flatbuffer: %d bytes at %d, past the end (%d)", n, pos, len(r.buf))
+	}
+	return r.buf[pos : pos+n]
+}
+
+func (r fbReader) u16(pos int) int    { return int(binary.LittleEndian.Uint16(r.slice(pos, 2))) }
+func (r fbReader) u32(pos int) uint32 { return binary.LittleEndian.Uint32(r.slice(pos, 4)) }
+
+func (r fbReader) root() int { return int(r.u32(0)) }
+
+// field - Position of field `id` in the table at `table`, 0 if absent
+func (r fbReader) field(table int, id int) int {
+	vtable := table - int(int32(r.u32(table)))
+	if 4+2*id >= r.u16(vtable) {
+		return 0
+	}
+	if offset := r.u16(vtable + 4 + 2*id); offset != 0 {
+		return table + offset
+	}
+	return 0
+}
+
+func (r fbReader) ref(pos int) int { return pos + int(r.u32(pos)) }
+
+// vector - Start and length of a vector field, 0, 0 if absent
+func (r fbReader) vector(table int, id int) (int, int) {
+	pos := r.field(table, id)
+	if pos == 0 {
+		return 0, 0
+	}
+	v := r.ref(pos)
+	return v + 4, int(r.u32(v))
+}
+
+func (r fbReader) str(table int, id int) string {
+	start, n := r.vector(table, id)
+	return string(r.slice(start, n))
+}
+
+func (r fbReader) u8(table int, id int, otherwise int) int {
+	if pos := r.field(table, id); pos != 0 {
+		return int(r.slice(pos, 1)[0])
+	}
+	return otherwise
+}
+
+func (r fbReader) doubles(table int, id int) []float64 {
+	start, n := r.vector(table, id)
+	var vs []float64
+	for i := 0; i < n; i++ {
+		vs = append(vs, math.Float64frombits(binary.LittleEndian.Uint64(r.slice(start+8*i, 8))))
+	}
+	return vs
+}
+
+func (r fbReader) tables(table int, id int) []int {
+	start, n := r.vector(table, id)
+	var ts []int
+	for i := 0; i < n; i++ {
+		ts = append(ts, r.ref(start+4*i))
+	}
+	return ts
+}
+
+// fgbFile - A FlatGeobuf file read back
+type fgbFile struct {
+	name         string
+	envelope     []float64
+	geometryType int
+	hasZ         bool
+	columns      []fgbColumn
+	count        uint64
+	nodeSize     int
+	crs          int32
+	nodes        []fgbNode
+	offsets      []uint64 // Of each feature in the feature section
+	features     []*geojson.Feature
+}
+
+// fgbTreeSize - Nodes in the packed R-tree, as the reference
+// implementation's `calcTreeSize` counts them
+func fgbTreeSize(items uint64, nodeSize int) uint64 {
+	n, total := items, items
+	for {
+		n = (n + uint64(nodeSize) - 1) / uint64(nodeSize)
+		total += n
+		if n == 1 {
+			return total
+		}
+	}
+}
+
+func decodeFlatGeobuf(t *testing.T, b []byte) *fgbFile {
+
+	if len(b) < 12 || !bytes.Equal(b[:3], fgbMagic[:3]) || b[3] != 3 {
+		t.Fatalf("not a FlatGeobuf v3 file: % x", b[:8])
+	}
+
+	size := int(binary.LittleEndian.Uint32(b[8:]))
+	header := fbReader{t, b[12 : 12+size]}
+	h := header.root()
+	rest := b[12+size:]
+
+	var file = fgbFile{
+		name:         header.str(h, 0),
+		envelope:     header.doubles(h, 1),
+		geometryType: header.u8(h, 2, 0),
+		hasZ:         header.u8(h, 3, 0) != 0,
+		nodeSize:     16,
+	}
+	for _, c := range header.tables(h, 7) {
+		file.columns = append(file.columns, fgbColumn{header.str(c, 0), uint8(header.u8(c, 1, 0))})
+	}
+	if pos := header.field(h, 8); pos != 0 {
+		file.count = binary.LittleEndian.Uint64(header.slice(pos, 8))
+	}
+	if pos := header.field(h, 9); pos != 0 {
+		file.nodeSize = header.u16(pos)
+	}
+	if pos := header.field(h, 10); pos != 0 {
+		crs := header.ref(pos)
+		if code := header.field(crs, 1); code != 0 {
+			file.crs = int32(header.u32(code))
+		}
+	}
+
+	if file.nodeSize > 0 && file.count > 0 {
+		n := int(fgbTreeSize(file.count, file.nodeSize))
+		if len(rest) < 40*n {
+			t.Fatalf("index: %d bytes, want %d", len(rest), 40*n)
+		}
+		for i := 0; i < n; i++ {
+			node := rest[40*i:]
+			var f fgbNode
+			for j := range f.bbox {
+				f.bbox[j] = math.Float64frombits(binary.LittleEndian.Uint64(node[8*j:]))
+			}
+			f.offset = binary.LittleEndian.Uint64(node[32:])
+			file.nodes = append(file.nodes, f)
+		}
+		rest = rest[40*n:]
+	}
+
+	var offset int
+	for i := uint64(0); i < file.count; i++ {
+		if len(rest[offset:]) < 4 {
+			t.Fatalf("feature %d: missing", i)
+		}
+		size := int(binary.LittleEndian.Uint32(rest[offset:]))
+		r := fbReader{t, rest[offset+4 : offset+4+size]}
+		file.offsets = append(file.offsets, uint64(offset))
+		file.features = append(file.features, file.feature(r))
+		offset += 4 + size
+	}
+	if offset != len(rest) {
+		t.Errorf("%d bytes after the last feature", len(rest)-offset)
+	}
+	return &file
+}
+
+func (file *fgbFile) feature(r fbReader) *geojson.Feature {
+
+	f := geojson.NewFeature(nil)
+	root := r.root()
+	if pos := r.field(root, 0); pos != 0 {
+		f.Geometry = file.geometry(r, r.ref(pos), file.geometryType)
+	}
+
+	start, n := r.vector(root, 1)
+	props := r.slice(start, n)
+	for len(props) > 0 {
+		i := int(binary.LittleEndian.Uint16(props))
+		if i >= len(file.columns) {
+			r.t.Fatalf("property column %d of %d", i, len(file.columns))
+		}
+		c := file.columns[i]
+		props = props[2:]
+
+		switch c.kind {
+		case fgbBool:
+			f.Properties[c.name], props = props[0] != 0, props[1:]
+		case fgbLong:
+			f.Properties[c.name], props = float64(int64(binary.LittleEndian.Uint64(props))), props[8:]
+		case fgbDouble:
+			f.Properties[c.name], props = math.Float64frombits(binary.LittleEndian.Uint64(props)), props[8:]
+		case fgbString, fgbJSON:
+			size := int(binary.LittleEndian.Uint32(props))
+			value := props[4 : 4+size]
+			props = props[4+size:]
+			if c.kind == fgbString {
+				f.Properties[c.name] = string(value)
+				continue
+			}
+			var v interface{}
+			if err := json.Unmarshal(value, &v); err != nil {
+				r.t.Fatalf("column %s: %v", c.name, err)
+			}
+			f.Properties[c.name] = v
+		default:
+			r.t.Fatalf("column %s: unexpected type %d", c.name, c.kind)
+		}
+	}
+	return f
+}
+
+// geometry - `kind` is the header's geometry type, used when the
+// geometry doesn't have its own
+func (file *fgbFile) geometry(r fbReader, g int, kind int) *geojson.Geometry {
+
+	kind = r.u8(g, 6, kind)
+	xy, z, m := r.doubles(g, 1), r.doubles(g, 2), r.doubles(g, 3)
+
+	var positions [][]float64
+	for i := 0; i < len(xy)/2; i++ {
+		pos := []float64{xy[2*i], xy[2*i+1]}
+		if z != nil {
+			pos = append(pos, z[i])
+		}
+		if m != nil {
+			pos = append(pos, m[i])
+		}
+		positions = append(positions, pos)
+	}
+
+	start, n := r.vector(g, 0)
+	var paths [][][]float64
+	var from int
+	for i := 0; i < n; i++ {
+		end := int(r.u32(start + 4*i))
+		paths = append(paths, positions[from:end])
+		from = end
+	}
+	if n == 0 {
+		paths = [][][]float64{positions}
+	}
+
+	var parts []*geojson.Geometry
+	for _, part := range r.tables(g, 7) {
+		parts = append(parts, file.geometry(r, part, 0))
+	}
+
+	switch kind {
+	case 1:
+		return geojson.NewPointGeometry(positions[0])
+	case 2:
+		return geojson.NewLineStringGeometry(positions)
+	case 3:
+		return geojson.NewPolygonGeometry(paths)
+	case 4:
+		return geojson.NewMultiPointGeometry(positions...)
+	case 5:
+		return geojson.NewMultiLineStringGeometry(paths...)
+	case 6:
+		var polygons [][][][]float64
+		for _, part := range parts {
+			polygons = append(polygons, part.Polygon)
+		}
+		return geojson.NewMultiPolygonGeometry(polygons...)
+	case 7:
+		return geojson.NewCollectionGeometry(parts...)
+	}
+	r.t.Fatalf("unknown geometry type %d", kind)
+	return nil
+}
+
+const flatGeobufRoundTrip = `{"type": "FeatureCollection", "features": [
+	{"type": "Feature", "geometry": {"type": "Point", "coordinates": [151.2092955, -33.868820]},
+		"properties": {"n": 0, "name": "Sydney", "capital": false, "ratio": 0.25, "tags": {"state": "NSW"}}},
+	{"type": "Feature", "geometry": {"type": "LineString", "coordinates": [[0, 0], [1.5, -1.5], [3, 0]]},
+		"properties": {"n": 1, "name": "line", "ratio": 2}},
+	{"type": "Feature", "geometry": {"type": "Polygon", "coordinates": [
+		[[0, 0], [10, 0], [10, 10], [0, 10], [0, 0]],
+		[[2, 2], [2, 4], [4, 4], [2, 2]]]}, "properties": {"n": 2, "tags": [1, "two"]}},
+	{"type": "Feature", "geometry": {"type": "MultiPolygon", "coordinates": [
+		[[[0, 0], [1, 0], [1, 1], [0, 0]]],
+		[[[5, 5], [6, 5], [6, 6], [5, 5]], [[5.2, 5.1], [5.8, 5.1], [5.8, 5.7], [5.2, 5.1]]]]}, "properties": {"n": 3}},
+	{"type": "Feature", "geometry": {"type": "MultiLineString", "coordinates": [[[0, 0], [1, 1]], [[2, 2], [3, 3], [4, 2]]]}, "properties": {"n": 4}},
+	{"type": "Feature", "geometry": {"type": "MultiPoint", "coordinates": [[0, 0], [-1, -1]]}, "properties": {"n": 5}},
+	{"type": "Feature", "geometry": {"type": "GeometryCollection", "geometries": [
+		{"type": "Point", "coordinates": [-20, 40]},
+		{"type": "LineString", "coordinates": [[1, 2], [3, 4]]}]}, "properties": {"n": 6, "name": "mixed"}}
+]}`
+
+func TestFlatGeobufRoundTrip(t *testing.T) {
+
+	fc, err := geojson.UnmarshalFeatureCollection([]byte(flatGeobufRoundTrip))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := MarshalFlatGeobuf(fc, "shapes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := decodeFlatGeobuf(t, b)
+
+	if file.name != "shapes" || file.count != 7 || file.crs != 4326 || file.hasZ {
+		t.Errorf("header: name %q, %d features, EPSG:%d, has_z %v", file.name, file.count, file.crs, file.hasZ)
+	}
+	if file.geometryType != 0 {
+		t.Errorf("geometry type %d for mixed geometries, want 0 (unknown)", file.geometryType)
+	}
+	if want := []float64{-20, -33.868820, 151.2092955, 40}; !sameFloats(file.envelope, want) {
+		t.Errorf("envelope %v, want %v", file.envelope, want)
+	}
+
+	var columns = map[string]uint8{}
+	for _, c := range file.columns {
+		columns[c.name] = c.kind
+	}
+	for name, kind := range map[string]uint8{"n": fgbLong, "name": fgbString, "capital": fgbBool, "ratio": fgbDouble, "tags": fgbJSON} {
+		if columns[name] != kind {
+			t.Errorf("column %s: type %d, want %d", name, columns[name], kind)
+		}
+	}
+
+	// The R-tree: the root covers the extent, and each leaf (the last
+	// nodes, in feature order) points at its feature and bounds it
+	if len(file.nodes) == 0 || !sameFloats(file.nodes[0].bbox[:], file.envelope) {
+		t.Fatalf("root node %v, want the envelope %v", file.nodes, file.envelope)
+	}
+	leaves := file.nodes[len(file.nodes)-len(file.features):]
+	for i, leaf := range leaves {
+		if leaf.offset != file.offsets[i] {
+			t.Errorf("leaf %d: offset %d, feature at %d", i, leaf.offset, file.offsets[i])
+		}
+		if b := geometryBBox(file.features[i].Geometry); b != leaf.bbox {
+			t.Errorf("leaf %d: bbox %v, feature's %v", i, leaf.bbox, b)
+		}
+	}
+
+	// Features come back in Hilbert order; `n` is the original index
+	got := file.features
+	sort.Slice(got, func(i, j int) bool { return got[i].Properties["n"].(float64) < got[j].Properties["n"].(float64) })
+	sameFeatures(t, got, fc.Features)
+}
+
+func TestFlatGeobufDimensions(t *testing.T) {
+
+	fc := geojson.NewFeatureCollection()
+	fc.AddFeature(geojson.NewLineStringFeature([][]float64{{1, 2, 3}, {4, 5, 6}}))
+	fc.AddFeature(geojson.NewPointFeature([]float64{7, 8, 9}))
+
+	b, err := MarshalFlatGeobuf(fc, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := decodeFlatGeobuf(t, b)
+	if !file.hasZ || file.name != "" {
+		t.Errorf("has_z %v, name %q", file.hasZ, file.name)
+	}
+
+	got := file.features
+	sort.Slice(got, func(i, j int) bool { return got[i].Geometry.Type < got[j].Geometry.Type })
+	sameFeatures(t, got, fc.Features)
+}
+
+// Without a geometry a feature can't be indexed, so there's no R-tree
+// and features stay in order
+func TestFlatGeobufWithoutGeometry(t *testing.T) {
+
+	fc := geojson.NewFeatureCollection()
+	fc.AddFeature(geojson.NewPointFeature([]float64{1, 2}))
+	missing := geojson.NewFeature(nil)
+	missing.Properties["name"] = "nowhere"
+	fc.AddFeature(missing)
+
+	b, err := MarshalFlatGeobuf(fc, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := decodeFlatGeobuf(t, b)
+	if file.nodeSize != 0 || len(file.nodes) != 0 {
+		t.Errorf("index_node_size %d, %d nodes; want no index", file.nodeSize, len(file.nodes))
+	}
+	sameFeatures(t, file.features, fc.Features)
+}
+
+// A single point, worked out by hand from the FlatGeobuf v3 schema
+// (header.fbs, feature.fbs): each table's vtable comes just before it and
+// the vectors and tables it refers to just after
+func TestFlatGeobufFixture(t *testing.T) {
+
+	fc := geojson.NewFeatureCollection()
+	fc.AddFeature(geojson.NewPointFeature([]float64{1, 2}))
+
+	b, err := MarshalFlatGeobuf(fc, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	one := []byte{0, 0, 0, 0, 0, 0, 0xf0, 0x3f}
+	two := []byte{0, 0, 0, 0, 0, 0, 0, 0x40}
+	point := bytes.Join([][]byte{one, two, one, two}, nil)
+
+	want := bytes.Join([][]byte{
+		{0x66, 0x67, 0x62, 0x03, 0x66, 0x67, 0x62, 0x00}, // magic
+		{0x7c, 0x00, 0x00, 0x00},                         // header, 124 bytes
+		{0x20, 0x00, 0x00, 0x00},                         // root table at 32
+		// Header vtable (26 bytes, table 33 bytes): envelope 16,
+		// geometry_type 30, has_z 31, has_m 32, columns 20,
+		// features_count 8, index_node_size 28, crs 24
+		{0x1a, 0x00, 0x21, 0x00, 0x00, 0x00, 0x10, 0x00, 0x1e, 0x00, 0x1f, 0x00, 0x20, 0x00,
+			0x00, 0x00, 0x00, 0x00, 0x14, 0x00, 0x08, 0x00, 0x1c, 0x00, 0x18, 0x00},
+		{0x00, 0x00}, // padding to 8
+		{0x1c, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}, // header table at 32: vtable 28 back
+		{0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}, // features_count 1
+		{0x14, 0x00, 0x00, 0x00},                         // envelope, at 68
+		{0x34, 0x00, 0x00, 0x00},                         // columns, at 104
+		{0x3c, 0x00, 0x00, 0x00},                         // crs, at 116
+		{0x10, 0x00},                                     // index_node_size 16
+		{0x01, 0x00, 0x00},                               // Point, no z, no m
+		{0x00, 0x00, 0x00},                               // padding to 4
+		{0x04, 0x00, 0x00, 0x00}, point,                  // envelope [1, 2, 1, 2]
+		{0x00, 0x00, 0x00, 0x00},                         // no columns
+		{0x08, 0x00, 0x08, 0x00, 0x00, 0x00, 0x04, 0x00}, // Crs vtable: code at 4
+		{0x08, 0x00, 0x00, 0x00, 0xe6, 0x10, 0x00, 0x00}, // Crs table: code 4326
+		// Index: the root (first child is node 1), then the leaf
+		// (feature at offset 0)
+		point, {0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+		point, {0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+		{0x48, 0x00, 0x00, 0x00},                         // feature, 72 bytes
+		{0x0c, 0x00, 0x00, 0x00},                         // root table at 12
+		{0x08, 0x00, 0x08, 0x00, 0x04, 0x00, 0x00, 0x00}, // Feature vtable: geometry at 4
+		{0x08, 0x00, 0x00, 0x00, 0x18, 0x00, 0x00, 0x00}, // Feature table: geometry at 40
+		// Geometry vtable (20 bytes, table 9 bytes): xy 4, type 8
+		{0x14, 0x00, 0x09, 0x00, 0x00, 0x00, 0x04, 0x00, 0x00, 0x00,
+			0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x08, 0x00, 0x00, 0x00},
+		{0x14, 0x00, 0x00, 0x00, 0x08, 0x00, 0x00, 0x00, 0x01}, // Geometry table: xy at 52, Point
+		{0x00, 0x00, 0x00},                 // padding to 4
+		{0x02, 0x00, 0x00, 0x00}, one, two, // xy [1, 2]
+	}, nil)
+
+	if !bytes.Equal(b, want) {
+		t.Errorf("got  % x\nwant % x", b, want)
+	}
+}
+
+func sameFloats(a []float64, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
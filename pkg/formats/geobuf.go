@@ -0,0 +1,363 @@
+// Package formats -
+package formats
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+
+	geojson "github.com/paulmach/go.geojson"
+)
+
+/*
+NOTES:
+	- Geobuf (https://github.com/mapbox/geobuf) is GeoJSON as protobuf:
+	coordinates are integers at a fixed precision, delta encoded per path,
+	and property names are stored once per document. This writes at
+	Geobuf's default (and maximum) precision of 6 decimal places.
+	- Follows geobuf.proto field numbers and the reference encoder's
+	conventions: closing vertices of rings aren't written, `lengths` is
+	left out when there's a single path, nested properties go in
+	`json_value`.
+*/
+
+// geobufPrecision - Decimal places kept; Geobuf's default, so not written
+const geobufPrecision = 6
+
+// Geobuf geometry types
+var geobufTypes = map[geojson.GeometryType]uint64{
+	geojson.GeometryPoint:           0,
+	geojson.GeometryMultiPoint:      1,
+	geojson.GeometryLineString:      2,
+	geojson.GeometryMultiLineString: 3,
+	geojson.GeometryPolygon:         4,
+	geojson.GeometryMultiPolygon:    5,
+	geojson.GeometryCollection:      6,
+}
+
+// MarshalGeobuf - A collection as a Geobuf `FeatureCollection`
+func MarshalGeobuf(fc *geojson.FeatureCollection) ([]byte, error) {
+	return marshalGeobuf(fc.Features, true)
+}
+
+// MarshalGeobufFeature - A single feature as a Geobuf `Feature`, as the
+// per-shape objects in the target bucket are written
+func MarshalGeobufFeature(f *geojson.Feature) ([]byte, error) {
+	return marshalGeobuf([]*geojson.Feature{f}, false)
+}
+
+func marshalGeobuf(features []*geojson.Feature, collection bool) ([]byte, error) {
+
+	var e = geobufEncoder{keys: make(map[string]uint64), dims: 2}
+	for _, f := range features {
+		e.scan(f)
+	}
+
+	var body pbWriter
+	for i, f := range features {
+		feature, err := e.feature(f)
+		if err != nil {
+			return nil, fmt.Errorf("formats: feature %d: %v", i, err)
+		}
+		if collection {
+			body.message(1, feature)
+		} else {
+			body = feature
+		}
+	}
+
+	var data pbWriter
+	for _, k := range e.names {
+		data.string(1, k)
+	}
+	if e.dims != 2 {
+		data.varint(2, uint64(e.dims))
+	}
+	if collection {
+		data.message(4, body)
+	} else {
+		data.message(5, body)
+	}
+	return data, nil
+}
+
+type geobufEncoder struct {
+	keys  map[string]uint64
+	names []string
+	dims  int
+}
+
+// scan - Collect property names and the largest dimension
+func (e *geobufEncoder) scan(f *geojson.Feature) {
+
+	var names []string
+	for k := range f.Properties {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	for _, k := range names {
+		if _, ok := e.keys[k]; !ok {
+			e.keys[k] = uint64(len(e.names))
+			e.names = append(e.names, k)
+		}
+	}
+	if f.Geometry != nil {
+		if d := maxDimensions(f.Geometry); d > e.dims {
+			e.dims = d
+		}
+	}
+}
+
+func (e *geobufEncoder) feature(f *geojson.Feature) (pbWriter, error) {
+
+	var w pbWriter
+
+	// Geometry is required; a nil one is written as an empty collection
+	geometry := f.Geometry
+	if geometry == nil {
+		geometry = geojson.NewCollectionGeometry()
+	}
+	g, err := e.geometry(geometry)
+	if err != nil {
+		return nil, err
+	}
+	w.message(1, g)
+
+	switch id := f.ID.(type) {
+	case nil:
+	case string:
+		w.string(11, id)
+	case float64:
+		if id == math.Trunc(id) {
+			w.svarint(12, int64(id))
+		} else {
+			w.string(11, fmt.Sprint(id))
+		}
+	default:
+		w.string(11, fmt.Sprint(id))
+	}
+
+	var names []string
+	for k, v := range f.Properties {
+		if v != nil {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+
+	var indexes []uint64
+	for i, k := range names {
+		value, err := geobufValue(f.Properties[k])
+		if err != nil {
+			return nil, fmt.Errorf("property %q: %v", k, err)
+		}
+		w.message(13, value)
+		indexes = append(indexes, e.keys[k], uint64(i))
+	}
+	w.packedVarints(14, indexes)
+
+	return w, nil
+}
+
+func geobufValue(v interface{}) (pbWriter, error) {
+
+	var w pbWriter
+	switch v := v.(type) {
+	case string:
+		w.string(1, v)
+	case bool:
+		var b uint64
+		if v {
+			b = 1
+		}
+		w.varint(5, b)
+	case float64:
+		switch {
+		case v != math.Trunc(v) || math.IsInf(v, 0) || math.Abs(v) >= 1<<63:
+			w.double(2, v)
+		case v >= 0:
+			w.varint(3, uint64(v))
+		default:
+			w.varint(4, uint64(-v))
+		}
+	case int:
+		if v >= 0 {
+			w.varint(3, uint64(v))
+		} else {
+			w.varint(4, uint64(-v))
+		}
+	default:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		w.string(6, string(b))
+	}
+	return w, nil
+}
+
+func (e *geobufEncoder) geometry(g *geojson.Geometry) (pbWriter, error) {
+
+	kind, ok := geobufTypes[g.Type]
+	if !ok {
+		return nil, fmt.Errorf("cannot write %q as Geobuf", g.Type)
+	}
+
+	var w pbWriter
+	var lengths []uint64
+	var coords []int64
+
+	w.varint(1, kind)
+
+	switch g.Type {
+	case geojson.GeometryPoint:
+		coords = e.line(coords, [][]float64{g.Point}, false)
+	case geojson.GeometryMultiPoint:
+		coords = e.line(coords, g.MultiPoint, false)
+	case geojson.GeometryLineString:
+		coords = e.line(coords, g.LineString, false)
+	case geojson.GeometryMultiLineString, geojson.GeometryPolygon:
+		lines, closed := g.MultiLineString, false
+		if g.Type == geojson.GeometryPolygon {
+			lines, closed = g.Polygon, true
+		}
+		for _, line := range lines {
+			coords = e.line(coords, line, closed)
+			lengths = append(lengths, uint64(pathLength(line, closed)))
+		}
+		if len(lines) == 1 {
+			lengths = nil
+		}
+	case geojson.GeometryMultiPolygon:
+		lengths = append(lengths, uint64(len(g.MultiPolygon)))
+		for _, polygon := range g.MultiPolygon {
+			lengths = append(lengths, uint64(len(polygon)))
+			for _, ring := range polygon {
+				coords = e.line(coords, ring, true)
+				lengths = append(lengths, uint64(pathLength(ring, true)))
+			}
+		}
+		if len(g.MultiPolygon) == 1 && len(g.MultiPolygon[0]) == 1 {
+			lengths = nil
+		}
+	case geojson.GeometryCollection:
+		for _, child := range g.Geometries {
+			c, err := e.geometry(child)
+			if err != nil {
+				return nil, err
+			}
+			w.message(4, c)
+		}
+	}
+
+	w.packedVarints(2, lengths)
+	w.packedSvarints(3, coords)
+	return w, nil
+}
+
+// line - Append a path's coordinates, delta encoded; a ring's closing
+// vertex is left out
+func (e *geobufEncoder) line(coords []int64, path [][]float64, closed bool) []int64 {
+
+	var sum = make([]int64, e.dims)
+	scale := math.Pow10(geobufPrecision)
+
+	for _, pos := range path[:pathLength(path, closed)] {
+		for d := 0; d < e.dims; d++ {
+			var v float64
+			if d < len(pos) {
+				v = pos[d]
+			}
+			n := int64(math.Round(v*scale)) - sum[d]
+			coords = append(coords, n)
+			sum[d] += n
+		}
+	}
+	return coords
+}
+
+func pathLength(path [][]float64, closed bool) int {
+	if closed && len(path) > 0 {
+		return len(path) - 1
+	}
+	return len(path)
+}
+
+// maxDimensions - `dimensions`, descending into collections
+func maxDimensions(g *geojson.Geometry) int {
+	if g.Type != geojson.GeometryCollection {
+		return dimensions(g)
+	}
+	var dims = 2
+	for _, child := range g.Geometries {
+		if d := maxDimensions(child); d > dims {
+			dims = d
+		}
+	}
+	return dims
+}
+
+// pbWriter - Just enough of the protobuf wire format for Geobuf
+type pbWriter []byte
+
+func (w *pbWriter) tag(field int, wireType int) {
+	w.rawVarint(uint64(field)<<3 | uint64(wireType))
+}
+
+func (w *pbWriter) rawVarint(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	*w = append(*w, b[:binary.PutUvarint(b[:], v)]...)
+}
+
+func (w *pbWriter) varint(field int, v uint64) {
+	w.tag(field, 0)
+	w.rawVarint(v)
+}
+
+func (w *pbWriter) svarint(field int, v int64) {
+	w.varint(field, uint64(v<<1^v>>63))
+}
+
+func (w *pbWriter) double(field int, v float64) {
+	w.tag(field, 1)
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], math.Float64bits(v))
+	*w = append(*w, b[:]...)
+}
+
+func (w *pbWriter) bytes(field int, b []byte) {
+	w.tag(field, 2)
+	w.rawVarint(uint64(len(b)))
+	*w = append(*w, b...)
+}
+
+func (w *pbWriter) string(field int, s string) {
+	w.bytes(field, []byte(s))
+}
+
+func (w *pbWriter) message(field int, m pbWriter) {
+	w.bytes(field, m)
+}
+
+// packedVarints - Nothing is written for an empty list
+func (w *pbWriter) packedVarints(field int, vs []uint64) {
+	if len(vs) == 0 {
+		return
+	}
+	var packed pbWriter
+	for _, v := range vs {
+		packed.rawVarint(v)
+	}
+	w.bytes(field, packed)
+}
+
+func (w *pbWriter) packedSvarints(field int, vs []int64) {
+	var zigzag = make([]uint64, len(vs))
+	for i, v := range vs {
+		zigzag[i] = uint64(v<<1 ^ v>>63)
+	}
+	w.packedVarints(field, zigzag)
+}
@@ -0,0 +1,370 @@
+package formats
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"testing"
+
+	geojson "github.com/paulmach/go.geojson"
+)
+
+// pbField - One protobuf field; `varint` also holds a fixed64's bits
+type pbField struct {
+	number int
+	varint uint64
+	bytes  []byte
+}
+
+// readProtobuf - The fields of a message, in order
+func readProtobuf(t *testing.T, b []byte) []pbField {
+
+	t.Helper()
+
+	var fields []pbField
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			t.Fatalf("bad field key at % x", b)
+		}
+		b = b[n:]
+
+		f := pbField{number: int(key >> 3)}
+		switch key & 7 {
+		case 0:
+			if f.varint, n = binary.Uvarint(b); n <= 0 {
+				t.Fatalf("field %d: bad varint", f.number)
+			}
+			b = b[n:]
+		case 1:
+			if len(b) < 8 {
+				t.Fatalf("field %d: short fixed64", f.number)
+			}
+			f.varint, b = binary.LittleEndian.Uint64(b), b[8:]
+		case 2:
+			size, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < size {
+				t.Fatalf("field %d: bad length", f.number)
+			}
+			f.bytes, b = b[n:n+int(size)], b[n+int(size):]
+		default:
+			t.Fatalf("field %d: unexpected wire type %d", f.number, key&7)
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+func readPackedVarints(t *testing.T, b []byte) []uint64 {
+	t.Helper()
+	var vs []uint64
+	for len(b) > 0 {
+		v, n := binary.Uvarint(b)
+		if n <= 0 {
+			t.Fatalf("bad packed varint")
+		}
+		vs, b = append(vs, v), b[n:]
+	}
+	return vs
+}
+
+func unzigzag(v uint64) int64 {
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+// geobufDecoder - Reads Geobuf back into GeoJSON, following geobuf.proto
+// and the reference decoder
+type geobufDecoder struct {
+	t     *testing.T
+	keys  []string
+	dims  int
+	scale float64
+}
+
+// decodeGeobuf - The features of a `FeatureCollection` or `Feature`
+func decodeGeobuf(t *testing.T, b []byte) []*geojson.Feature {
+
+	d := geobufDecoder{t: t, dims: 2, scale: 1e6}
+	fields := readProtobuf(t, b)
+	for _, f := range fields {
+		switch f.number {
+		case 1:
+			d.keys = append(d.keys, string(f.bytes))
+		case 2:
+			d.dims = int(f.varint)
+		case 3:
+			d.scale = math.Pow10(int(f.varint))
+		}
+	}
+
+	var features []*geojson.Feature
+	for _, f := range fields {
+		switch f.number {
+		case 4:
+			for _, c := range readProtobuf(t, f.bytes) {
+				if c.number == 1 {
+					features = append(features, d.feature(c.bytes))
+				}
+			}
+		case 5:
+			features = append(features, d.feature(f.bytes))
+		}
+	}
+	return features
+}
+
+func (d *geobufDecoder) feature(b []byte) *geojson.Feature {
+
+	var f = geojson.NewFeature(nil)
+	var values []interface{}
+	var indexes []uint64
+
+	for _, field := range readProtobuf(d.t, b) {
+		switch field.number {
+		case 1:
+			f.Geometry = d.geometry(field.bytes)
+		case 11:
+			f.ID = string(field.bytes)
+		case 12:
+			f.ID = float64(unzigzag(field.varint))
+		case 13:
+			values = append(values, d.value(field.bytes))
+		case 14:
+			indexes = append(indexes, readPackedVarints(d.t, field.bytes)...)
+		}
+	}
+
+	for i := 0; i+1 < len(indexes); i += 2 {
+		if indexes[i] >= uint64(len(d.keys)) || indexes[i+1] >= uint64(len(values)) {
+			d.t.Fatalf("property index out of range: %v", indexes)
+		}
+		f.Properties[d.keys[indexes[i]]] = values[indexes[i+1]]
+	}
+	return f
+}
+
+func (d *geobufDecoder) value(b []byte) interface{} {
+	for _, field := range readProtobuf(d.t, b) {
+		switch field.number {
+		case 1:
+			return string(field.bytes)
+		case 2:
+			return math.Float64frombits(field.varint)
+		case 3:
+			return float64(field.varint)
+		case 4:
+			return -float64(field.varint)
+		case 5:
+			return field.varint != 0
+		case 6:
+			var v interface{}
+			if err := json.Unmarshal(field.bytes, &v); err != nil {
+				d.t.Fatalf("json_value: %v", err)
+			}
+			return v
+		}
+	}
+	d.t.Fatalf("empty value")
+	return nil
+}
+
+func (d *geobufDecoder) geometry(b []byte) *geojson.Geometry {
+
+	var kind uint64
+	var lengths []uint64
+	var coords []int64
+	var children []*geojson.Geometry
+
+	for _, field := range readProtobuf(d.t, b) {
+		switch field.number {
+		case 1:
+			kind = field.varint
+		case 2:
+			lengths = readPackedVarints(d.t, field.bytes)
+		case 3:
+			for _, v := range readPackedVarints(d.t, field.bytes) {
+				coords = append(coords, unzigzag(v))
+			}
+		case 4:
+			children = append(children, d.geometry(field.bytes))
+		}
+	}
+
+	// line - `n` positions off the front of `coords`, delta decoded; a
+	// ring gets its closing vertex back
+	line := func(n int, closed bool) [][]float64 {
+		if n*d.dims > len(coords) {
+			d.t.Fatalf("%d positions, %d coordinates left", n, len(coords))
+		}
+		var path [][]float64
+		var sum = make([]int64, d.dims)
+		for i := 0; i < n; i++ {
+			pos := make([]float64, d.dims)
+			for j := range pos {
+				sum[j] += coords[j]
+				pos[j] = float64(sum[j]) / d.scale
+			}
+			coords = coords[d.dims:]
+			path = append(path, pos)
+		}
+		if closed && len(path) > 0 {
+			path = append(path, append([]float64(nil), path[0]...))
+		}
+		return path
+	}
+	lines := func(closed bool) [][][]float64 {
+		if len(lengths) == 0 {
+			return [][][]float64{line(len(coords)/d.dims, closed)}
+		}
+		var paths [][][]float64
+		for _, n := range lengths {
+			paths = append(paths, line(int(n), closed))
+		}
+		return paths
+	}
+
+	switch kind {
+	case 0:
+		return geojson.NewPointGeometry(line(1, false)[0])
+	case 1:
+		return geojson.NewMultiPointGeometry(line(len(coords)/d.dims, false)...)
+	case 2:
+		return geojson.NewLineStringGeometry(line(len(coords)/d.dims, false))
+	case 3:
+		return geojson.NewMultiLineStringGeometry(lines(false)...)
+	case 4:
+		return geojson.NewPolygonGeometry(lines(true))
+	case 5:
+		if len(lengths) == 0 {
+			return geojson.NewMultiPolygonGeometry([][][]float64{line(len(coords)/d.dims, true)})
+		}
+		var polygons [][][][]float64
+		rest := lengths[1:]
+		for p := uint64(0); p < lengths[0]; p++ {
+			var rings [][][]float64
+			n := rest[0]
+			for _, size := range rest[1 : 1+n] {
+				rings = append(rings, line(int(size), true))
+			}
+			rest = rest[1+n:]
+			polygons = append(polygons, rings)
+		}
+		return geojson.NewMultiPolygonGeometry(polygons...)
+	case 6:
+		return geojson.NewCollectionGeometry(children...)
+	}
+	d.t.Fatalf("unknown geometry type %d", kind)
+	return nil
+}
+
+// sameFeatures - Compare geometry, properties and ID as GeoJSON
+func sameFeatures(t *testing.T, got []*geojson.Feature, want []*geojson.Feature) {
+
+	t.Helper()
+
+	if len(got) != len(want) {
+		t.Fatalf("%d features, want %d", len(got), len(want))
+	}
+	for i := range want {
+		g, err := json.Marshal(got[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		w, err := json.Marshal(want[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(g, w) {
+			t.Errorf("feature %d:\n got %s\nwant %s", i, g, w)
+		}
+	}
+}
+
+const geobufRoundTrip = `{"type": "FeatureCollection", "features": [
+	{"type": "Feature", "id": 7, "geometry": {"type": "Point", "coordinates": [151.209295, -33.86882]},
+		"properties": {"name": "Sydney", "population": 5312163, "depth": -3, "ratio": 0.25, "capital": false, "tags": {"state": "NSW", "codes": [1, 2]}}},
+	{"type": "Feature", "id": "a", "geometry": {"type": "LineString", "coordinates": [[0, 0], [1.5, -1.5], [3, 0]]},
+		"properties": {"name": "line"}},
+	{"type": "Feature", "geometry": {"type": "Polygon", "coordinates": [
+		[[0, 0], [10, 0], [10, 10], [0, 10], [0, 0]],
+		[[2, 2], [2, 4], [4, 4], [2, 2]]]}, "properties": {}},
+	{"type": "Feature", "geometry": {"type": "MultiPolygon", "coordinates": [
+		[[[0, 0], [1, 0], [1, 1], [0, 0]]],
+		[[[5, 5], [6, 5], [6, 6], [5, 5]], [[5.2, 5.1], [5.8, 5.1], [5.8, 5.7], [5.2, 5.1]]]]}, "properties": {}},
+	{"type": "Feature", "geometry": {"type": "MultiPolygon", "coordinates": [[[[0, 0], [1, 0], [1, 1], [0, 0]]]]}, "properties": {}},
+	{"type": "Feature", "geometry": {"type": "MultiLineString", "coordinates": [[[0, 0], [1, 1]], [[2, 2], [3, 3], [4, 2]]]}, "properties": {}},
+	{"type": "Feature", "geometry": {"type": "MultiPoint", "coordinates": [[0, 0], [-1, -1]]}, "properties": {}},
+	{"type": "Feature", "geometry": {"type": "GeometryCollection", "geometries": [
+		{"type": "Point", "coordinates": [1, 2]},
+		{"type": "LineString", "coordinates": [[1, 2], [3, 4]]}]}, "properties": {"name": "mixed"}}
+]}`
+
+func TestGeobufRoundTrip(t *testing.T) {
+
+	fc, err := geojson.UnmarshalFeatureCollection([]byte(geobufRoundTrip))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := MarshalGeobuf(fc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sameFeatures(t, decodeGeobuf(t, b), fc.Features)
+
+	for i, f := range fc.Features {
+		b, err := MarshalGeobufFeature(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := decodeGeobuf(t, b)
+		if len(got) != 1 {
+			t.Fatalf("feature %d: decoded %d features", i, len(got))
+		}
+		sameFeatures(t, got, fc.Features[i:i+1])
+	}
+}
+
+// Coordinates are kept to 6 decimal places, and a third dimension is
+// written when any position has one
+func TestGeobufPrecisionAndDimensions(t *testing.T) {
+
+	f := geojson.NewLineStringFeature([][]float64{{1.23456789, -2.0000004, 100}, {1, 2, 0.5}})
+	b, err := MarshalGeobufFeature(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := geojson.NewLineStringFeature([][]float64{{1.234568, -2, 100}, {1, 2, 0.5}})
+	sameFeatures(t, decodeGeobuf(t, b), []*geojson.Feature{want})
+}
+
+// A point with one property, worked out by hand from geobuf.proto:
+// keys, then the Feature (data field 5) holding its geometry, the value
+// and the key/value index pair
+func TestGeobufFixture(t *testing.T) {
+
+	f := geojson.NewPointFeature([]float64{1, 2})
+	f.Properties["a"] = "b"
+
+	b, err := MarshalGeobufFeature(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []byte{
+		0x0a, 0x01, 'a', // keys: "a"
+		0x2a, 0x16, // feature, 22 bytes
+		0x0a, 0x0b, // geometry, 11 bytes
+		0x08, 0x00, // type: Point
+		0x1a, 0x07, // coords, packed sint64
+		0x80, 0x89, 0x7a, // 1000000 (1.0)
+		0x80, 0x92, 0xf4, 0x01, // 2000000 (2.0)
+		0x6a, 0x03, 0x0a, 0x01, 'b', // values: string_value "b"
+		0x72, 0x02, 0x00, 0x00, // properties: key 0, value 0
+	}
+	if !bytes.Equal(b, want) {
+		t.Errorf("got  % x\nwant % x", b, want)
+	}
+}
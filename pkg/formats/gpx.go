@@ -0,0 +1,172 @@
+// Package formats -
+package formats
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"time"
+
+	geojson "github.com/paulmach/go.geojson"
+)
+
+/*
+NOTES:
+	- Tracks become LineStrings (MultiLineStrings when they have more than
+	one segment), routes LineStrings and waypoints Points. Each feature's
+	`gpx` property is "trk", "rte" or "wpt".
+	- Timestamps are kept as M values: a path with any <time> has
+	[lon, lat, ele, m] positions, m in Unix seconds (0 for points without
+	a time), ele 0 when missing. Otherwise positions are [lon, lat, ele] if
+	any point has an elevation, else [lon, lat]. A track is decided as a
+	whole, so all of its segments have the same dimension.
+*/
+
+type gpxFile struct {
+	Waypoints []gpxPoint `xml:"wpt"`
+	Routes    []gpxPath  `xml:"rte"`
+	Tracks    []gpxTrack `xml:"trk"`
+}
+
+type gpxPoint struct {
+	Lat       float64  `xml:"lat,attr"`
+	Lon       float64  `xml:"lon,attr"`
+	Elevation *float64 `xml:"ele"`
+	Time      string   `xml:"time"`
+	Name      string   `xml:"name"`
+}
+
+// gpxPath - A route, or one track segment
+type gpxPath struct {
+	Name        string     `xml:"name"`
+	Description string     `xml:"desc"`
+	Type        string     `xml:"type"`
+	RoutePoints []gpxPoint `xml:"rtept"`
+	TrackPoints []gpxPoint `xml:"trkpt"`
+}
+
+type gpxTrack struct {
+	Name        string    `xml:"name"`
+	Description string    `xml:"desc"`
+	Type        string    `xml:"type"`
+	Segments    []gpxPath `xml:"trkseg"`
+}
+
+// ReadGPX - Decode the tracks, routes and waypoints of a GPX 1.0/1.1 file
+func ReadGPX(b []byte) (*geojson.FeatureCollection, error) {
+
+	var doc gpxFile
+	if err := xml.Unmarshal(b, &doc); err != nil {
+		return nil, fmt.Errorf("formats: bad GPX: %v", err)
+	}
+
+	fc := geojson.NewFeatureCollection()
+
+	for i, trk := range doc.Tracks {
+		var points []gpxPoint
+		for _, seg := range trk.Segments {
+			points = append(points, seg.TrackPoints...)
+		}
+		hasEle, hasTime := gpxDimensions(points)
+
+		var lines [][][]float64
+		for _, seg := range trk.Segments {
+			line, err := gpxPositions(seg.TrackPoints, hasEle, hasTime)
+			if err != nil {
+				return nil, fmt.Errorf("formats: GPX track %d: %v", i, err)
+			}
+			if len(line) > 0 {
+				lines = append(lines, line)
+			}
+		}
+
+		var geom *geojson.Geometry
+		switch len(lines) {
+		case 0:
+		case 1:
+			geom = geojson.NewLineStringGeometry(lines[0])
+		default:
+			geom = geojson.NewMultiLineStringGeometry(lines...)
+		}
+		fc.AddFeature(gpxFeature(geom, "trk", trk.Name, trk.Description, trk.Type))
+	}
+
+	for i, rte := range doc.Routes {
+		hasEle, hasTime := gpxDimensions(rte.RoutePoints)
+		line, err := gpxPositions(rte.RoutePoints, hasEle, hasTime)
+		if err != nil {
+			return nil, fmt.Errorf("formats: GPX route %d: %v", i, err)
+		}
+
+		var geom *geojson.Geometry
+		if len(line) > 0 {
+			geom = geojson.NewLineStringGeometry(line)
+		}
+		fc.AddFeature(gpxFeature(geom, "rte", rte.Name, rte.Description, rte.Type))
+	}
+
+	for i, wpt := range doc.Waypoints {
+		hasEle, hasTime := gpxDimensions([]gpxPoint{wpt})
+		positions, err := gpxPositions([]gpxPoint{wpt}, hasEle, hasTime)
+		if err != nil {
+			return nil, fmt.Errorf("formats: GPX waypoint %d: %v", i, err)
+		}
+		fc.AddFeature(gpxFeature(geojson.NewPointGeometry(positions[0]), "wpt", wpt.Name, "", ""))
+	}
+
+	return fc, nil
+}
+
+func gpxFeature(geom *geojson.Geometry, kind, name, description, typ string) *geojson.Feature {
+
+	f := geojson.NewFeature(geom)
+	f.Properties["gpx"] = kind
+	for k, v := range map[string]string{"name": name, "description": description, "type": typ} {
+		if v = strings.TrimSpace(v); v != "" {
+			f.Properties[k] = v
+		}
+	}
+	return f
+}
+
+// gpxDimensions - Whether any of the points has an elevation, and a time
+func gpxDimensions(points []gpxPoint) (hasEle bool, hasTime bool) {
+	for _, p := range points {
+		hasEle = hasEle || p.Elevation != nil
+		hasTime = hasTime || strings.TrimSpace(p.Time) != ""
+	}
+	return hasEle, hasTime
+}
+
+// gpxPositions - Positions for a path, every one with an elevation if
+// hasEle or hasTime, and an M value if hasTime (see NOTES)
+func gpxPositions(points []gpxPoint, hasEle bool, hasTime bool) ([][]float64, error) {
+
+	var positions = make([][]float64, len(points))
+	for i, p := range points {
+
+		pos := []float64{p.Lon, p.Lat}
+		if hasEle || hasTime {
+			var ele float64
+			if p.Elevation != nil {
+				ele = *p.Elevation
+			}
+			pos = append(pos, ele)
+		}
+
+		if hasTime {
+			var m float64
+			if s := strings.TrimSpace(p.Time); s != "" {
+				t, err := time.Parse(time.RFC3339Nano, s)
+				if err != nil {
+					return nil, fmt.Errorf("bad time %q", s)
+				}
+				m = float64(t.UnixNano()) / 1e9
+			}
+			pos = append(pos, m)
+		}
+
+		positions[i] = pos
+	}
+	return positions, nil
+}
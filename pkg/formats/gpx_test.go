@@ -0,0 +1,36 @@
+package formats
+
+import (
+	"reflect"
+	"testing"
+)
+
+// Only the second segment has elevations and times, but both segments of
+// the MultiLineString need the same dimension
+func TestReadGPXTrackDimensions(t *testing.T) {
+
+	fc, err := ReadGPX([]byte(`<gpx version="1.1">
+		<trk><name>Mixed</name>
+			<trkseg><trkpt lat="1" lon="2"/><trkpt lat="3" lon="4"/></trkseg>
+			<trkseg>
+				<trkpt lat="5" lon="6"><ele>10</ele><time>1970-01-01T00:01:00Z</time></trkpt>
+				<trkpt lat="7" lon="8"><ele>11</ele></trkpt>
+			</trkseg>
+		</trk>
+		<rte><rtept lat="1" lon="2"/><rtept lat="3" lon="4"><ele>5</ele></rtept></rte>
+	</gpx>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := [][][]float64{
+		{{2, 1, 0, 0}, {4, 3, 0, 0}},
+		{{6, 5, 10, 60}, {8, 7, 11, 0}},
+	}
+	if got := fc.Features[0].Geometry.MultiLineString; !reflect.DeepEqual(got, want) {
+		t.Errorf("track = %v, want %v", got, want)
+	}
+	if got, want := fc.Features[1].Geometry.LineString, [][]float64{{2, 1, 0}, {4, 3, 5}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("route = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,304 @@
+// Package formats -
+package formats
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	geojson "github.com/paulmach/go.geojson"
+)
+
+/*
+NOTES:
+	- Placemarks are read from any depth of Document/Folder nesting. Styles,
+	overlays, gx:Track and NetworkLinks are ignored.
+	- Properties are the Placemark's `name` and `description` (if set)
+	plus its ExtendedData (both `Data` and `SchemaData`), all as strings.
+	- KML doesn't fix ring orientation; polygons are rewound to GeoJSON's
+	right-hand rule (outer counterclockwise, holes clockwise).
+*/
+
+// kmlContainer - <kml>, <Document> or <Folder>
+type kmlContainer struct {
+	Documents  []kmlContainer `xml:"Document"`
+	Folders    []kmlContainer `xml:"Folder"`
+	Placemarks []kmlPlacemark `xml:"Placemark"`
+}
+
+// kmlGeometries - Every geometry element directly under a Placemark or
+// MultiGeometry
+type kmlGeometries struct {
+	Points          []kmlCoordinates `xml:"Point"`
+	LineStrings     []kmlCoordinates `xml:"LineString"`
+	LinearRings     []kmlCoordinates `xml:"LinearRing"`
+	Polygons        []kmlPolygon     `xml:"Polygon"`
+	MultiGeometries []kmlGeometries  `xml:"MultiGeometry"`
+}
+
+type kmlPlacemark struct {
+	Name         string          `xml:"name"`
+	Description  string          `xml:"description"`
+	ExtendedData kmlExtendedData `xml:"ExtendedData"`
+	kmlGeometries
+}
+
+type kmlCoordinates struct {
+	Coordinates string `xml:"coordinates"`
+}
+
+type kmlPolygon struct {
+	Outer kmlCoordinates   `xml:"outerBoundaryIs>LinearRing"`
+	Inner []kmlCoordinates `xml:"innerBoundaryIs>LinearRing"`
+}
+
+type kmlExtendedData struct {
+	Data []struct {
+		Name  string `xml:"name,attr"`
+		Value string `xml:"value"`
+	} `xml:"Data"`
+	SchemaData []struct {
+		SimpleData []struct {
+			Name  string `xml:"name,attr"`
+			Value string `xml:",chardata"`
+		} `xml:"SimpleData"`
+	} `xml:"SchemaData"`
+}
+
+// ReadKML - Decode every Placemark in a KML document; Placemarks without
+// a geometry get a nil geometry
+func ReadKML(b []byte) (*geojson.FeatureCollection, error) {
+
+	var root kmlContainer
+	if err := xml.Unmarshal(b, &root); err != nil {
+		return nil, fmt.Errorf("formats: bad KML: %v", err)
+	}
+
+	fc := geojson.NewFeatureCollection()
+	if err := root.features(fc); err != nil {
+		return nil, err
+	}
+	return fc, nil
+}
+
+// ReadKMZ - Decode the KML document in a KMZ archive: `doc.kml` if there
+// is one, otherwise the first .kml file
+func ReadKMZ(b []byte) (*geojson.FeatureCollection, error) {
+
+	archive, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		return nil, err
+	}
+
+	var doc *zip.File
+	for _, f := range archive.File {
+		if !strings.EqualFold(path.Ext(f.Name), ".kml") {
+			continue
+		}
+		if doc == nil || strings.EqualFold(path.Base(f.Name), "doc.kml") {
+			doc = f
+		}
+	}
+	if doc == nil {
+		return nil, fmt.Errorf("formats: no .kml in KMZ")
+	}
+
+	r, err := doc.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	content, err := readLimited(r, MaxDecompressedSize)
+	if err != nil {
+		return nil, err
+	}
+	return ReadKML(content)
+}
+
+func (c *kmlContainer) features(fc *geojson.FeatureCollection) error {
+
+	for _, p := range c.Placemarks {
+		geom, err := p.geometry()
+		if err != nil {
+			return fmt.Errorf("formats: KML placemark %q: %v", p.Name, err)
+		}
+
+		f := geojson.NewFeature(geom)
+		if name := strings.TrimSpace(p.Name); name != "" {
+			f.Properties["name"] = name
+		}
+		if description := strings.TrimSpace(p.Description); description != "" {
+			f.Properties["description"] = description
+		}
+		for _, d := range p.ExtendedData.Data {
+			f.Properties[d.Name] = strings.TrimSpace(d.Value)
+		}
+		for _, s := range p.ExtendedData.SchemaData {
+			for _, d := range s.SimpleData {
+				f.Properties[d.Name] = strings.TrimSpace(d.Value)
+			}
+		}
+		fc.AddFeature(f)
+	}
+
+	for _, children := range [][]kmlContainer{c.Documents, c.Folders} {
+		for i := range children {
+			if err := children[i].features(fc); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// geometry - A single geometry as-is; several are merged into a Multi*
+// geometry when they're all the same kind, a GeometryCollection if not.
+// nil if there are none.
+func (g *kmlGeometries) geometry() (*geojson.Geometry, error) {
+
+	var geometries []*geojson.Geometry
+
+	for _, c := range g.Points {
+		positions, err := parseKMLCoordinates(c.Coordinates)
+		if err != nil {
+			return nil, err
+		}
+		if len(positions) != 1 {
+			return nil, fmt.Errorf("point with %d positions", len(positions))
+		}
+		geometries = append(geometries, geojson.NewPointGeometry(positions[0]))
+	}
+
+	for _, c := range g.LineStrings {
+		positions, err := parseKMLCoordinates(c.Coordinates)
+		if err != nil {
+			return nil, err
+		}
+		geometries = append(geometries, geojson.NewLineStringGeometry(positions))
+	}
+
+	// A bare LinearRing is a polygon without holes
+	for _, c := range g.LinearRings {
+		polygon, err := kmlPolygon{Outer: c}.rings()
+		if err != nil {
+			return nil, err
+		}
+		geometries = append(geometries, geojson.NewPolygonGeometry(polygon))
+	}
+
+	for _, p := range g.Polygons {
+		polygon, err := p.rings()
+		if err != nil {
+			return nil, err
+		}
+		geometries = append(geometries, geojson.NewPolygonGeometry(polygon))
+	}
+
+	for i := range g.MultiGeometries {
+		geom, err := g.MultiGeometries[i].geometry()
+		if err != nil {
+			return nil, err
+		}
+		if geom != nil {
+			geometries = append(geometries, geom)
+		}
+	}
+
+	switch len(geometries) {
+	case 0:
+		return nil, nil
+	case 1:
+		return geometries[0], nil
+	default:
+		return mergeGeometries(geometries), nil
+	}
+}
+
+// rings - Outer ring counterclockwise, holes clockwise
+func (p kmlPolygon) rings() ([][][]float64, error) {
+
+	outer, err := parseKMLCoordinates(p.Outer.Coordinates)
+	if err != nil {
+		return nil, err
+	}
+	if signedArea(outer) < 0 {
+		outer = reversed(outer)
+	}
+
+	var rings = [][][]float64{outer}
+	for _, c := range p.Inner {
+		hole, err := parseKMLCoordinates(c.Coordinates)
+		if err != nil {
+			return nil, err
+		}
+		if signedArea(hole) > 0 {
+			hole = reversed(hole)
+		}
+		rings = append(rings, hole)
+	}
+	return rings, nil
+}
+
+// parseKMLCoordinates - Whitespace separated "lon,lat[,alt]" tuples
+func parseKMLCoordinates(s string) ([][]float64, error) {
+
+	var positions [][]float64
+	for _, tuple := range strings.Fields(s) {
+		values := strings.Split(tuple, ",")
+		if len(values) < 2 || len(values) > 3 {
+			return nil, fmt.Errorf("bad coordinate %q", tuple)
+		}
+
+		var pos = make([]float64, len(values))
+		for i, v := range values {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return nil, fmt.Errorf("bad coordinate %q", tuple)
+			}
+			pos[i] = f
+		}
+		positions = append(positions, pos)
+	}
+	return positions, nil
+}
+
+// mergeGeometries - Points into a MultiPoint, lines into a
+// MultiLineString, polygons into a MultiPolygon; anything mixed (or
+// already multi) into a GeometryCollection
+func mergeGeometries(geometries []*geojson.Geometry) *geojson.Geometry {
+
+	kind := geometries[0].Type
+	for _, g := range geometries[1:] {
+		if g.Type != kind {
+			return geojson.NewCollectionGeometry(geometries...)
+		}
+	}
+
+	switch kind {
+	case geojson.GeometryPoint:
+		var points [][]float64
+		for _, g := range geometries {
+			points = append(points, g.Point)
+		}
+		return geojson.NewMultiPointGeometry(points...)
+	case geojson.GeometryLineString:
+		var lines [][][]float64
+		for _, g := range geometries {
+			lines = append(lines, g.LineString)
+		}
+		return geojson.NewMultiLineStringGeometry(lines...)
+	case geojson.GeometryPolygon:
+		var polygons [][][][]float64
+		for _, g := range geometries {
+			polygons = append(polygons, g.Polygon)
+		}
+		return geojson.NewMultiPolygonGeometry(polygons...)
+	default:
+		return geojson.NewCollectionGeometry(geometries...)
+	}
+}
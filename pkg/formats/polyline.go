@@ -0,0 +1,294 @@
+// Package formats -
+package formats
+
+import (
+	"aws-lambda-viswal/pkg/viswal"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	geojson "github.com/paulmach/go.geojson"
+)
+
+/*
+NOTES:
+	- Google's encoded polyline: each value is rounded to `precision`
+	decimal places, delta'd against the previous vertex, zigzag encoded
+	and written 5 bits per character (+63, 0x20 as continuation). Values
+	are written lat, lng. Polylines are 2D; Z and M are dropped.
+	- The progressive variant writes each vertex as (index, lat, lng), all
+	three as deltas against the previously written vertex, in descending
+	Visvalingam-Whyatt importance: endpoints first, then the vertex
+	dropped last, and so on. Any prefix of whole vertices, sorted by index,
+	is the path simplified to that many vertices; at least 2 for a line, 4
+	(the closing vertex is kept) for a ring.
+*/
+
+// DefaultPolylinePrecision - Google's 5 decimal places (~1m)
+const DefaultPolylinePrecision = 5
+
+// EncodePolyline - A path as a Google encoded polyline
+func EncodePolyline(path [][]float64, precision int) string {
+
+	var b strings.Builder
+	var lat, lng int64
+
+	scale := math.Pow10(precision)
+	for _, pos := range path {
+		y, x := int64(math.Round(pos[1]*scale)), int64(math.Round(pos[0]*scale))
+		writePolylineValue(&b, y-lat)
+		writePolylineValue(&b, x-lng)
+		lat, lng = y, x
+	}
+	return b.String()
+}
+
+// DecodePolyline - The [lng, lat] path of a Google encoded polyline
+func DecodePolyline(s string, precision int) ([][]float64, error) {
+
+	var path [][]float64
+	var lat, lng int64
+
+	scale := math.Pow10(precision)
+	r := polylineReader{s: s}
+	for !r.done() {
+		dlat, dlng := r.value(), r.value()
+		if r.err != nil {
+			return nil, r.err
+		}
+		lat, lng = lat+dlat, lng+dlng
+		path = append(path, []float64{float64(lng) / scale, float64(lat) / scale})
+	}
+	return path, nil
+}
+
+// EncodeProgressivePolyline - A path as a progressive polyline (see
+// NOTES), most important vertices first
+func EncodeProgressivePolyline(path [][]float64, precision int) string {
+
+	order, area := viswal.RankPath(path)
+
+	// Endpoints (order 0) first, then latest dropped first
+	var indices = make([]int, len(path))
+	for i := range indices {
+		indices[i] = i
+	}
+	sort.SliceStable(indices, func(a, b int) bool {
+		i, j := indices[a], indices[b]
+		if (order[i] == 0) != (order[j] == 0) {
+			return order[i] == 0
+		}
+		if order[i] != order[j] {
+			return order[i] > order[j]
+		}
+		return area[i] > area[j]
+	})
+
+	var b strings.Builder
+	var index, lat, lng int64
+
+	scale := math.Pow10(precision)
+	for _, i := range indices {
+		pos := path[i]
+		y, x := int64(math.Round(pos[1]*scale)), int64(math.Round(pos[0]*scale))
+		writePolylineValue(&b, int64(i)-index)
+		writePolylineValue(&b, y-lat)
+		writePolylineValue(&b, x-lng)
+		index, lat, lng = int64(i), y, x
+	}
+	return b.String()
+}
+
+// DecodeProgressivePolyline - The [lng, lat] path of a (possibly
+// truncated) progressive polyline, in index order. A vertex cut off
+// part way is dropped.
+func DecodeProgressivePolyline(s string, precision int) ([][]float64, error) {
+
+	type vertex struct {
+		index int64
+		pos   []float64
+	}
+
+	var vertices []vertex
+	var index, lat, lng int64
+
+	scale := math.Pow10(precision)
+	r := polylineReader{s: s}
+	for !r.done() {
+		di, dlat, dlng := r.value(), r.value(), r.value()
+		if r.err == errPolylineTruncated {
+			break
+		}
+		if r.err != nil {
+			return nil, r.err
+		}
+		index, lat, lng = index+di, lat+dlat, lng+dlng
+		vertices = append(vertices, vertex{index, []float64{float64(lng) / scale, float64(lat) / scale}})
+	}
+
+	sort.Slice(vertices, func(a, b int) bool { return vertices[a].index < vertices[b].index })
+
+	var path = make([][]float64, len(vertices))
+	for i, v := range vertices {
+		path[i] = v.pos
+	}
+	return path, nil
+}
+
+func writePolylineValue(b *strings.Builder, v int64) {
+	u := uint64(v << 1)
+	if v < 0 {
+		u = ^u
+	}
+	for u >= 0x20 {
+		b.WriteByte(byte(0x20|u&0x1f) + 63)
+		u >>= 5
+	}
+	b.WriteByte(byte(u) + 63)
+}
+
+var errPolylineTruncated = fmt.Errorf("formats: truncated polyline")
+
+type polylineReader struct {
+	s   string
+	off int
+	err error
+}
+
+func (r *polylineReader) done() bool {
+	return r.off >= len(r.s)
+}
+
+func (r *polylineReader) value() int64 {
+
+	var u uint64
+	for shift := uint(0); r.err == nil; shift += 5 {
+		if r.done() {
+			r.err = errPolylineTruncated
+			break
+		}
+		c := r.s[r.off]
+		r.off++
+		if c < 63 || c > 126 || shift > 60 {
+			r.err = fmt.Errorf("formats: bad polyline character %q", c)
+			break
+		}
+		u |= uint64(c-63) & 0x1f << shift
+		if c-63 < 0x20 {
+			break
+		}
+	}
+
+	if u&1 != 0 {
+		return int64(^(u >> 1))
+	}
+	return int64(u >> 1)
+}
+
+// EncodedGeometry - A geometry whose paths are encoded polylines; the
+// nesting of `Coordinates` follows GeoJSON's with each path replaced by
+// a string (a Point or MultiPoint is a single polyline)
+type EncodedGeometry struct {
+	Type        geojson.GeometryType `json:"type"`
+	Coordinates interface{}          `json:"coordinates,omitempty"`
+	Geometries  []*EncodedGeometry   `json:"geometries,omitempty"`
+}
+
+// EncodedFeature -
+type EncodedFeature struct {
+	Type       string                 `json:"type"`
+	Geometry   *EncodedGeometry       `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+// EncodedFeatureCollection - `Encoding` is `OutputPolyline` or
+// `OutputProgressivePolyline`
+type EncodedFeatureCollection struct {
+	Type      string            `json:"type"`
+	Encoding  string            `json:"encoding"`
+	Precision int               `json:"precision"`
+	Features  []*EncodedFeature `json:"features"`
+}
+
+// WritePolylines - A FeatureCollection-shaped JSON document with every
+// path encoded as a polyline, progressive (ranked) or not. Points and
+// MultiPoints have no rank and are always plain polylines.
+func WritePolylines(fc *geojson.FeatureCollection, precision int, progressive bool) ([]byte, error) {
+
+	if precision < 0 || precision > 10 {
+		return nil, fmt.Errorf("formats: polyline precision %d not on [0, 10]", precision)
+	}
+
+	var out = EncodedFeatureCollection{
+		Type:      "FeatureCollection",
+		Encoding:  OutputPolyline,
+		Precision: precision,
+		Features:  make([]*EncodedFeature, len(fc.Features)),
+	}
+	if progressive {
+		out.Encoding = OutputProgressivePolyline
+	}
+
+	encode := EncodePolyline
+	if progressive {
+		encode = EncodeProgressivePolyline
+	}
+
+	for i, f := range fc.Features {
+		geom, err := encodeGeometry(f.Geometry, precision, encode)
+		if err != nil {
+			return nil, fmt.Errorf("formats: feature %d: %v", i, err)
+		}
+		out.Features[i] = &EncodedFeature{Type: "Feature", Geometry: geom, Properties: f.Properties}
+	}
+
+	return json.Marshal(out)
+}
+
+func encodeGeometry(g *geojson.Geometry, precision int, encode func([][]float64, int) string) (*EncodedGeometry, error) {
+
+	if g == nil {
+		return nil, nil
+	}
+
+	paths := func(paths [][][]float64) []string {
+		var encoded = make([]string, len(paths))
+		for i, path := range paths {
+			encoded[i] = encode(path, precision)
+		}
+		return encoded
+	}
+
+	var out = &EncodedGeometry{Type: g.Type}
+	switch g.Type {
+	case geojson.GeometryPoint:
+		out.Coordinates = EncodePolyline([][]float64{g.Point}, precision)
+	case geojson.GeometryMultiPoint:
+		out.Coordinates = EncodePolyline(g.MultiPoint, precision)
+	case geojson.GeometryLineString:
+		out.Coordinates = encode(g.LineString, precision)
+	case geojson.GeometryMultiLineString:
+		out.Coordinates = paths(g.MultiLineString)
+	case geojson.GeometryPolygon:
+		out.Coordinates = paths(g.Polygon)
+	case geojson.GeometryMultiPolygon:
+		var polygons = make([][]string, len(g.MultiPolygon))
+		for i, polygon := range g.MultiPolygon {
+			polygons[i] = paths(polygon)
+		}
+		out.Coordinates = polygons
+	case geojson.GeometryCollection:
+		for _, child := range g.Geometries {
+			encoded, err := encodeGeometry(child, precision, encode)
+			if err != nil {
+				return nil, err
+			}
+			out.Geometries = append(out.Geometries, encoded)
+		}
+	default:
+		return nil, fmt.Errorf("cannot encode %q as a polyline", g.Type)
+	}
+	return out, nil
+}
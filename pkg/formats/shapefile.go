@@ -0,0 +1,546 @@
+// Package formats - Readers and writers between `*geojson.Feature` and the
+// other formats sources arrive in (or consumers want back)
+package formats
+
+import (
+	"archive/zip"
+	"aws-lambda-viswal/pkg/crs"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+
+	geojson "github.com/paulmach/go.geojson"
+)
+
+// Shape types from the ESRI Shapefile Technical Description
+const (
+	shpNull        = 0
+	shpPoint       = 1
+	shpPolyLine    = 3
+	shpPolygon     = 5
+	shpMultiPoint  = 8
+	shpPointZ      = 11
+	shpPolyLineZ   = 13
+	shpPolygonZ    = 15
+	shpMultiPointZ = 18
+	shpPointM      = 21
+	shpPolyLineM   = 23
+	shpPolygonM    = 25
+	shpMultiPointM = 28
+)
+
+const shpHeaderLength = 100
+
+// Shapefile - The files of one shapefile layer. Only `SHP` is required;
+// `DBF` supplies properties, `CPG` the DBF's text encoding and `PRJ` the
+// coordinate reference system.
+type Shapefile struct {
+	Name string
+	SHP  []byte
+	SHX  []byte
+	DBF  []byte
+	CPG  []byte
+	PRJ  []byte
+}
+
+// ReadShapefileZip - Read every layer (.shp and its siblings) in a zip
+// archive into one FeatureCollection, reprojected to WGS84 per each
+// layer's .prj
+func ReadShapefileZip(b []byte) (*geojson.FeatureCollection, error) {
+	return readShapefileZip(b, crs.WGS84)
+}
+
+// readShapefileZip - Layers without a .prj are taken to be in `assumed`
+func readShapefileZip(b []byte, assumed *crs.CRS) (*geojson.FeatureCollection, error) {
+
+	layers, err := ShapefilesFromZip(b)
+	if err != nil {
+		return nil, err
+	}
+
+	fc := geojson.NewFeatureCollection()
+	for _, layer := range layers {
+		features, err := layer.Features()
+		if err == nil {
+			var source *crs.CRS
+			if source, err = layer.CRS(); err == nil {
+				if source == nil {
+					source = assumed
+				}
+				err = reproject(features, source)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", layer.Name, err)
+		}
+		fc.Features = append(fc.Features, features...)
+	}
+	return fc, nil
+}
+
+// ShapefilesFromZip - Group the files in a zip archive into layers by
+// base name; layers without a .shp are dropped
+func ShapefilesFromZip(b []byte) ([]*Shapefile, error) {
+
+	r, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		return nil, err
+	}
+
+	var layers = make(map[string]*Shapefile)
+	var order []string
+	var budget = MaxDecompressedSize // For every file together
+
+	for _, f := range r.File {
+
+		// Skip directories and macOS resource forks
+		if f.FileInfo().IsDir() || strings.HasPrefix(f.Name, "__MACOSX/") {
+			continue
+		}
+
+		ext := strings.ToLower(path.Ext(f.Name))
+		base := strings.TrimSuffix(f.Name, path.Ext(f.Name))
+
+		var target *[]byte
+		layer, ok := layers[strings.ToLower(base)]
+		if !ok {
+			layer = &Shapefile{Name: base}
+		}
+
+		switch ext {
+		case ".shp":
+			target = &layer.SHP
+		case ".shx":
+			target = &layer.SHX
+		case ".dbf":
+			target = &layer.DBF
+		case ".cpg":
+			target = &layer.CPG
+		case ".prj":
+			target = &layer.PRJ
+		default:
+			continue
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		*target, err = readLimited(rc, budget)
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f.Name, err)
+		}
+		budget -= int64(len(*target))
+
+		if !ok {
+			layers[strings.ToLower(base)] = layer
+			order = append(order, strings.ToLower(base))
+		}
+	}
+
+	var shapefiles []*Shapefile
+	for _, k := range order {
+		if layers[k].SHP != nil {
+			shapefiles = append(shapefiles, layers[k])
+		}
+	}
+	if len(shapefiles) == 0 {
+		return nil, fmt.Errorf("formats: no .shp file in archive")
+	}
+	return shapefiles, nil
+}
+
+// CRS - The layer's coordinate reference system, from its .prj; nil
+// without one
+func (s *Shapefile) CRS() (*crs.CRS, error) {
+	if len(bytes.TrimSpace(s.PRJ)) == 0 {
+		return nil, nil
+	}
+	return crs.FromPRJ(s.PRJ)
+}
+
+// Features - Decode the layer; feature `i` pairs shape record `i` with
+// DBF record `i`. Records deleted in the DBF are skipped, null shapes
+// become features with a nil geometry.
+func (s *Shapefile) Features() ([]*geojson.Feature, error) {
+
+	geometries, err := readSHP(s.SHP)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []map[string]interface{}
+	var deleted []bool
+	if s.DBF != nil {
+		records, deleted, err = readDBF(s.DBF, decoderFor(string(s.CPG)))
+		if err != nil {
+			return nil, err
+		}
+		if len(records) != len(geometries) {
+			return nil, fmt.Errorf("formats: %d shapes but %d attribute records", len(geometries), len(records))
+		}
+	}
+
+	var features = make([]*geojson.Feature, 0, len(geometries))
+	for i, geom := range geometries {
+		if deleted != nil && deleted[i] {
+			continue
+		}
+
+		f := geojson.NewFeature(geom)
+		if records != nil {
+			f.Properties = records[i]
+		}
+		features = append(features, f)
+	}
+
+	return features, nil
+}
+
+// readSHP - Decode every record of a .shp file
+func readSHP(b []byte) ([]*geojson.Geometry, error) {
+
+	if len(b) < shpHeaderLength || binary.BigEndian.Uint32(b[0:4]) != 9994 {
+		return nil, fmt.Errorf("formats: not a shapefile")
+	}
+
+	// The length in the header is in 16-bit words
+	end := int(binary.BigEndian.Uint32(b[24:28])) * 2
+	if end > len(b) || end < shpHeaderLength {
+		end = len(b)
+	}
+
+	var geometries []*geojson.Geometry
+	for offset := shpHeaderLength; offset+8 <= end; {
+
+		length := int(binary.BigEndian.Uint32(b[offset+4:offset+8])) * 2
+		offset += 8
+		if offset+length > end {
+			return nil, fmt.Errorf("formats: shape record %d truncated", len(geometries)+1)
+		}
+
+		geom, err := readShape(b[offset : offset+length])
+		if err != nil {
+			return nil, fmt.Errorf("formats: shape record %d: %v", len(geometries)+1, err)
+		}
+		geometries = append(geometries, geom)
+		offset += length
+	}
+
+	return geometries, nil
+}
+
+// shpReader - Little endian reads over a record, tracking overruns
+type shpReader struct {
+	b   []byte
+	off int
+	err error
+}
+
+func (r *shpReader) int32() int {
+	if r.off+4 > len(r.b) {
+		r.err = fmt.Errorf("record truncated")
+		return 0
+	}
+	v := int32(binary.LittleEndian.Uint32(r.b[r.off:]))
+	r.off += 4
+	return int(v)
+}
+
+func (r *shpReader) float64() float64 {
+	if r.off+8 > len(r.b) {
+		r.err = fmt.Errorf("record truncated")
+		return 0
+	}
+	v := math.Float64frombits(binary.LittleEndian.Uint64(r.b[r.off:]))
+	r.off += 8
+	return v
+}
+
+func (r *shpReader) skip(n int) {
+	r.off += n
+}
+
+// remaining - Bytes left in the record
+func (r *shpReader) remaining() int {
+	if r.off > len(r.b) {
+		return 0
+	}
+	return len(r.b) - r.off
+}
+
+// readShape - Decode one record's content. Z values become the third
+// coordinate; M values are dropped.
+func readShape(b []byte) (*geojson.Geometry, error) {
+
+	r := &shpReader{b: b}
+	shapeType := r.int32()
+	hasZ := shapeType == shpPointZ || shapeType == shpPolyLineZ || shapeType == shpPolygonZ || shapeType == shpMultiPointZ
+
+	switch shapeType {
+
+	case shpNull:
+		return nil, r.err
+
+	case shpPoint, shpPointZ, shpPointM:
+		p := []float64{r.float64(), r.float64()}
+		if hasZ {
+			p = append(p, r.float64())
+		}
+		return geojson.NewPointGeometry(p), r.err
+
+	case shpMultiPoint, shpMultiPointZ, shpMultiPointM:
+		r.skip(32) // Box
+		n := r.int32()
+		if n < 0 || n*16 > r.remaining() {
+			return nil, fmt.Errorf("bad point count")
+		}
+		points := readPoints(r, n, hasZ)
+		return geojson.NewMultiPointGeometry(points...), r.err
+
+	case shpPolyLine, shpPolyLineZ, shpPolyLineM, shpPolygon, shpPolygonZ, shpPolygonM:
+		r.skip(32) // Box
+		numParts, numPoints := r.int32(), r.int32()
+		if numParts < 0 || numPoints < 0 || numParts*4+numPoints*16 > r.remaining() {
+			return nil, fmt.Errorf("bad part or point count")
+		}
+
+		var starts = make([]int, numParts)
+		for i := range starts {
+			starts[i] = r.int32()
+		}
+		points := readPoints(r, numPoints, hasZ)
+		if r.err != nil {
+			return nil, r.err
+		}
+
+		var parts = make([][][]float64, 0, numParts)
+		for i, start := range starts {
+			stop := numPoints
+			if i+1 < numParts {
+				stop = starts[i+1]
+			}
+			if start < 0 || start > stop || stop > numPoints {
+				return nil, fmt.Errorf("bad part index")
+			}
+			parts = append(parts, points[start:stop])
+		}
+
+		switch shapeType {
+		case shpPolygon, shpPolygonZ, shpPolygonM:
+			return polygonFromRings(parts), nil
+		}
+		if len(parts) == 1 {
+			return geojson.NewLineStringGeometry(parts[0]), nil
+		}
+		return geojson.NewMultiLineStringGeometry(parts...), nil
+
+	default:
+		return nil, fmt.Errorf("unsupported shape type %d", shapeType)
+	}
+}
+
+// readPoints - `n` XY points, followed (for Z types) by the Z range and
+// `n` Z values
+func readPoints(r *shpReader, n int, hasZ bool) [][]float64 {
+
+	var points = make([][]float64, n)
+	for i := range points {
+		points[i] = []float64{r.float64(), r.float64()}
+	}
+
+	if hasZ {
+		r.skip(16) // Z range
+		for i := range points {
+			points[i] = append(points[i], r.float64())
+		}
+	}
+	return points
+}
+
+// polygonFromRings - Shapefile polygons are a flat list of rings:
+// clockwise outer rings and counterclockwise holes. Each hole goes to the
+// first outer ring containing it. Rings are reversed to GeoJSON's
+// right-hand rule (outer counterclockwise).
+func polygonFromRings(rings [][][]float64) *geojson.Geometry {
+
+	var polygons [][][][]float64
+	var holes [][][]float64
+
+	for _, ring := range rings {
+		if len(ring) < 4 {
+			continue
+		}
+		if signedArea(ring) < 0 { // Clockwise
+			polygons = append(polygons, [][][]float64{reversed(ring)})
+		} else {
+			holes = append(holes, reversed(ring))
+		}
+	}
+
+	for _, hole := range holes {
+		var placed bool
+		for i, polygon := range polygons {
+			if pointInRing(hole[0], polygon[0]) {
+				polygons[i] = append(polygons[i], hole)
+				placed = true
+				break
+			}
+		}
+
+		// A hole with no outer ring is (per common practice) an outer ring
+		// that was wound the wrong way
+		if !placed {
+			polygons = append(polygons, [][][]float64{reversed(hole)})
+		}
+	}
+
+	if len(polygons) == 1 {
+		return geojson.NewPolygonGeometry(polygons[0])
+	}
+	return geojson.NewMultiPolygonGeometry(polygons...)
+}
+
+// signedArea - Shoelace area, positive for counterclockwise rings
+func signedArea(ring [][]float64) float64 {
+	var area float64
+	for i := 0; i < len(ring)-1; i++ {
+		area += ring[i][0]*ring[i+1][1] - ring[i+1][0]*ring[i][1]
+	}
+	return area / 2
+}
+
+// pointInRing - Even-odd ray casting
+func pointInRing(p []float64, ring [][]float64) bool {
+	var inside bool
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		a, b := ring[i], ring[j]
+		if (a[1] > p[1]) != (b[1] > p[1]) && p[0] < (b[0]-a[0])*(p[1]-a[1])/(b[1]-a[1])+a[0] {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+func reversed(ring [][]float64) [][]float64 {
+	var r = make([][]float64, len(ring))
+	for i, p := range ring {
+		r[len(ring)-1-i] = p
+	}
+	return r
+}
+
+// dbfField - One column of a .dbf
+type dbfField struct {
+	name     string
+	kind     byte
+	length   int
+	decimals int
+}
+
+// readDBF - Decode a dBASE III table into one property map per record,
+// plus each record's deletion flag
+func readDBF(b []byte, decode func([]byte) string) ([]map[string]interface{}, []bool, error) {
+
+	if len(b) < 32 {
+		return nil, nil, fmt.Errorf("formats: dbf truncated")
+	}
+
+	numRecords := int(binary.LittleEndian.Uint32(b[4:8]))
+	headerLength := int(binary.LittleEndian.Uint16(b[8:10]))
+	recordLength := int(binary.LittleEndian.Uint16(b[10:12]))
+
+	var fields []dbfField
+	for off := 32; off+32 <= headerLength && off+32 <= len(b) && b[off] != 0x0D; off += 32 {
+
+		// Names are NUL terminated, some writers leave junk after the NUL
+		name := b[off : off+11]
+		if i := bytes.IndexByte(name, 0); i >= 0 {
+			name = name[:i]
+		}
+		fields = append(fields, dbfField{
+			name:     decode(bytes.TrimRight(name, " ")),
+			kind:     b[off+11],
+			length:   int(b[off+16]),
+			decimals: int(b[off+17]),
+		})
+	}
+
+	var records = make([]map[string]interface{}, 0, numRecords)
+	var deleted = make([]bool, 0, numRecords)
+
+	for i := 0; i < numRecords; i++ {
+		off := headerLength + i*recordLength
+		if off+recordLength > len(b) {
+			return nil, nil, fmt.Errorf("formats: dbf record %d truncated", i+1)
+		}
+
+		record := b[off : off+recordLength]
+		properties := make(map[string]interface{}, len(fields))
+
+		pos := 1 // Deletion flag
+		for _, field := range fields {
+			if pos+field.length > len(record) {
+				return nil, nil, fmt.Errorf("formats: dbf field %s overruns record", field.name)
+			}
+			properties[field.name] = dbfValue(field, record[pos:pos+field.length], decode)
+			pos += field.length
+		}
+
+		records = append(records, properties)
+		deleted = append(deleted, record[0] == '*')
+	}
+
+	return records, deleted, nil
+}
+
+// dbfValue - Convert a raw field; blanks are nil
+func dbfValue(field dbfField, raw []byte, decode func([]byte) string) interface{} {
+
+	text := strings.TrimSpace(decode(bytes.TrimRight(raw, "\x00")))
+
+	switch field.kind {
+	case 'N', 'F':
+		if text == "" || strings.Trim(text, "*") == "" {
+			return nil
+		}
+		if field.decimals == 0 {
+			if n, err := strconv.ParseInt(text, 10, 64); err == nil {
+				return n
+			}
+		}
+		if f, err := strconv.ParseFloat(text, 64); err == nil {
+			return f
+		}
+		return text
+
+	case 'L':
+		switch text {
+		case "T", "t", "Y", "y":
+			return true
+		case "F", "f", "N", "n":
+			return false
+		}
+		return nil
+
+	case 'D':
+		if len(text) == 8 {
+			return text[0:4] + "-" + text[4:6] + "-" + text[6:8]
+		}
+		if text == "" {
+			return nil
+		}
+		return text
+
+	default:
+		if text == "" && field.kind != 'C' {
+			return nil
+		}
+		return text
+	}
+}
@@ -0,0 +1,145 @@
+package formats
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"reflect"
+	"testing"
+
+	geojson "github.com/paulmach/go.geojson"
+)
+
+// shpRecord - A shape record's content from little endian int32s and
+// float64s
+func shpRecord(values ...interface{}) []byte {
+	var b bytes.Buffer
+	for _, v := range values {
+		switch v := v.(type) {
+		case int:
+			binary.Write(&b, binary.LittleEndian, int32(v))
+		case float64:
+			binary.Write(&b, binary.LittleEndian, math.Float64bits(v))
+		}
+	}
+	return b.Bytes()
+}
+
+// box - The 32 byte bounding box every multi-part record starts with
+var box = []interface{}{0.0, 0.0, 0.0, 0.0}
+
+func withBox(shapeType int, rest ...interface{}) []byte {
+	values := append([]interface{}{shapeType}, box...)
+	return shpRecord(append(values, rest...)...)
+}
+
+func TestReadShape(t *testing.T) {
+
+	var cases = []struct {
+		name   string
+		record []byte
+		want   *geojson.Geometry
+	}{
+		{"null", shpRecord(shpNull), nil},
+		{"point", shpRecord(shpPoint, 1.5, 2.5), geojson.NewPointGeometry([]float64{1.5, 2.5})},
+		{"point z", shpRecord(shpPointZ, 1.0, 2.0, 3.0, 0.0), geojson.NewPointGeometry([]float64{1, 2, 3})},
+		{
+			"multipoint",
+			withBox(shpMultiPoint, 2, 0.0, 1.0, 2.0, 3.0),
+			geojson.NewMultiPointGeometry([]float64{0, 1}, []float64{2, 3}),
+		},
+		{
+			"polyline",
+			withBox(shpPolyLine, 1, 3, 0, 0.0, 0.0, 1.0, 1.0, 2.0, 0.0),
+			geojson.NewLineStringGeometry([][]float64{{0, 0}, {1, 1}, {2, 0}}),
+		},
+		{
+			"two part polyline",
+			withBox(shpPolyLine, 2, 4, 0, 2, 0.0, 0.0, 1.0, 1.0, 5.0, 5.0, 6.0, 6.0),
+			geojson.NewMultiLineStringGeometry([][]float64{{0, 0}, {1, 1}}, [][]float64{{5, 5}, {6, 6}}),
+		},
+		{
+			// Clockwise outer ring, reversed to counterclockwise
+			"polygon",
+			withBox(shpPolygon, 1, 4, 0, 0.0, 0.0, 0.0, 1.0, 1.0, 0.0, 0.0, 0.0),
+			geojson.NewPolygonGeometry([][][]float64{{{0, 0}, {1, 0}, {0, 1}, {0, 0}}}),
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			g, err := readShape(tc.record)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(g, tc.want) {
+				t.Errorf("readShape = %+v, want %+v", g, tc.want)
+			}
+		})
+	}
+}
+
+func TestReadShapeRejectsBadCounts(t *testing.T) {
+
+	var cases = []struct {
+		name   string
+		record []byte
+	}{
+		{"negative multipoint count", withBox(shpMultiPoint, -1)},
+		{"huge multipoint count", withBox(shpMultiPoint, math.MaxInt32)},
+		{"multipoint count past the record", withBox(shpMultiPoint, 2, 0.0, 1.0)},
+		{"negative multipointz count", withBox(shpMultiPointZ, -5)},
+		{"negative part count", withBox(shpPolyLine, -1, 2)},
+		{"huge point count", withBox(shpPolygon, 1, math.MaxInt32, 0)},
+		{"part index past the points", withBox(shpPolyLine, 1, 1, 5, 0.0, 0.0)},
+		{"truncated point", shpRecord(shpPoint, 1.0)},
+		{"unknown type", shpRecord(99)},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := readShape(tc.record); err == nil {
+				t.Error("want an error")
+			}
+		})
+	}
+}
+
+// A field name with junk after its NUL, as some writers leave it
+func TestReadDBFFieldNames(t *testing.T) {
+
+	var b bytes.Buffer
+	header := make([]byte, 32)
+	header[0] = 3
+	binary.LittleEndian.PutUint32(header[4:], 1)       // Records
+	binary.LittleEndian.PutUint16(header[8:], 32+32+1) // Header length
+	binary.LittleEndian.PutUint16(header[10:], 1+5)    // Record length
+	b.Write(header)
+
+	field := make([]byte, 32)
+	copy(field, "NAME\x00ab\x01")
+	field[11] = 'C'
+	field[16] = 5
+	b.Write(field)
+	b.WriteString("\x0D" + " Vienn")
+
+	records, _, err := readDBF(b.Bytes(), func(b []byte) string { return string(b) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0]["NAME"] != "Vienn" {
+		t.Errorf("records = %v, want one with NAME", records)
+	}
+}
+
+func FuzzReadShape(f *testing.F) {
+	f.Add(shpRecord(shpPoint, 1.0, 2.0))
+	f.Add(withBox(shpMultiPoint, 2, 0.0, 1.0, 2.0, 3.0))
+	f.Add(withBox(shpMultiPoint, -1))
+	f.Add(withBox(shpPolyLine, 2, 4, 0, 2, 0.0, 0.0, 1.0, 1.0, 5.0, 5.0, 6.0, 6.0))
+	f.Add(withBox(shpPolygonZ, 1, 4, 0, 0.0, 0.0, 0.0, 1.0, 1.0, 0.0, 0.0, 0.0, 0.0, 0.0, 1.0, 1.0, 1.0, 1.0))
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		readShape(b) // Must not panic
+	})
+}
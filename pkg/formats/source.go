@@ -0,0 +1,289 @@
+// Package formats -
+package formats
+
+import (
+	"aws-lambda-viswal/pkg/crs"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"strings"
+
+	geojson "github.com/paulmach/go.geojson"
+)
+
+// SourceType - A source format, detected from the file name
+type SourceType string
+
+// Supported source formats
+const (
+	SourceGeoJSON   SourceType = "geojson"
+	SourceShapefile SourceType = "shapefile"
+	SourceWKT       SourceType = "wkt"
+	SourceWKB       SourceType = "wkb"
+	SourceCSV       SourceType = "csv"
+	SourceKML       SourceType = "kml"
+	SourceGPX       SourceType = "gpx"
+)
+
+// DetectSourceType - Pick the format of `name` (a key or file path) by
+// extension; anything unrecognized is read as GeoJSON
+func DetectSourceType(name string) SourceType {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".zip", ".shp":
+		return SourceShapefile
+	case ".wkt":
+		return SourceWKT
+	case ".wkb":
+		return SourceWKB
+	case ".csv":
+		return SourceCSV
+	case ".kml", ".kmz":
+		return SourceKML
+	case ".gpx":
+		return SourceGPX
+	default:
+		return SourceGeoJSON
+	}
+}
+
+// ReadSource - Decode the source file `name` into features, first
+// undoing any compression (see `Decompress`)
+func ReadSource(name string, b []byte) (*geojson.FeatureCollection, error) {
+	return ReadEncodedSource(name, "", b)
+}
+
+// ReadEncodedSource - `ReadSource` for an object stored with
+// Content-Encoding `contentEncoding`
+func ReadEncodedSource(name string, contentEncoding string, b []byte) (*geojson.FeatureCollection, error) {
+	return ReadSourceWithCRS(name, contentEncoding, b, nil)
+}
+
+// ReadSourceWithCRS - `ReadEncodedSource`, reprojected to WGS84 from the
+// CRS the source declares (a GeoJSON `crs` member, a shapefile's .prj,
+// an EWKT/EWKB SRID) or else `assumed` (WGS84 if nil). KML and GPX are
+// always WGS84. Coordinates that still aren't longitude/latitude are an
+// error rather than being ranked as degrees.
+func ReadSourceWithCRS(name string, contentEncoding string, b []byte, assumed *crs.CRS) (*geojson.FeatureCollection, error) {
+
+	name, b, err := Decompress(name, contentEncoding, b)
+	if err != nil {
+		return nil, err
+	}
+	if assumed == nil {
+		assumed = crs.WGS84
+	}
+
+	fc, source, err := readSource(name, b, assumed)
+	if err != nil {
+		return nil, err
+	}
+	if err = reproject(fc.Features, source); err != nil {
+		return nil, err
+	}
+	for i, f := range fc.Features {
+		if f.Geometry == nil {
+			continue
+		}
+		if b := geometryBBox(f.Geometry); b[0] < -180 || b[1] < -90 || b[2] > 180 || b[3] > 90 {
+			return nil, fmt.Errorf("formats: feature %d is outside longitude/latitude bounds; is %s in a projected CRS without one declared?", i, name)
+		}
+	}
+	return fc, nil
+}
+
+// readSource - The features of a decompressed source and the CRS they're
+// in (the shapefile reader reprojects per layer itself)
+func readSource(name string, b []byte, assumed *crs.CRS) (*geojson.FeatureCollection, *crs.CRS, error) {
+
+	switch DetectSourceType(name) {
+
+	case SourceShapefile:
+		if strings.EqualFold(path.Ext(name), ".shp") {
+			// A bare .shp has geometry only
+			features, err := (&Shapefile{Name: name, SHP: b}).Features()
+			if err != nil {
+				return nil, nil, err
+			}
+			fc := geojson.NewFeatureCollection()
+			fc.Features = features
+			return fc, assumed, nil
+		}
+		fc, err := readShapefileZip(b, assumed)
+		return fc, crs.WGS84, err
+
+	case SourceWKT:
+		fc, srid, err := readWKTFile(b)
+		if err != nil {
+			return nil, nil, err
+		}
+		source, err := sridCRS(srid, assumed)
+		return fc, source, err
+
+	case SourceWKB:
+		geom, srid, err := ParseWKB(b)
+		if err != nil {
+			return nil, nil, err
+		}
+		source, err := sridCRS(srid, assumed)
+		return geojson.NewFeatureCollection().AddFeature(geojson.NewFeature(geom)), source, err
+
+	case SourceCSV:
+		fc, err := ReadCSV(b, "")
+		return fc, assumed, err
+
+	case SourceKML:
+		var fc *geojson.FeatureCollection
+		var err error
+		if strings.EqualFold(path.Ext(name), ".kmz") {
+			fc, err = ReadKMZ(b)
+		} else {
+			fc, err = ReadKML(b)
+		}
+		return fc, crs.WGS84, err
+
+	case SourceGPX:
+		fc, err := ReadGPX(b)
+		return fc, crs.WGS84, err
+
+	case SourceGeoJSON:
+		fc, err := geojson.UnmarshalFeatureCollection(b)
+		if err != nil {
+			return nil, nil, err
+		}
+		source, err := crs.FromGeoJSON(fc.CRS)
+		if err != nil {
+			return nil, nil, err
+		}
+		if source == nil {
+			source = assumed
+		}
+		fc.CRS = nil // Written as RFC 7946 GeoJSON, always WGS84
+		return fc, source, nil
+
+	default:
+		return nil, nil, fmt.Errorf("formats: unsupported source %s", name)
+	}
+}
+
+// sridCRS - The CRS for an EWKT/EWKB SRID, `assumed` if there's none
+func sridCRS(srid int, assumed *crs.CRS) (*crs.CRS, error) {
+	if srid == 0 {
+		return assumed, nil
+	}
+	return crs.FromEPSG(srid)
+}
+
+// reproject - Reproject features from `source` to WGS84, in place
+func reproject(features []*geojson.Feature, source *crs.CRS) error {
+	if source.IsWGS84() {
+		return nil
+	}
+	for i, f := range features {
+		if f.Geometry == nil {
+			continue
+		}
+		if err := source.ToWGS84(f.Geometry); err != nil {
+			return fmt.Errorf("formats: feature %d: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// readWKTFile - A .wkt file holds a single (possibly multi-line)
+// geometry, or one geometry per line; also returns the first EWKT SRID
+func readWKTFile(b []byte) (*geojson.FeatureCollection, int, error) {
+
+	fc := geojson.NewFeatureCollection()
+
+	if geom, srid, err := ParseWKT(string(b)); err == nil {
+		return fc.AddFeature(geojson.NewFeature(geom)), srid, nil
+	}
+
+	var srid int
+	for n, line := range strings.Split(string(b), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		geom, lineSRID, err := ParseWKT(line)
+		if err != nil {
+			return nil, 0, fmt.Errorf("line %d: %v", n+1, err)
+		}
+		if srid == 0 {
+			srid = lineSRID
+		}
+		fc.AddFeature(geojson.NewFeature(geom))
+	}
+	return fc, srid, nil
+}
+
+// Output formats for `WriteFeatures`
+const (
+	OutputGeoJSON = "geojson"
+	OutputWKT     = "wkt"
+	OutputWKB     = "wkb"
+	OutputCSV     = "csv"
+
+	OutputPolyline            = "polyline"
+	OutputProgressivePolyline = "polyline-progressive"
+
+	OutputGeobuf     = "geobuf"
+	OutputFlatGeobuf = "fgb"
+)
+
+// IsBinaryOutput - Whether `WriteFeatures` output for `format` is binary
+func IsBinaryOutput(format string) bool {
+	return format == OutputGeobuf || format == OutputFlatGeobuf
+}
+
+// WriteFeatures - Encode a collection as GeoJSON, CSV (see `WriteCSV`), or
+// one geometry per line as WKT or hex ISO WKB (features without a
+// geometry are skipped), Geobuf or FlatGeobuf; polylines use `DefaultPolylinePrecision`, see
+// `WritePolylines` for others
+func WriteFeatures(format string, fc *geojson.FeatureCollection) ([]byte, error) {
+
+	switch format {
+	case OutputGeoJSON, "":
+		return fc.MarshalJSON()
+
+	case OutputCSV:
+		return WriteCSV(fc)
+
+	case OutputGeobuf:
+		return MarshalGeobuf(fc)
+
+	case OutputFlatGeobuf:
+		return MarshalFlatGeobuf(fc, "")
+
+	case OutputPolyline, OutputProgressivePolyline:
+		return WritePolylines(fc, DefaultPolylinePrecision, format == OutputProgressivePolyline)
+
+	case OutputWKT, OutputWKB:
+		var buf bytes.Buffer
+		for i, f := range fc.Features {
+			if f.Geometry == nil {
+				continue
+			}
+
+			var line string
+			var err error
+			if format == OutputWKT {
+				line, err = MarshalWKT(f.Geometry)
+			} else {
+				var b []byte
+				b, err = MarshalWKB(f.Geometry)
+				line = hex.EncodeToString(b)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("formats: feature %d: %v", i, err)
+			}
+
+			buf.WriteString(line)
+			buf.WriteByte('\n')
+		}
+		return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+
+	default:
+		return nil, fmt.Errorf("formats: unknown output format %q", format)
+	}
+}
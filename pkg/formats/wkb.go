@@ -0,0 +1,338 @@
+// Package formats -
+package formats
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math"
+
+	geojson "github.com/paulmach/go.geojson"
+)
+
+// WKB geometry type codes; ISO adds 1000 for Z, 2000 for M, 3000 for ZM
+const (
+	wkbPoint              = 1
+	wkbLineString         = 2
+	wkbPolygon            = 3
+	wkbMultiPoint         = 4
+	wkbMultiLineString    = 5
+	wkbMultiPolygon       = 6
+	wkbGeometryCollection = 7
+)
+
+// EWKB (PostGIS) flags in the high bits of the type
+const (
+	ewkbZ    = 0x80000000
+	ewkbM    = 0x40000000
+	ewkbSRID = 0x20000000
+)
+
+// ParseWKB - Decode ISO WKB or PostGIS EWKB, in either byte order; the
+// SRID (EWKB only) is returned as well
+func ParseWKB(b []byte) (*geojson.Geometry, int, error) {
+	r := &wkbReader{b: b}
+	geom := r.geometry()
+	if r.err == nil && r.off != len(b) {
+		r.err = fmt.Errorf("%d trailing bytes", len(b)-r.off)
+	}
+	if r.err != nil {
+		return nil, 0, fmt.Errorf("formats: bad WKB: %v", r.err)
+	}
+	return geom, r.srid, nil
+}
+
+// ParseWKBHex - `ParseWKB` on hex text, as PostGIS prints geometries
+func ParseWKBHex(s string) (*geojson.Geometry, int, error) {
+	b, err := hex.DecodeString(string(bytes.TrimSpace([]byte(s))))
+	if err != nil {
+		return nil, 0, fmt.Errorf("formats: bad WKB hex: %v", err)
+	}
+	return ParseWKB(b)
+}
+
+type wkbReader struct {
+	b     []byte
+	off   int
+	order binary.ByteOrder
+	srid  int
+	err   error
+}
+
+func (r *wkbReader) read(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if r.off+n > len(r.b) {
+		r.err = fmt.Errorf("truncated")
+		return nil
+	}
+	b := r.b[r.off : r.off+n]
+	r.off += n
+	return b
+}
+
+func (r *wkbReader) uint32() uint32 {
+	if b := r.read(4); b != nil {
+		return r.order.Uint32(b)
+	}
+	return 0
+}
+
+func (r *wkbReader) float64() float64 {
+	if b := r.read(8); b != nil {
+		return math.Float64frombits(r.order.Uint64(b))
+	}
+	return 0
+}
+
+// count - A length prefix, sanity checked against the bytes left so a
+// corrupt count can't allocate gigabytes
+func (r *wkbReader) count(minSize int) int {
+	n := int(r.uint32())
+	if r.err == nil && n*minSize > len(r.b)-r.off {
+		r.err = fmt.Errorf("count %d exceeds input", n)
+		return 0
+	}
+	return n
+}
+
+func (r *wkbReader) geometry() *geojson.Geometry {
+
+	// Every geometry, including nested ones, starts with its byte order
+	switch order := r.read(1); {
+	case order == nil:
+		return nil
+	case order[0] == 0:
+		r.order = binary.BigEndian
+	case order[0] == 1:
+		r.order = binary.LittleEndian
+	default:
+		r.err = fmt.Errorf("bad byte order %d", order[0])
+		return nil
+	}
+
+	code := r.uint32()
+	hasZ := code&ewkbZ != 0
+	hasM := code&ewkbM != 0
+	if code&ewkbSRID != 0 {
+		r.srid = int(r.uint32())
+	}
+	code &^= ewkbZ | ewkbM | ewkbSRID
+
+	switch code / 1000 {
+	case 1:
+		hasZ = true
+	case 2:
+		hasM = true
+	case 3:
+		hasZ, hasM = true, true
+	}
+	code %= 1000
+
+	dims := 2
+	if hasZ {
+		dims++
+	}
+	if hasM {
+		dims++
+	}
+	position := func() []float64 {
+		var p = make([]float64, dims)
+		for i := range p {
+			p[i] = r.float64()
+		}
+		if hasM && !hasZ {
+			p = []float64{p[0], p[1], 0, p[2]}
+		}
+		return p
+	}
+	positions := func() [][]float64 {
+		var ps = make([][]float64, r.count(dims*8))
+		for i := range ps {
+			ps[i] = position()
+		}
+		return ps
+	}
+	paths := func() [][][]float64 {
+		var ps = make([][][]float64, r.count(4))
+		for i := range ps {
+			ps[i] = positions()
+		}
+		return ps
+	}
+	children := func() []*geojson.Geometry {
+		var gs = make([]*geojson.Geometry, r.count(5))
+		for i := range gs {
+			gs[i] = r.geometry()
+		}
+		return gs
+	}
+
+	switch code {
+	case wkbPoint:
+		return geojson.NewPointGeometry(position())
+	case wkbLineString:
+		return geojson.NewLineStringGeometry(positions())
+	case wkbPolygon:
+		return geojson.NewPolygonGeometry(paths())
+	case wkbMultiPoint:
+		var points [][]float64
+		for _, g := range children() {
+			if g != nil {
+				points = append(points, g.Point)
+			}
+		}
+		return geojson.NewMultiPointGeometry(points...)
+	case wkbMultiLineString:
+		var lines [][][]float64
+		for _, g := range children() {
+			if g != nil {
+				lines = append(lines, g.LineString)
+			}
+		}
+		return geojson.NewMultiLineStringGeometry(lines...)
+	case wkbMultiPolygon:
+		var polygons [][][][]float64
+		for _, g := range children() {
+			if g != nil {
+				polygons = append(polygons, g.Polygon)
+			}
+		}
+		return geojson.NewMultiPolygonGeometry(polygons...)
+	case wkbGeometryCollection:
+		return geojson.NewCollectionGeometry(children()...)
+	default:
+		r.err = fmt.Errorf("unknown geometry type %d", code)
+		return nil
+	}
+}
+
+// MarshalWKB - Encode a geometry as little endian ISO WKB
+func MarshalWKB(g *geojson.Geometry) ([]byte, error) {
+	var buf bytes.Buffer
+	err := writeWKB(&buf, g, dimensions(g), -1)
+	return buf.Bytes(), err
+}
+
+// MarshalEWKB - Encode a geometry as little endian PostGIS EWKB carrying
+// `srid`
+func MarshalEWKB(g *geojson.Geometry, srid int) ([]byte, error) {
+	var buf bytes.Buffer
+	err := writeWKB(&buf, g, dimensions(g), srid)
+	return buf.Bytes(), err
+}
+
+// writeWKB - ISO type codes when `srid` < 0, EWKB flags otherwise (the
+// SRID is only written on the outermost geometry)
+func writeWKB(buf *bytes.Buffer, g *geojson.Geometry, dims int, srid int) error {
+
+	var code uint32
+	switch g.Type {
+	case geojson.GeometryPoint:
+		code = wkbPoint
+	case geojson.GeometryLineString:
+		code = wkbLineString
+	case geojson.GeometryPolygon:
+		code = wkbPolygon
+	case geojson.GeometryMultiPoint:
+		code = wkbMultiPoint
+	case geojson.GeometryMultiLineString:
+		code = wkbMultiLineString
+	case geojson.GeometryMultiPolygon:
+		code = wkbMultiPolygon
+	case geojson.GeometryCollection:
+		code = wkbGeometryCollection
+	default:
+		return fmt.Errorf("formats: cannot write %q as WKB", g.Type)
+	}
+
+	ewkb := srid >= 0
+	switch {
+	case ewkb && dims >= 3:
+		code |= ewkbZ
+		if dims == 4 {
+			code |= ewkbM
+		}
+	case dims == 3:
+		code += 1000
+	case dims == 4:
+		code += 3000
+	}
+	if ewkb && srid > 0 {
+		code |= ewkbSRID
+	}
+
+	buf.WriteByte(1)
+	writeUint32(buf, code)
+	if ewkb && srid > 0 {
+		writeUint32(buf, uint32(srid))
+	}
+
+	// Children never repeat the SRID
+	var childSRID = -1
+	if ewkb {
+		childSRID = 0
+	}
+
+	switch g.Type {
+	case geojson.GeometryPoint:
+		writeWKBPosition(buf, g.Point, dims)
+	case geojson.GeometryLineString:
+		writeWKBPositions(buf, g.LineString, dims)
+	case geojson.GeometryPolygon:
+		writeUint32(buf, uint32(len(g.Polygon)))
+		for _, ring := range g.Polygon {
+			writeWKBPositions(buf, ring, dims)
+		}
+	case geojson.GeometryMultiPoint:
+		writeUint32(buf, uint32(len(g.MultiPoint)))
+		for _, p := range g.MultiPoint {
+			writeWKB(buf, geojson.NewPointGeometry(p), dims, childSRID)
+		}
+	case geojson.GeometryMultiLineString:
+		writeUint32(buf, uint32(len(g.MultiLineString)))
+		for _, line := range g.MultiLineString {
+			writeWKB(buf, geojson.NewLineStringGeometry(line), dims, childSRID)
+		}
+	case geojson.GeometryMultiPolygon:
+		writeUint32(buf, uint32(len(g.MultiPolygon)))
+		for _, polygon := range g.MultiPolygon {
+			writeWKB(buf, geojson.NewPolygonGeometry(polygon), dims, childSRID)
+		}
+	case geojson.GeometryCollection:
+		writeUint32(buf, uint32(len(g.Geometries)))
+		for _, child := range g.Geometries {
+			if err := writeWKB(buf, child, dimensions(child), childSRID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func writeUint32(buf *bytes.Buffer, v uint32) {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], v)
+	buf.Write(b[:])
+}
+
+func writeWKBPositions(buf *bytes.Buffer, positions [][]float64, dims int) {
+	writeUint32(buf, uint32(len(positions)))
+	for _, p := range positions {
+		writeWKBPosition(buf, p, dims)
+	}
+}
+
+func writeWKBPosition(buf *bytes.Buffer, pos []float64, dims int) {
+	var b [8]byte
+	for i := 0; i < dims; i++ {
+		var v float64
+		if i < len(pos) {
+			v = pos[i]
+		}
+		binary.LittleEndian.PutUint64(b[:], math.Float64bits(v))
+		buf.Write(b[:])
+	}
+}
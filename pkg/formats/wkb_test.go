@@ -0,0 +1,147 @@
+package formats
+
+import (
+	"encoding/hex"
+	"reflect"
+	"strings"
+	"testing"
+
+	geojson "github.com/paulmach/go.geojson"
+)
+
+// As PostGIS prints them: ST_AsBinary / ST_AsEWKB, and big endian
+// ST_AsBinary(geom, 'XDR')
+var wkbFixtures = []struct {
+	name string
+	hex  string
+	want []float64
+	srid int
+}{
+	{"POINT (1 2)", "0101000000000000000000f03f0000000000000040", []float64{1, 2}, 0},
+	{"SRID=4326;POINT (1 2)", "0101000020e6100000000000000000f03f0000000000000040", []float64{1, 2}, 4326},
+	{"POINT (1 2), XDR", "00000000013ff00000000000004000000000000000", []float64{1, 2}, 0},
+	{"POINT Z (1 2 3)", "01e9030000000000000000f03f00000000000000400000000000000840", []float64{1, 2, 3}, 0},
+	{"POINT Z (1 2 3), EWKB", "0101000080000000000000f03f00000000000000400000000000000840", []float64{1, 2, 3}, 0},
+	{"POINT M (1 2 4)", "01d1070000000000000000f03f00000000000000400000000000001040", []float64{1, 2, 0, 4}, 0},
+}
+
+func TestParseWKBFixtures(t *testing.T) {
+	for _, tc := range wkbFixtures {
+		g, srid, err := ParseWKBHex(" " + strings.ToUpper(tc.hex) + "\n")
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if g.Type != geojson.GeometryPoint || !reflect.DeepEqual(g.Point, tc.want) || srid != tc.srid {
+			t.Errorf("%s: got %s %v, SRID %d", tc.name, g.Type, g.Point, srid)
+		}
+	}
+}
+
+func TestMarshalWKBFixtures(t *testing.T) {
+
+	point := geojson.NewPointGeometry([]float64{1, 2})
+	for _, tc := range []struct {
+		name    string
+		marshal func() ([]byte, error)
+		want    string
+	}{
+		{"WKB", func() ([]byte, error) { return MarshalWKB(point) }, wkbFixtures[0].hex},
+		{"EWKB", func() ([]byte, error) { return MarshalEWKB(point, 4326) }, wkbFixtures[1].hex},
+		{"EWKB without SRID", func() ([]byte, error) { return MarshalEWKB(point, 0) }, wkbFixtures[0].hex},
+		{"WKB Z", func() ([]byte, error) { return MarshalWKB(geojson.NewPointGeometry([]float64{1, 2, 3})) }, wkbFixtures[3].hex},
+		{"EWKB Z", func() ([]byte, error) { return MarshalEWKB(geojson.NewPointGeometry([]float64{1, 2, 3}), 0) }, wkbFixtures[4].hex},
+	} {
+		b, err := tc.marshal()
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if got := hex.EncodeToString(b); got != tc.want {
+			t.Errorf("%s:\n got %s\nwant %s", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestWKBRoundTrip(t *testing.T) {
+
+	for _, g := range []*geojson.Geometry{
+		geojson.NewPointGeometry([]float64{1.5, -2}),
+		geojson.NewPointGeometry([]float64{1, 2, 3, 4}),
+		geojson.NewLineStringGeometry([][]float64{{0, 0}, {1, 1}, {2, 0}}),
+		geojson.NewLineStringGeometry([][]float64{{0, 0, 10}, {1, 1, 11}}),
+		geojson.NewPolygonGeometry([][][]float64{{{0, 0}, {4, 0}, {4, 4}, {0, 0}}, {{1, 1}, {2, 1}, {2, 2}, {1, 1}}}),
+		geojson.NewMultiPointGeometry([]float64{0, 0}, []float64{1, 1}),
+		geojson.NewMultiLineStringGeometry([][]float64{{0, 0}, {1, 1}}, [][]float64{{2, 2}, {3, 3}}),
+		geojson.NewMultiPolygonGeometry([][][]float64{{{0, 0}, {1, 0}, {1, 1}, {0, 0}}}, [][][]float64{{{5, 5}, {6, 5}, {6, 6}, {5, 5}}}),
+		geojson.NewCollectionGeometry(
+			geojson.NewPointGeometry([]float64{1, 2, 3}),
+			geojson.NewLineStringGeometry([][]float64{{0, 0}, {1, 1}}),
+		),
+	} {
+		for _, srid := range []int{-1, 0, 3857} {
+			var b []byte
+			var err error
+			if srid < 0 {
+				b, err = MarshalWKB(g)
+			} else {
+				b, err = MarshalEWKB(g, srid)
+			}
+			if err != nil {
+				t.Fatalf("%s: %v", g.Type, err)
+			}
+
+			got, gotSRID, err := ParseWKB(b)
+			if err != nil {
+				t.Errorf("%s (SRID %d): %v", g.Type, srid, err)
+				continue
+			}
+			if !reflect.DeepEqual(got, g) {
+				t.Errorf("%s (SRID %d): got %+v, want %+v", g.Type, srid, got, g)
+			}
+			// ISO WKB has no SRID
+			if want := srid; gotSRID != want && !(want < 0 && gotSRID == 0) {
+				t.Errorf("%s: SRID %d, want %d", g.Type, gotSRID, want)
+			}
+		}
+	}
+}
+
+func TestParseWKBErrors(t *testing.T) {
+
+	point, _ := hex.DecodeString(wkbFixtures[0].hex)
+	line, _ := MarshalWKB(geojson.NewLineStringGeometry([][]float64{{0, 0}, {1, 1}}))
+	huge := append([]byte(nil), line...)
+	copy(huge[5:], []byte{0xff, 0xff, 0xff, 0x7f}) // 2^31-1 positions
+
+	for name, b := range map[string][]byte{
+		"empty":          {},
+		"truncated":      point[:len(point)-1],
+		"trailing bytes": append(append([]byte(nil), point...), 0),
+		"byte order":     append([]byte{2}, point[1:]...),
+		"unknown type":   append([]byte{1, 9, 0, 0, 0}, point[5:]...),
+		"huge count":     huge,
+	} {
+		if g, _, err := ParseWKB(b); err == nil {
+			t.Errorf("%s: parsed as %+v", name, g)
+		}
+	}
+
+	if _, _, err := ParseWKBHex("01zz"); err == nil {
+		t.Error("bad hex parsed")
+	}
+}
+
+func FuzzParseWKB(f *testing.F) {
+	for _, tc := range wkbFixtures {
+		b, _ := hex.DecodeString(tc.hex)
+		f.Add(b)
+	}
+	polygon, _ := MarshalEWKB(geojson.NewMultiPolygonGeometry([][][]float64{{{0, 0}, {1, 0}, {1, 1}, {0, 0}}}), 4326)
+	f.Add(polygon)
+	collection, _ := MarshalWKB(geojson.NewCollectionGeometry(geojson.NewPointGeometry([]float64{1, 2})))
+	f.Add(collection)
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		ParseWKB(b) // Must not panic
+	})
+}
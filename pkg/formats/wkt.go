@@ -0,0 +1,463 @@
+// Package formats -
+package formats
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	geojson "github.com/paulmach/go.geojson"
+)
+
+/*
+NOTES:
+	- Positions are [x, y], [x, y, z] or [x, y, z, m]. GeoJSON has no M, so
+	an XYM position is stored as [x, y, 0, m]; writers pick the dimension
+	from the longest position in the geometry.
+	- Empty geometries (`POLYGON EMPTY` etc.) decode to a nil geometry, the
+	same as a GeoJSON `"geometry": null`, so readers skip them rather than
+	ranking shapes with no coordinates. Empty members of a collection or
+	MULTIPOLYGON are dropped.
+*/
+
+// ParseWKT - Decode WKT (or EWKT, with a leading `SRID=n;`) into a
+// geometry; the SRID, if any, is returned as well
+func ParseWKT(s string) (*geojson.Geometry, int, error) {
+
+	var srid int
+
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(strings.ToUpper(s), "SRID=") {
+		i := strings.Index(s, ";")
+		if i < 0 {
+			return nil, 0, fmt.Errorf("formats: bad EWKT SRID")
+		}
+		n, err := strconv.Atoi(s[5:i])
+		if err != nil {
+			return nil, 0, fmt.Errorf("formats: bad EWKT SRID: %v", err)
+		}
+		srid, s = n, s[i+1:]
+	}
+
+	p := &wktParser{tokens: tokenizeWKT(s)}
+	geom, err := p.geometry()
+	if err == nil && p.pos < len(p.tokens) {
+		err = fmt.Errorf("unexpected %q", p.tokens[p.pos])
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("formats: bad WKT: %v", err)
+	}
+	return geom, srid, nil
+}
+
+// tokenizeWKT - Split into words/numbers, "(", ")" and ","
+func tokenizeWKT(s string) []string {
+
+	var tokens []string
+	var start = -1
+
+	flush := func(i int) {
+		if start >= 0 {
+			tokens = append(tokens, s[start:i])
+			start = -1
+		}
+	}
+
+	for i, c := range s {
+		switch c {
+		case '(', ')', ',':
+			flush(i)
+			tokens = append(tokens, string(c))
+		case ' ', '\t', '\n', '\r':
+			flush(i)
+		default:
+			if start < 0 {
+				start = i
+			}
+		}
+	}
+	flush(len(s))
+
+	return tokens
+}
+
+type wktParser struct {
+	tokens []string
+	pos    int
+	hasZ   bool
+	hasM   bool
+}
+
+func (p *wktParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return strings.ToUpper(p.tokens[p.pos])
+	}
+	return ""
+}
+
+func (p *wktParser) next() string {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+func (p *wktParser) expect(t string) error {
+	if got := p.next(); got != t {
+		return fmt.Errorf("expected %q, got %q", t, got)
+	}
+	return nil
+}
+
+// empty - Consume EMPTY if it's next
+func (p *wktParser) empty() bool {
+	if p.peek() == "EMPTY" {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *wktParser) geometry() (*geojson.Geometry, error) {
+
+	kind := p.next()
+
+	// Dimension: "POINT Z (...)", "POINTZ (...)", "POINT ZM (...)", ...
+	p.hasZ, p.hasM = false, false
+	for _, suffix := range []string{"ZM", "Z", "M"} {
+		if strings.HasSuffix(kind, suffix) && kind != "MULTIPOINT" && len(kind) > len(suffix) {
+			if base := strings.TrimSuffix(kind, suffix); isWKTType(base) {
+				kind = base
+				p.dimension(suffix)
+				break
+			}
+		}
+	}
+	switch p.peek() {
+	case "Z", "M", "ZM":
+		p.dimension(p.next())
+	}
+
+	if isWKTType(kind) && p.empty() {
+		return nil, nil
+	}
+
+	switch kind {
+
+	case "POINT":
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		pos, err := p.position()
+		if err != nil {
+			return nil, err
+		}
+		return geojson.NewPointGeometry(pos), p.expect(")")
+
+	case "LINESTRING":
+		line, err := p.positions()
+		return geojson.NewLineStringGeometry(line), err
+
+	case "POLYGON":
+		rings, err := p.paths()
+		return geojson.NewPolygonGeometry(rings), err
+
+	case "MULTIPOINT":
+		points, err := p.multiPoint()
+		return geojson.NewMultiPointGeometry(points...), err
+
+	case "MULTILINESTRING":
+		lines, err := p.paths()
+		return geojson.NewMultiLineStringGeometry(lines...), err
+
+	case "MULTIPOLYGON":
+		var polygons [][][][]float64
+		err := p.list(func() error {
+			rings, err := p.paths()
+			if len(rings) > 0 {
+				polygons = append(polygons, rings)
+			}
+			return err
+		})
+		if err == nil && len(polygons) == 0 {
+			return nil, nil
+		}
+		return geojson.NewMultiPolygonGeometry(polygons...), err
+
+	case "GEOMETRYCOLLECTION":
+		var geometries []*geojson.Geometry
+		err := p.list(func() error {
+			g, err := p.geometry()
+			if g != nil {
+				geometries = append(geometries, g)
+			}
+			return err
+		})
+		if err == nil && len(geometries) == 0 {
+			return nil, nil
+		}
+		return geojson.NewCollectionGeometry(geometries...), err
+
+	default:
+		return nil, fmt.Errorf("unknown geometry type %q", kind)
+	}
+}
+
+func isWKTType(kind string) bool {
+	switch kind {
+	case "POINT", "LINESTRING", "POLYGON", "MULTIPOINT", "MULTILINESTRING", "MULTIPOLYGON", "GEOMETRYCOLLECTION":
+		return true
+	}
+	return false
+}
+
+func (p *wktParser) dimension(d string) {
+	p.hasZ = strings.Contains(d, "Z")
+	p.hasM = strings.Contains(d, "M")
+}
+
+// list - "EMPTY" or "(" item {"," item} ")"
+func (p *wktParser) list(item func() error) error {
+	if p.empty() {
+		return nil
+	}
+	if err := p.expect("("); err != nil {
+		return err
+	}
+	for {
+		if err := item(); err != nil {
+			return err
+		}
+		if t := p.next(); t == ")" {
+			return nil
+		} else if t != "," {
+			return fmt.Errorf("expected \",\" or \")\", got %q", t)
+		}
+	}
+}
+
+func (p *wktParser) positions() ([][]float64, error) {
+	var positions [][]float64
+	err := p.list(func() error {
+		pos, err := p.position()
+		positions = append(positions, pos)
+		return err
+	})
+	return positions, err
+}
+
+func (p *wktParser) paths() ([][][]float64, error) {
+	var paths [][][]float64
+	err := p.list(func() error {
+		path, err := p.positions()
+		paths = append(paths, path)
+		return err
+	})
+	return paths, err
+}
+
+// multiPoint - Points may or may not be parenthesized: both
+// MULTIPOINT (1 2, 3 4) and MULTIPOINT ((1 2), (3 4)) are common
+func (p *wktParser) multiPoint() ([][]float64, error) {
+	var points [][]float64
+	err := p.list(func() error {
+		var pos []float64
+		var err error
+		if p.peek() == "(" {
+			p.pos++
+			if pos, err = p.position(); err == nil {
+				err = p.expect(")")
+			}
+		} else {
+			pos, err = p.position()
+		}
+		points = append(points, pos)
+		return err
+	})
+	return points, err
+}
+
+// position - Numbers up to the next "," or ")"; with no dimension
+// keyword, 3 numbers are XYZ and 4 are XYZM
+func (p *wktParser) position() ([]float64, error) {
+
+	var values []float64
+	for p.pos < len(p.tokens) && p.peek() != "," && p.peek() != ")" {
+		v, err := strconv.ParseFloat(p.next(), 64)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+
+	if len(values) < 2 || len(values) > 4 {
+		return nil, fmt.Errorf("position with %d values", len(values))
+	}
+	if p.hasM && !p.hasZ {
+		if len(values) != 3 {
+			return nil, fmt.Errorf("XYM position with %d values", len(values))
+		}
+		return []float64{values[0], values[1], 0, values[2]}, nil
+	}
+	return values, nil
+}
+
+// MarshalWKT - Encode a geometry as WKT, e.g. "POLYGON Z ((...))"
+func MarshalWKT(g *geojson.Geometry) (string, error) {
+	var b strings.Builder
+	if err := writeWKT(&b, g, dimensions(g)); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// MarshalEWKT - `MarshalWKT` with a PostGIS `SRID=n;` prefix
+func MarshalEWKT(g *geojson.Geometry, srid int) (string, error) {
+	s, err := MarshalWKT(g)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("SRID=%d;%s", srid, s), nil
+}
+
+func writeWKT(b *strings.Builder, g *geojson.Geometry, dims int) error {
+
+	b.WriteString(strings.ToUpper(string(g.Type)))
+	switch dims {
+	case 3:
+		b.WriteString(" Z")
+	case 4:
+		b.WriteString(" ZM")
+	}
+	b.WriteByte(' ')
+
+	switch g.Type {
+	case geojson.GeometryPoint:
+		b.WriteByte('(')
+		writeWKTPosition(b, g.Point, dims)
+		b.WriteByte(')')
+	case geojson.GeometryMultiPoint:
+		writeWKTPositions(b, g.MultiPoint, dims)
+	case geojson.GeometryLineString:
+		writeWKTPositions(b, g.LineString, dims)
+	case geojson.GeometryMultiLineString:
+		writeWKTPaths(b, g.MultiLineString, dims)
+	case geojson.GeometryPolygon:
+		writeWKTPaths(b, g.Polygon, dims)
+	case geojson.GeometryMultiPolygon:
+		if len(g.MultiPolygon) == 0 {
+			b.WriteString("EMPTY")
+			return nil
+		}
+		b.WriteByte('(')
+		for i, polygon := range g.MultiPolygon {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			writeWKTPaths(b, polygon, dims)
+		}
+		b.WriteByte(')')
+	case geojson.GeometryCollection:
+		if len(g.Geometries) == 0 {
+			b.WriteString("EMPTY")
+			return nil
+		}
+		b.WriteByte('(')
+		for i, child := range g.Geometries {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			if err := writeWKT(b, child, dimensions(child)); err != nil {
+				return err
+			}
+		}
+		b.WriteByte(')')
+	default:
+		return fmt.Errorf("formats: cannot write %q as WKT", g.Type)
+	}
+	return nil
+}
+
+func writeWKTPaths(b *strings.Builder, paths [][][]float64, dims int) {
+	if len(paths) == 0 {
+		b.WriteString("EMPTY")
+		return
+	}
+	b.WriteByte('(')
+	for i, path := range paths {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		writeWKTPositions(b, path, dims)
+	}
+	b.WriteByte(')')
+}
+
+func writeWKTPositions(b *strings.Builder, positions [][]float64, dims int) {
+	if len(positions) == 0 {
+		b.WriteString("EMPTY")
+		return
+	}
+	b.WriteByte('(')
+	for i, pos := range positions {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		writeWKTPosition(b, pos, dims)
+	}
+	b.WriteByte(')')
+}
+
+func writeWKTPosition(b *strings.Builder, pos []float64, dims int) {
+	for i := 0; i < dims; i++ {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		var v float64
+		if i < len(pos) {
+			v = pos[i]
+		}
+		b.WriteString(strconv.FormatFloat(v, 'f', -1, 64))
+	}
+}
+
+// dimensions - 2, 3 or 4, from the longest position in the geometry
+// (not descending into collections)
+func dimensions(g *geojson.Geometry) int {
+
+	var dims = 2
+	visit := func(positions [][]float64) {
+		for _, p := range positions {
+			if len(p) > dims {
+				dims = len(p)
+			}
+		}
+	}
+
+	switch g.Type {
+	case geojson.GeometryPoint:
+		visit([][]float64{g.Point})
+	case geojson.GeometryMultiPoint:
+		visit(g.MultiPoint)
+	case geojson.GeometryLineString:
+		visit(g.LineString)
+	case geojson.GeometryMultiLineString:
+		for _, line := range g.MultiLineString {
+			visit(line)
+		}
+	case geojson.GeometryPolygon:
+		for _, ring := range g.Polygon {
+			visit(ring)
+		}
+	case geojson.GeometryMultiPolygon:
+		for _, polygon := range g.MultiPolygon {
+			for _, ring := range polygon {
+				visit(ring)
+			}
+		}
+	}
+
+	if dims > 4 {
+		dims = 4
+	}
+	return dims
+}
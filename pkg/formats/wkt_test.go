@@ -0,0 +1,75 @@
+package formats
+
+import (
+	"reflect"
+	"testing"
+
+	geojson "github.com/paulmach/go.geojson"
+)
+
+func TestParseWKTEmpty(t *testing.T) {
+	for _, s := range []string{
+		"POINT EMPTY",
+		"POLYGON EMPTY",
+		"polygon empty",
+		"MULTIPOLYGON EMPTY",
+		"MULTIPOLYGON (EMPTY)",
+		"LINESTRING Z EMPTY",
+		"GEOMETRYCOLLECTION EMPTY",
+		"GEOMETRYCOLLECTION (POINT EMPTY, POLYGON EMPTY)",
+		"SRID=4326;POLYGON EMPTY",
+	} {
+		g, _, err := ParseWKT(s)
+		if err != nil {
+			t.Errorf("%s: %v", s, err)
+			continue
+		}
+		if g != nil {
+			t.Errorf("%s: got %s geometry, want nil", s, g.Type)
+		}
+	}
+}
+
+func TestParseWKTDropsEmptyMembers(t *testing.T) {
+
+	g, _, err := ParseWKT("MULTIPOLYGON (EMPTY, ((0 0, 1 0, 1 1, 0 0)))")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := [][][][]float64{{{{0, 0}, {1, 0}, {1, 1}, {0, 0}}}}; !reflect.DeepEqual(g.MultiPolygon, want) {
+		t.Errorf("MultiPolygon = %v, want %v", g.MultiPolygon, want)
+	}
+
+	g, _, err = ParseWKT("GEOMETRYCOLLECTION (POINT EMPTY, POINT (1 2))")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(g.Geometries) != 1 || !reflect.DeepEqual(g.Geometries[0].Point, []float64{1, 2}) {
+		t.Errorf("Geometries = %v, want [POINT (1 2)]", g.Geometries)
+	}
+}
+
+func TestWKTRoundTrip(t *testing.T) {
+	for _, g := range []*geojson.Geometry{
+		geojson.NewPointGeometry([]float64{1.5, -2}),
+		geojson.NewPointGeometry([]float64{1, 2, 3}),
+		geojson.NewLineStringGeometry([][]float64{{0, 0}, {1, 1}, {2, 0}}),
+		geojson.NewPolygonGeometry([][][]float64{{{0, 0}, {4, 0}, {4, 4}, {0, 0}}, {{1, 1}, {2, 1}, {2, 2}, {1, 1}}}),
+		geojson.NewMultiPointGeometry([]float64{0, 0}, []float64{1, 1}),
+		geojson.NewMultiLineStringGeometry([][]float64{{0, 0}, {1, 1}}, [][]float64{{2, 2}, {3, 3}}),
+		geojson.NewMultiPolygonGeometry([][][]float64{{{0, 0}, {1, 0}, {1, 1}, {0, 0}}}, [][][]float64{{{5, 5}, {6, 5}, {6, 6}, {5, 5}}}),
+		geojson.NewCollectionGeometry(geojson.NewPointGeometry([]float64{1, 2}), geojson.NewLineStringGeometry([][]float64{{0, 0}, {1, 1}})),
+	} {
+		s, err := MarshalWKT(g)
+		if err != nil {
+			t.Fatalf("%s: %v", g.Type, err)
+		}
+		back, _, err := ParseWKT(s)
+		if err != nil {
+			t.Fatalf("%s: %v", s, err)
+		}
+		if !reflect.DeepEqual(back, g) {
+			t.Errorf("%s: round trip = %+v, want %+v", s, back, g)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+// Package manager ...
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// BackfillOptions - How `Backfill` reads the bucket; `Concurrency` (at
+// least 1) meta objects are downloaded at a time. Tombstoned shapes are
+// deleted from the index with `DeleteTombstones`, otherwise skipped.
+// With `ModifiedSince`, only meta objects modified since then are read.
+type BackfillOptions struct {
+	Concurrency      int
+	DeleteTombstones bool
+	ModifiedSince    time.Time
+	Indexer          BulkIndexerConfig
+}
+
+// BackfillResult - What `Backfill` did with the meta objects it listed
+type BackfillResult struct {
+	Listed  int
+	Indexed int
+	Deleted int
+	Skipped int
+	Failed  int
+}
+
+// Backfill - Index the meta objects under `MetaPrefix` in `bucket` into
+// the client's index. Keys that aren't meta are skipped; meta that can't
+// be read, and documents Elasticsearch rejects, are failed. Stops listing
+// new work when `ctx` is done, but finishes what's queued.
+func Backfill(ctx context.Context, store ObjectStore, bucket string, e *ElasticClient, opts BackfillOptions) (BackfillResult, error) {
+
+	var result BackfillResult
+
+	keys, err := store.ListObjectKeysSince(bucket, MetaPrefix, opts.ModifiedSince)
+	if err != nil {
+		return result, fmt.Errorf("manager: list %s/%s: %v", bucket, MetaPrefix, err)
+	}
+	result.Listed = len(keys)
+
+	var concurrency = opts.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	// Requests outlive `ctx` so queued documents are still sent
+	indexer := e.NewBulkIndexer(context.Background(), opts.Indexer)
+
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		jobs    = make(chan string)
+		skipped int
+		failed  int
+		notMeta int
+	)
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key := range jobs {
+				queued, err := backfillKey(store, bucket, key, indexer, opts.DeleteTombstones)
+				mu.Lock()
+				switch {
+				case err != nil:
+					log.WithFields(log.Fields{"Key": key}).Warn("Failed To Backfill Meta: ", err)
+					failed++
+				case !queued:
+					skipped++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+feed:
+	for _, key := range keys {
+		if _, ok := HashFromMetaKey(key); !ok {
+			notMeta++
+			continue
+		}
+		select {
+		case jobs <- key:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	stats := indexer.Close()
+	result.Indexed = stats.Indexed
+	result.Deleted = stats.Deleted
+	result.Skipped = skipped + notMeta
+	result.Failed = failed + stats.Failed
+	return result, ctx.Err()
+}
+
+// backfillKey - Queue the action for one meta object; false if there's
+// nothing to do (a tombstone that's being skipped)
+func backfillKey(store ObjectStore, bucket string, key string, indexer *BulkIndexer, deleteTombstones bool) (bool, error) {
+
+	b, err := store.GetObject(bucket, key)
+	if err != nil {
+		return false, err
+	}
+
+	var meta S3UploadMeta
+	if err = json.Unmarshal(b, &meta); err != nil {
+		return false, err
+	}
+	if hash, _ := HashFromMetaKey(key); meta.Hash != hash {
+		return false, fmt.Errorf("meta hash %q doesn't match its key", meta.Hash)
+	}
+
+	switch {
+	case !meta.Deleted:
+		_, err = indexer.Index(&meta)
+	case deleteTombstones:
+		_, err = indexer.Delete(meta.Hash)
+	default:
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -0,0 +1,406 @@
+// Package manager ...
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	elastic "github.com/olivere/elastic/v7"
+)
+
+/*
+NOTES:
+	- Actions are queued and sent in one `_bulk` request once there are
+	`MaxActions` of them, their bodies pass `MaxBytes`, or `FlushInterval`
+	has passed; whichever comes first. A full queue is sent by the caller
+	that filled it, which is the backpressure.
+	- One bulk request is in flight at a time, retries included, and
+	batches go out in the order they were queued. Otherwise an index and a
+	delete for the same hash in different batches (or a retried index
+	behind a newer delete) could land in the wrong order. Meta `Version`
+	can't order them instead: it counts versions of one source, and a
+	shape can come from several.
+	- A bulk response holds a status per action, in order. 429 and 5xx
+	actions (a full write queue, a shard that moved) are resent on their
+	own with `Retry`'s backoff; anything else (a mapping conflict, say) is
+	final. Deleting a document that isn't there counts as success. An
+	action superseded by a later one for the same document in the same
+	request isn't resent.
+	- Each queued action returns a `BulkItem`; `Wait` on it for the final
+	outcome, after retries.
+*/
+
+// ErrIndexerClosed - Returned when queueing on a closed `BulkIndexer`
+var ErrIndexerClosed = errors.New("elastic: bulk indexer is closed")
+
+// BulkIndexerConfig - Flush thresholds; zero `MaxActions`, `MaxBytes` or
+// `FlushInterval` disables that threshold
+type BulkIndexerConfig struct {
+	MaxActions    int
+	MaxBytes      int64
+	FlushInterval time.Duration
+	Retry         RetryPolicy
+}
+
+// DefaultBulkIndexerConfig - 500 actions or 5MB, at least once a second
+var DefaultBulkIndexerConfig = BulkIndexerConfig{
+	MaxActions:    500,
+	MaxBytes:      5 << 20,
+	FlushInterval: time.Second,
+	Retry:         DefaultRetryPolicy,
+}
+
+// BulkStats - Running totals for a `BulkIndexer`
+type BulkStats struct {
+	Indexed int
+	Deleted int
+	Failed  int
+	Retried int
+	Flushes int
+}
+
+// BulkItem - One queued action
+type BulkItem struct {
+	ID     string
+	Action string // index or delete
+
+	request elastic.BulkableRequest
+	size    int64
+	done    chan struct{}
+	err     error
+}
+
+// Wait - Block until the action succeeds or fails for good, or `ctx` is done
+func (i *BulkItem) Wait(ctx context.Context) error {
+	select {
+	case <-i.done:
+		return i.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (i *BulkItem) finish(err error) {
+	i.err = err
+	close(i.done)
+}
+
+// BulkIndexer - Batches index and delete actions for one index; see NOTES.
+// Safe for concurrent use. Create with `ElasticClient.NewBulkIndexer` and
+// release with `Close`.
+type BulkIndexer struct {
+	client *elastic.Client
+	index  string
+	config BulkIndexerConfig
+	ctx    context.Context
+
+	mu      sync.Mutex
+	pending []*BulkItem
+	bytes   int64
+	closed  bool
+	stats   BulkStats
+
+	sending  sync.Mutex // Held from `take` until the batch is done; see NOTES
+	inflight sync.WaitGroup
+	stop     chan struct{}
+	stopped  chan struct{}
+}
+
+// NewBulkIndexer - A `BulkIndexer` writing to the client's index; requests
+// use `ctx`
+func (e *ElasticClient) NewBulkIndexer(ctx context.Context, config BulkIndexerConfig) *BulkIndexer {
+
+	if !(e.hasClient()) {
+		e.initializeClient()
+	}
+
+	b := &BulkIndexer{
+		client:  e.Client,
+		index:   e.IndexName,
+		config:  config,
+		ctx:     ctx,
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+
+	go b.run()
+	return b
+}
+
+// run - Flush on the interval until closed
+func (b *BulkIndexer) run() {
+
+	defer close(b.stopped)
+	if b.config.FlushInterval <= 0 {
+		<-b.stop
+		return
+	}
+
+	ticker := time.NewTicker(b.config.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			b.Flush()
+		case <-b.stop:
+			return
+		}
+	}
+}
+
+// Index - Queue `entry` to be indexed under its hash
+func (b *BulkIndexer) Index(entry *S3UploadMeta) (*BulkItem, error) {
+	doc, err := marshalShapeDocument(entry)
+	if err != nil {
+		return nil, fmt.Errorf("elastic: encode %s: %v", entry.Hash, err)
+	}
+	return b.add("index", entry.Hash, elastic.NewBulkIndexRequest().Id(entry.Hash).Doc(json.RawMessage(doc)))
+}
+
+// Delete - Queue removal of the document for `hash`
+func (b *BulkIndexer) Delete(hash string) (*BulkItem, error) {
+	return b.add("delete", hash, elastic.NewBulkDeleteRequest().Id(hash))
+}
+
+func (b *BulkIndexer) add(action string, id string, request elastic.BulkableRequest) (*BulkItem, error) {
+
+	lines, err := request.Source()
+	if err != nil {
+		return nil, fmt.Errorf("elastic: %s %s: %v", action, id, err)
+	}
+
+	item := &BulkItem{ID: id, Action: action, request: request, done: make(chan struct{})}
+	for _, line := range lines {
+		item.size += int64(len(line)) + 1
+	}
+
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return nil, ErrIndexerClosed
+	}
+	b.pending = append(b.pending, item)
+	b.bytes += item.size
+
+	full := b.full()
+	b.mu.Unlock()
+
+	if full {
+		b.flush(true)
+	}
+	return item, nil
+}
+
+// full - Whether the queue has reached a threshold; the caller holds `mu`
+func (b *BulkIndexer) full() bool {
+	return (b.config.MaxActions > 0 && len(b.pending) >= b.config.MaxActions) ||
+		(b.config.MaxBytes > 0 && b.bytes >= b.config.MaxBytes)
+}
+
+// take - Claim the queue and mark it in flight; the caller holds `mu`
+// and `sending`
+func (b *BulkIndexer) take() []*BulkItem {
+	batch := b.pending
+	b.pending, b.bytes = nil, 0
+	if len(batch) > 0 {
+		b.inflight.Add(1)
+	}
+	return batch
+}
+
+// Flush - Send whatever is queued and wait for it
+func (b *BulkIndexer) Flush() {
+	b.flush(false)
+}
+
+// flush - Wait for the request in flight, then send the queue; if
+// `onlyFull`, only if it's still full by then
+func (b *BulkIndexer) flush(onlyFull bool) {
+
+	b.sending.Lock()
+	defer b.sending.Unlock()
+
+	b.mu.Lock()
+	var batch []*BulkItem
+	if !onlyFull || b.full() {
+		batch = b.take()
+	}
+	b.mu.Unlock()
+
+	if len(batch) > 0 {
+		b.send(batch)
+	}
+}
+
+// Close - Stop the interval flush, send what's queued and wait for every
+// request in flight; later `Index` and `Delete` calls fail
+func (b *BulkIndexer) Close() BulkStats {
+
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		b.inflight.Wait()
+		return b.Stats()
+	}
+	b.closed = true
+	b.mu.Unlock()
+
+	close(b.stop)
+	<-b.stopped
+
+	b.Flush()
+	b.inflight.Wait()
+	return b.Stats()
+}
+
+// Stats - Totals so far
+func (b *BulkIndexer) Stats() BulkStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.stats
+}
+
+// send - One bulk request per attempt, resending the actions worth
+// another try until none are left or the attempts run out
+func (b *BulkIndexer) send(batch []*BulkItem) {
+
+	defer b.inflight.Done()
+
+	var attempts = b.config.Retry.MaxAttempts
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	for attempt := 0; len(batch) > 0; attempt++ {
+
+		if attempt > 0 {
+			time.Sleep(b.config.Retry.backoff(attempt - 1))
+		}
+		last := attempt == attempts-1
+
+		service := b.client.Bulk().Index(b.index)
+		for _, item := range batch {
+			service.Add(item.request)
+		}
+
+		response, err := service.Do(b.ctx)
+		b.count(func(s *BulkStats) { s.Flushes++ })
+
+		if err != nil {
+			if last || !retryableBulkError(err) {
+				log.WithFields(log.Fields{"Index": b.index, "Actions": len(batch)}).Error("Elasticsearch Bulk Request Error ", err)
+				b.finish(batch, fmt.Errorf("elastic: bulk request: %v", err))
+				return
+			}
+			b.count(func(s *BulkStats) { s.Retried += len(batch) })
+			continue
+		}
+
+		if len(response.Items) != len(batch) {
+			b.finish(batch, fmt.Errorf("elastic: bulk response has %d items for %d actions", len(response.Items), len(batch)))
+			return
+		}
+
+		// Walk back, so an action can see whether a later one for the
+		// same document already succeeded
+		var retry []*BulkItem
+		var succeeded = make(map[string]bool)
+		for i := len(batch) - 1; i >= 0; i-- {
+			item := batch[i]
+			result := response.Items[i][item.Action]
+			switch err := bulkItemError(item.Action, result); {
+			case err == nil:
+				succeeded[item.ID] = true
+				b.finish([]*BulkItem{item}, nil)
+			case !last && result != nil && retryableStatus(result.Status):
+				if succeeded[item.ID] {
+					b.finish([]*BulkItem{item}, nil) // Superseded
+					continue
+				}
+				retry = append([]*BulkItem{item}, retry...)
+			default:
+				b.finish([]*BulkItem{item}, err)
+			}
+		}
+
+		if len(retry) > 0 {
+			b.count(func(s *BulkStats) { s.Retried += len(retry) })
+		}
+		batch = retry
+	}
+}
+
+// finish - Record the outcome of `items`
+func (b *BulkIndexer) finish(items []*BulkItem, err error) {
+	b.count(func(s *BulkStats) {
+		for _, item := range items {
+			switch {
+			case err != nil:
+				s.Failed++
+			case item.Action == "delete":
+				s.Deleted++
+			default:
+				s.Indexed++
+			}
+		}
+	})
+	for _, item := range items {
+		if err != nil {
+			log.WithFields(log.Fields{"Index": b.index, "ID": item.ID, "Action": item.Action}).Warn(err)
+		}
+		item.finish(err)
+	}
+}
+
+func (b *BulkIndexer) count(fn func(*BulkStats)) {
+	b.mu.Lock()
+	fn(&b.stats)
+	b.mu.Unlock()
+}
+
+// bulkItemError - nil if the action succeeded
+func bulkItemError(action string, result *elastic.BulkResponseItem) error {
+	if result == nil {
+		return fmt.Errorf("elastic: no %s result in bulk response", action)
+	}
+	if result.Status >= 200 && result.Status <= 299 {
+		return nil
+	}
+	if action == "delete" && result.Status == http.StatusNotFound {
+		return nil
+	}
+	if result.Error != nil {
+		return fmt.Errorf("elastic: %s %s: %d %s: %s", action, result.Id, result.Status, result.Error.Type, result.Error.Reason)
+	}
+	return fmt.Errorf("elastic: %s %s: %d", action, result.Id, result.Status)
+}
+
+// retryableStatus - Rejected (429) or a server side failure
+func retryableStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status >= 500
+}
+
+// retryableBulkError - No node to talk to, a dropped connection, or a
+// retryable status for the whole request
+func retryableBulkError(err error) bool {
+	if elastic.IsConnErr(err) {
+		return true
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var e *elastic.Error
+	if errors.As(err, &e) {
+		return retryableStatus(e.Status)
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
@@ -0,0 +1,196 @@
+package manager
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	elastic "github.com/olivere/elastic/v7"
+)
+
+// fakeBulk - A `_bulk` endpoint keeping the documents it was left with.
+// `reject` decides, per request and action, whether to answer 429.
+type fakeBulk struct {
+	mu          sync.Mutex
+	docs        map[string]bool
+	requests    int
+	inflight    int
+	maxInflight int
+	reject      func(request int, action string, id string) bool
+}
+
+func (f *fakeBulk) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	f.mu.Lock()
+	request := f.requests
+	f.requests++
+	f.inflight++
+	if f.inflight > f.maxInflight {
+		f.maxInflight = f.inflight
+	}
+	f.mu.Unlock()
+
+	// Long enough for another request to overlap, if the indexer allowed it
+	time.Sleep(20 * time.Millisecond)
+
+	var items []map[string]interface{}
+	scanner := bufio.NewScanner(r.Body)
+	for scanner.Scan() {
+		var line map[string]map[string]interface{}
+		if json.Unmarshal(scanner.Bytes(), &line) != nil {
+			continue
+		}
+		for action, meta := range line {
+			id, ok := meta["_id"].(string)
+			if !ok || (action != "index" && action != "delete") {
+				continue // A document line
+			}
+
+			status := http.StatusOK
+			f.mu.Lock()
+			switch {
+			case f.reject != nil && f.reject(request, action, id):
+				status = http.StatusTooManyRequests
+			case action == "index":
+				f.docs[id] = true
+			default:
+				delete(f.docs, id)
+			}
+			f.mu.Unlock()
+
+			items = append(items, map[string]interface{}{
+				action: map[string]interface{}{"_id": id, "status": status},
+			})
+		}
+	}
+
+	f.mu.Lock()
+	f.inflight--
+	f.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"took": 1, "errors": false, "items": items})
+}
+
+func (f *fakeBulk) requestCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.requests
+}
+
+func newTestBulkIndexer(t *testing.T, f *fakeBulk, config BulkIndexerConfig) *BulkIndexer {
+
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+
+	client, err := elastic.NewClient(elastic.SetURL(srv.URL), elastic.SetSniff(false), elastic.SetHealthcheck(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := ElasticClient{Client: client, IndexName: "shapes"}
+	return e.NewBulkIndexer(context.Background(), config)
+}
+
+var testBulkConfig = BulkIndexerConfig{
+	MaxActions: 1,
+	Retry:      RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond},
+}
+
+// An index that has to be retried mustn't land after a delete for the
+// same hash queued behind it
+func TestBulkIndexerKeepsOrderAcrossRetries(t *testing.T) {
+
+	f := &fakeBulk{
+		docs: make(map[string]bool),
+		reject: func(request int, action string, id string) bool {
+			return request == 0
+		},
+	}
+	b := newTestBulkIndexer(t, f, testBulkConfig)
+
+	var items []*BulkItem
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	queue := func(fn func() (*BulkItem, error)) {
+		defer wg.Done()
+		item, err := fn()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		mu.Lock()
+		items = append(items, item)
+		mu.Unlock()
+	}
+
+	wg.Add(1)
+	go queue(func() (*BulkItem, error) { return b.Index(&S3UploadMeta{Hash: "h1", Name: "a"}) })
+	for f.requestCount() == 0 { // The index goes first
+		time.Sleep(time.Millisecond)
+	}
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go queue(func() (*BulkItem, error) { return b.Index(&S3UploadMeta{Hash: fmt.Sprintf("other%d", i)}) })
+	}
+	wg.Add(1)
+	go queue(func() (*BulkItem, error) { return b.Delete("h1") })
+	wg.Wait()
+
+	stats := b.Close()
+	for _, item := range items {
+		if err := item.Wait(context.Background()); err != nil {
+			t.Errorf("%s %s: %v", item.Action, item.ID, err)
+		}
+	}
+
+	if f.docs["h1"] {
+		t.Error("h1 was indexed after its delete")
+	}
+	if f.maxInflight != 1 {
+		t.Errorf("%d bulk requests in flight at once, want 1", f.maxInflight)
+	}
+	if stats.Retried != 1 || stats.Failed != 0 {
+		t.Errorf("stats = %+v, want 1 retried and none failed", stats)
+	}
+}
+
+// Within one request, an action superseded by a later one for the same
+// document isn't resent
+func TestBulkIndexerDropsSupersededRetries(t *testing.T) {
+
+	f := &fakeBulk{
+		docs: make(map[string]bool),
+		reject: func(request int, action string, id string) bool {
+			return request == 0 && action == "index"
+		},
+	}
+	config := testBulkConfig
+	config.MaxActions = 0
+	b := newTestBulkIndexer(t, f, config)
+
+	index, _ := b.Index(&S3UploadMeta{Hash: "h1"})
+	remove, _ := b.Delete("h1")
+	stats := b.Close()
+
+	for _, item := range []*BulkItem{index, remove} {
+		if err := item.Wait(context.Background()); err != nil {
+			t.Errorf("%s: %v", item.Action, err)
+		}
+	}
+	if f.docs["h1"] {
+		t.Error("superseded index was resent")
+	}
+	if f.requests != 1 || stats.Retried != 0 {
+		t.Errorf("%d requests, stats %+v; want 1 request and no retries", f.requests, stats)
+	}
+	if stats.Indexed != 1 || stats.Deleted != 1 {
+		t.Errorf("stats = %+v, want 1 indexed and 1 deleted", stats)
+	}
+}
@@ -0,0 +1,56 @@
+// Package manager ...
+package manager
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Checkpoint - Progress through a source that couldn't be finished in one
+// invocation. `NextIndex` is the first feature not yet uploaded,
+// `Completed` the hashes already uploaded and `Skipped` those that can't
+// be; `ETag` ties the checkpoint to one version of the source, so a
+// re-upload starts over.
+type Checkpoint struct {
+	Source    string    `json:"Source"`
+	ETag      string    `json:"ETag"`
+	NextIndex int       `json:"NextIndex"`
+	Completed []string  `json:"Completed"`
+	Skipped   []string  `json:"Skipped,omitempty"`
+	UpdatedAt time.Time `json:"UpdatedAt"`
+}
+
+// ReadCheckpoint - Fetch the checkpoint for `source`; returns nil (and
+// no error) if there isn't one
+func ReadCheckpoint(store ObjectStore, bucket string, source string) (*Checkpoint, error) {
+
+	var c Checkpoint
+
+	b, err := store.GetObject(bucket, CheckpointKey(source))
+	if err != nil {
+		if err == ErrNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	if err = json.Unmarshal(b, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// WriteCheckpoint - Store `c` in `bucket`, replacing any earlier one
+func WriteCheckpoint(store ObjectStore, bucket string, c *Checkpoint) error {
+	c.UpdatedAt = time.Now().UTC()
+	b, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return store.PutObject(bucket, CheckpointKey(c.Source), b)
+}
+
+// DeleteCheckpoint - Remove the checkpoint for a finished source
+func DeleteCheckpoint(store ObjectStore, bucket string, source string) error {
+	return store.DeleteObject(bucket, CheckpointKey(source))
+}
@@ -3,7 +3,7 @@ package manager
 
 import (
 	"context"
-	"encoding/json"
+	"fmt"
 	"os"
 
 	log "github.com/sirupsen/logrus"
@@ -16,24 +16,28 @@ var (
 	elasticHost         string = os.Getenv("ELASTIC_HOST")
 )
 
-// ElasticClient - Used to connect to ES and make Bulk Requests
+// ElasticClient - Used to connect to ES; writes go through a `BulkIndexer`
 type ElasticClient struct {
 	ConnectionParams map[string]string
 	Client           *elastic.Client
 	Context          context.Context
-	Request          *elastic.BulkService
 	IndexName        string
 }
 
-// NewElasticClient -
+// NewElasticClient - Connect to `ELASTIC_HOST`, for `ELASTIC_DEFAULT_INDEX`
 func NewElasticClient() ElasticClient {
+	return NewElasticClientFor(elasticHost, elasticDefaultIndex)
+}
+
+// NewElasticClientFor - Connect to `host`, for the index (or alias) `index`
+func NewElasticClientFor(host string, index string) ElasticClient {
 
 	// Init Elastic Client...
 	e := ElasticClient{
 		ConnectionParams: map[string]string{
-			"Host": elasticHost,
+			"Host": host,
 		},
-		IndexName: elasticDefaultIndex,
+		IndexName: index,
 	}
 
 	e.initializeClient()
@@ -58,26 +62,16 @@ func (e *ElasticClient) initializeClient() {
 
 }
 
-// ExecuteRequest -
-func (e *ElasticClient) ExecuteRequest() {
-
-	if !(e.hasContext()) {
-		e.initializeContext()
+// Ping - Check the cluster answers and `IndexName` exists; bound by `ctx`
+func (e *ElasticClient) Ping(ctx context.Context) error {
+	exists, err := e.Client.IndexExists(e.IndexName).Do(ctx)
+	if err != nil {
+		return fmt.Errorf("elastic: %v", err)
 	}
-
-	if _, err := e.Request.Do(e.Context); err != nil {
-		log.WithFields(
-			log.Fields{
-				"Host":  e.ConnectionParams["Host"],
-				"Index": e.IndexName,
-			},
-		).Error("Elasticsearch Request Error", err)
+	if !exists {
+		return fmt.Errorf("elastic: index %q doesn't exist", e.IndexName)
 	}
-
-}
-
-func (e *ElasticClient) hasRequest() bool {
-	return e.Request != nil
+	return nil
 }
 
 func (e *ElasticClient) hasContext() bool {
@@ -94,52 +88,3 @@ func (e *ElasticClient) initializeContext() {
 		e.Context = context.Background()
 	}
 }
-
-func (e *ElasticClient) initializeBulkRequest() {
-	e.Request = e.Client.
-		Bulk().
-		Index(e.IndexName)
-}
-
-// PrepareInsert -
-func (e *ElasticClient) PrepareInsert(entry *S3UploadMeta) {
-
-	// Initialize Request if Not Yet Exists - Send to Elastic
-	if !(e.hasRequest()) {
-		e.newRequest()
-	}
-
-	b, err := json.Marshal(entry)
-	if err != nil {
-		// Literally Should NEVER happen...
-		log.WithFields(
-			log.Fields{
-				"Path": entry.Path,
-				"Name": entry.Name,
-			},
-		).Warn(
-			"Failed To Add Feature to Index Request", err,
-		)
-	} else {
-		var id string = entry.Hash
-		// Add to ES Request...
-		e.Request.Add(
-			elastic.NewBulkIndexRequest().Id(id).Doc(string(b)),
-		)
-	}
-
-}
-
-func (e *ElasticClient) newRequest() {
-
-	// Initialize New Request
-	if !(e.hasClient()) {
-		e.initializeClient()
-	}
-
-	// Add Each New Document
-	if !(e.hasRequest()) {
-		e.initializeBulkRequest()
-	}
-
-}
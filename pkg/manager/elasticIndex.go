@@ -0,0 +1,345 @@
+// Package manager ...
+package manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	elastic "github.com/olivere/elastic/v7"
+)
+
+/*
+NOTES:
+	- `IndexName` (ELASTIC_DEFAULT_INDEX) is an alias. The documents live in
+	versioned indices named `<alias>-<UTC timestamp>`, which pick up their
+	settings and mappings from the `<alias>` index template; searches and
+	writes go through the alias, so a rebuilt index can be swapped in with
+	one atomic `_aliases` request.
+	- The template is the legacy `_template` API, not the 7.8+ composable
+	one. `Properties` is `flattened` though, which needs 7.3+ and, before
+	7.10, the default (not OSS) distribution; `PutTemplate` checks the
+	cluster for it rather than have the template rejected half way.
+	- An index created by hand under the alias name (as docs/elastic.md
+	used to suggest) is removed in the same request that first points the
+	alias at a versioned index; `EnsureIndex` refuses to do that to an
+	empty one, reindexing does it once the new index is full.
+*/
+
+// shapesMappingVersion - Bump when `shapesTemplate` changes, then reindex
+const shapesMappingVersion = 3
+
+// shapesTemplate - Settings and mappings for the shapes indices. `Name` is
+// the autocomplete field, with `Category` and `State` as its contexts;
+// `Text` (the name and property values) is for free text search and
+// `Properties` for exact property filters. `Extent` is the shape's
+// bounding box, and `Sizes` is stored but not indexed.
+const shapesTemplate = `{
+	"index_patterns": [%q],
+	"version": %d,
+	"settings": {"number_of_shards": 1, "number_of_replicas": 0},
+	"mappings": {
+		"dynamic": false,
+		"properties": {
+			"Hash": {"type": "keyword"},
+			"Name": {
+				"type": "completion",
+				"contexts": [
+					{"name": "category", "type": "category", "path": "Category"},
+					{"name": "state", "type": "category", "path": "State"}
+				]
+			},
+			"Path":         {"type": "keyword"},
+			"Source":       {"type": "keyword"},
+			"Version":      {"type": "integer"},
+			"Deleted":      {"type": "boolean"},
+			"Formats":      {"type": "keyword"},
+			"Encoding":     {"type": "keyword"},
+			"Sizes":        {"type": "object", "enabled": false},
+			"Grid":         {"type": "double"},
+			"BBox":         {"type": "double", "index": false},
+			"Extent":       {"type": "geo_shape"},
+			"GeometryType": {"type": "keyword"},
+			"Category":     {"type": "keyword"},
+			"State":        {"type": "keyword"},
+			"Properties":   {"type": "flattened"},
+			"Text":         {"type": "text"}
+		}
+	}
+}`
+
+// shapeDocument - What's indexed for a shape: its meta, plus the bounding
+// box as a geo_shape (an envelope, or a point for a point) and the text
+// searched by `Search`
+type shapeDocument struct {
+	*S3UploadMeta
+	Extent *geoShape `json:"Extent,omitempty"`
+	Text   string    `json:"Text,omitempty"`
+}
+
+// geoShape - Elasticsearch's GeoJSON-like geo_shape value; an envelope's
+// coordinates are `[[minX, maxY], [maxX, minY]]`
+type geoShape struct {
+	Type        string      `json:"type"`
+	Coordinates interface{} `json:"coordinates"`
+}
+
+func newShapeDocument(entry *S3UploadMeta) shapeDocument {
+	doc := shapeDocument{S3UploadMeta: entry, Text: documentText(entry)}
+	switch b := entry.BBox; {
+	case len(b) != 4:
+	case b[0] == b[2] && b[1] == b[3]:
+		doc.Extent = &geoShape{Type: "point", Coordinates: [2]float64{b[0], b[1]}}
+	default:
+		doc.Extent = &geoShape{Type: "envelope", Coordinates: [][2]float64{{b[0], b[3]}, {b[2], b[1]}}}
+	}
+	return doc
+}
+
+// documentText - The name, then property values in key order
+func documentText(entry *S3UploadMeta) string {
+
+	var keys = make([]string, 0, len(entry.Properties))
+	for k := range entry.Properties {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var words = []string{entry.Name}
+	for _, k := range keys {
+		if v := entry.Properties[k]; v != "" && v != entry.Name {
+			words = append(words, v)
+		}
+	}
+	return strings.TrimSpace(strings.Join(words, " "))
+}
+
+// PutTemplate - Create or update the index template for the alias;
+// fails if the cluster can't map `flattened` (see NOTES)
+func (e *ElasticClient) PutTemplate() error {
+
+	e.prepare()
+
+	if err := e.checkFlattened(); err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf(shapesTemplate, e.IndexName+"-*", shapesMappingVersion)
+	if _, err := e.Client.IndexPutTemplate(e.IndexName).BodyString(body).Do(e.Context); err != nil {
+		return fmt.Errorf("elastic: put template %s: %v", e.IndexName, err)
+	}
+	return nil
+}
+
+// checkFlattened - Ask the cluster for its version and distribution
+func (e *ElasticClient) checkFlattened() error {
+
+	response, err := e.Client.PerformRequest(e.Context, elastic.PerformRequestOptions{Method: http.MethodGet, Path: "/"})
+	if err != nil {
+		return fmt.Errorf("elastic: get cluster version: %v", err)
+	}
+
+	var info struct {
+		Version struct {
+			Number       string `json:"number"`
+			BuildFlavor  string `json:"build_flavor"`
+			Distribution string `json:"distribution"`
+		} `json:"version"`
+	}
+	if err = json.Unmarshal(response.Body, &info); err != nil {
+		return fmt.Errorf("elastic: get cluster version: %v", err)
+	}
+	return supportsFlattened(info.Version.Number, info.Version.BuildFlavor, info.Version.Distribution)
+}
+
+// supportsFlattened - nil if an Elasticsearch of version `number` (e.g.
+// 7.9.3) and `flavor` (default or oss) has the `flattened` field type;
+// `distribution` is only set by OpenSearch, which doesn't
+func supportsFlattened(number string, flavor string, distribution string) error {
+
+	if distribution != "" {
+		return fmt.Errorf("elastic: %s %s has no flattened field type; Elasticsearch 7.3+ is required", distribution, number)
+	}
+
+	parts := strings.SplitN(number, ".", 3)
+	if len(parts) < 2 {
+		return fmt.Errorf("elastic: unrecognised cluster version %q", number)
+	}
+	major, errMajor := strconv.Atoi(parts[0])
+	minor, errMinor := strconv.Atoi(parts[1])
+	if errMajor != nil || errMinor != nil {
+		return fmt.Errorf("elastic: unrecognised cluster version %q", number)
+	}
+
+	switch {
+	case major < 7 || major == 7 && minor < 3:
+		return fmt.Errorf("elastic: Elasticsearch %s has no flattened field type; 7.3+ is required", number)
+	case flavor == "oss":
+		return fmt.Errorf("elastic: the OSS distribution of Elasticsearch %s has no flattened field type; use the default distribution", number)
+	}
+	return nil
+}
+
+// CreateVersionedIndex - A new, empty index for the alias (not yet behind
+// it); puts the template first
+func (e *ElasticClient) CreateVersionedIndex() (string, error) {
+
+	if err := e.PutTemplate(); err != nil {
+		return "", err
+	}
+
+	name := fmt.Sprintf("%s-%s", e.IndexName, time.Now().UTC().Format("20060102150405"))
+	if _, err := e.Client.CreateIndex(name).Do(e.Context); err != nil {
+		return "", fmt.Errorf("elastic: create index %s: %v", name, err)
+	}
+	return name, nil
+}
+
+// AliasedIndices - The indices behind the alias, sorted; none if the alias
+// doesn't exist yet
+func (e *ElasticClient) AliasedIndices() ([]string, error) {
+
+	e.prepare()
+
+	result, err := e.Client.Aliases().Alias(e.IndexName).Do(e.Context)
+	if elastic.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("elastic: get alias %s: %v", e.IndexName, err)
+	}
+
+	indices := result.IndicesByAlias(e.IndexName)
+	sort.Strings(indices)
+	return indices, nil
+}
+
+// EnsureIndex - Make sure the alias points at an index, creating the
+// first versioned index if it doesn't; returns the index behind the alias
+func (e *ElasticClient) EnsureIndex() (string, error) {
+
+	indices, err := e.AliasedIndices()
+	if err != nil {
+		return "", err
+	}
+	if len(indices) > 0 {
+		return indices[len(indices)-1], e.PutTemplate()
+	}
+
+	// Swapping in an empty index would drop a hand made one's documents
+	exists, err := e.Client.IndexExists(e.IndexName).Do(e.Context)
+	if err != nil {
+		return "", fmt.Errorf("elastic: check index %s: %v", e.IndexName, err)
+	}
+	if exists {
+		return "", fmt.Errorf("elastic: %s is an index, not an alias; reindex to replace it", e.IndexName)
+	}
+
+	index, err := e.CreateVersionedIndex()
+	if err != nil {
+		return "", err
+	}
+	if _, err = e.SwapAlias(index); err != nil {
+		return "", err
+	}
+	return index, nil
+}
+
+// SwapAlias - Atomically point the alias at `index` alone; returns the
+// indices it pointed at before
+func (e *ElasticClient) SwapAlias(index string) ([]string, error) {
+
+	previous, err := e.AliasedIndices()
+	if err != nil {
+		return nil, err
+	}
+
+	actions := []elastic.AliasAction{elastic.NewAliasAddAction(e.IndexName).Index(index)}
+	for _, old := range previous {
+		if old != index {
+			actions = append(actions, elastic.NewAliasRemoveAction(e.IndexName).Index(old))
+		}
+	}
+
+	// A concrete index with the alias's name would block it; see NOTES
+	if len(previous) == 0 {
+		exists, err := e.Client.IndexExists(e.IndexName).Do(e.Context)
+		if err != nil {
+			return nil, fmt.Errorf("elastic: check index %s: %v", e.IndexName, err)
+		}
+		if exists {
+			actions = append(actions, elastic.NewAliasRemoveIndexAction(e.IndexName))
+			previous = append(previous, e.IndexName)
+		}
+	}
+
+	if _, err = e.Client.Alias().Action(actions...).Do(e.Context); err != nil {
+		return nil, fmt.Errorf("elastic: point %s at %s: %v", e.IndexName, index, err)
+	}
+	return previous, nil
+}
+
+// Refresh - Make everything written to `index` searchable
+func (e *ElasticClient) Refresh(index string) error {
+	e.prepare()
+	if _, err := e.Client.Refresh(index).Do(e.Context); err != nil {
+		return fmt.Errorf("elastic: refresh %s: %v", index, err)
+	}
+	return nil
+}
+
+// DeleteIndices - Remove old versioned indices; refuses anything still
+// behind the alias or not named for it
+func (e *ElasticClient) DeleteIndices(indices ...string) error {
+
+	current, err := e.AliasedIndices()
+	if err != nil {
+		return err
+	}
+
+	for _, index := range indices {
+		for _, c := range current {
+			if index == c {
+				return fmt.Errorf("elastic: %s is still behind %s", index, e.IndexName)
+			}
+		}
+		if !strings.HasPrefix(index, e.IndexName+"-") {
+			return fmt.Errorf("elastic: %s is not a versioned %s index", index, e.IndexName)
+		}
+	}
+
+	if len(indices) == 0 {
+		return nil
+	}
+	if _, err = e.Client.DeleteIndex(indices...).Do(e.Context); err != nil {
+		return fmt.Errorf("elastic: delete %s: %v", strings.Join(indices, ", "), err)
+	}
+	return nil
+}
+
+// WithIndex - A copy of the client writing to (and reading from) `index`
+// rather than the alias, e.g. to fill an index before it's swapped in
+func (e *ElasticClient) WithIndex(index string) *ElasticClient {
+	c := *e
+	c.IndexName = index
+	return &c
+}
+
+// prepare - Connect and set a context, if not yet done
+func (e *ElasticClient) prepare() {
+	if !(e.hasClient()) {
+		e.initializeClient()
+	}
+	if !(e.hasContext()) {
+		e.initializeContext()
+	}
+}
+
+// marshalShapeDocument - The indexed form of `entry`
+func marshalShapeDocument(entry *S3UploadMeta) ([]byte, error) {
+	return json.Marshal(newShapeDocument(entry))
+}
@@ -0,0 +1,30 @@
+package manager
+
+import "testing"
+
+func TestSupportsFlattened(t *testing.T) {
+
+	for _, c := range []struct {
+		number, flavor, distribution string
+		ok                           bool
+	}{
+		{"7.3.0", "default", "", true},
+		{"7.9.3", "default", "", true},
+		{"7.17.9", "default", "", true},
+		{"8.11.1", "default", "", true},
+		{"7.2.1", "default", "", false},
+		{"6.8.23", "default", "", false},
+		{"7.9.3", "oss", "", false},
+		{"7.10.2", "oss", "", false},
+		{"2.11.0", "", "opensearch", false},
+		{"", "", "", false},
+	} {
+		err := supportsFlattened(c.number, c.flavor, c.distribution)
+		if c.ok && err != nil {
+			t.Errorf("%s %s: %v, want supported", c.number, c.flavor, err)
+		}
+		if !c.ok && err == nil {
+			t.Errorf("%s %s %s: supported", c.number, c.flavor, c.distribution)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+// Package manager ...
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	elastic "github.com/olivere/elastic/v7"
+)
+
+// documentSource - `_source` fields returned by queries; the ones only
+// there to be searched are left out
+var documentSource = elastic.NewFetchSourceContext(true).Exclude("Text", "Extent")
+
+// Search - A page of shapes matching `q`, see `SearchQuery`. `Size` is
+// clamped to [1, MaxSearchSize] and defaults to DefaultSearchSize; a page
+// past `MaxSearchWindow` is an error.
+func (e *ElasticClient) Search(ctx context.Context, q SearchQuery) (*SearchResult, error) {
+
+	e.prepare()
+
+	var size = q.Size
+	switch {
+	case size <= 0:
+		size = DefaultSearchSize
+	case size > MaxSearchSize:
+		size = MaxSearchSize
+	}
+	if q.From < 0 || q.From+size > MaxSearchWindow {
+		return nil, fmt.Errorf("elastic: results past %d can't be paged to", MaxSearchWindow)
+	}
+
+	query := elastic.NewBoolQuery()
+	if text := strings.TrimSpace(q.Text); text != "" {
+		query = query.Must(elastic.NewMatchQuery("Text", text).Operator("and"))
+	} else {
+		query = query.Must(elastic.NewMatchAllQuery())
+	}
+
+	filter := func(field string, values []string) {
+		if values = nonEmpty(values); len(values) > 0 {
+			terms := make([]interface{}, len(values))
+			for i, v := range values {
+				terms[i] = v
+			}
+			query = query.Filter(elastic.NewTermsQuery(field, terms...))
+		}
+	}
+	filter("GeometryType", q.GeometryTypes)
+	filter("Category", q.Categories)
+	filter("State", q.States)
+
+	// Sorted so the same query is the same request
+	var properties = make([]string, 0, len(q.Properties))
+	for name := range q.Properties {
+		properties = append(properties, name)
+	}
+	sort.Strings(properties)
+	for _, name := range properties {
+		filter("Properties."+name, q.Properties[name])
+	}
+
+	result, err := e.Client.Search().
+		Index(e.IndexName).
+		Query(query).
+		FetchSourceContext(documentSource).
+		From(q.From).
+		Size(size).
+		TrackTotalHits(true).
+		Do(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("elastic: search %q: %v", q.Text, err)
+	}
+
+	var page = SearchResult{Shapes: []*S3UploadMeta{}}
+	if result.Hits == nil {
+		return &page, nil
+	}
+	if result.Hits.TotalHits != nil {
+		page.Total = result.Hits.TotalHits.Value
+	}
+	for _, hit := range result.Hits.Hits {
+		var entry S3UploadMeta
+		if err := json.Unmarshal(hit.Source, &entry); err != nil {
+			return nil, fmt.Errorf("elastic: search %q: document %s: %v", q.Text, hit.Id, err)
+		}
+		page.Shapes = append(page.Shapes, &entry)
+	}
+	return &page, nil
+}
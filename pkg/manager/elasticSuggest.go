@@ -0,0 +1,84 @@
+// Package manager ...
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	elastic "github.com/olivere/elastic/v7"
+)
+
+// suggestName - Name of the suggester in the request and response
+const suggestName = "shapes"
+
+// Suggest - Complete `q.Prefix` against shape names; returns the matching
+// shapes' meta, best first. `Size` is clamped to [1, MaxSuggestSize] and
+// defaults to DefaultSuggestSize.
+func (e *ElasticClient) Suggest(ctx context.Context, q SuggestQuery) ([]*S3UploadMeta, error) {
+
+	e.prepare()
+
+	var size = q.Size
+	switch {
+	case size <= 0:
+		size = DefaultSuggestSize
+	case size > MaxSuggestSize:
+		size = MaxSuggestSize
+	}
+
+	prefix := strings.TrimSpace(q.Prefix)
+	if prefix == "" {
+		return []*S3UploadMeta{}, nil
+	}
+
+	suggester := elastic.NewCompletionSuggester(suggestName).
+		Field("Name").
+		Size(size)
+
+	if q.Fuzzy {
+		suggester = suggester.PrefixWithOptions(prefix, elastic.NewFuzzyCompletionSuggesterOptions().
+			EditDistance("AUTO").
+			UnicodeAware(true))
+	} else {
+		suggester = suggester.Prefix(prefix)
+	}
+
+	if values := nonEmpty(q.Categories); len(values) > 0 {
+		suggester = suggester.ContextQuery(elastic.NewSuggesterCategoryQuery("category", values...))
+	}
+	if values := nonEmpty(q.States); len(values) > 0 {
+		suggester = suggester.ContextQuery(elastic.NewSuggesterCategoryQuery("state", values...))
+	}
+
+	result, err := e.Client.Search().
+		Index(e.IndexName).
+		SearchSource(elastic.NewSearchSource().Suggester(suggester).FetchSourceContext(documentSource)).
+		Do(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("elastic: suggest %q: %v", prefix, err)
+	}
+
+	var entries = []*S3UploadMeta{}
+	for _, suggestion := range result.Suggest[suggestName] {
+		for _, option := range suggestion.Options {
+			var entry S3UploadMeta
+			if err := json.Unmarshal(option.Source, &entry); err != nil {
+				return nil, fmt.Errorf("elastic: suggest %q: document %s: %v", prefix, option.Id, err)
+			}
+			entries = append(entries, &entry)
+		}
+	}
+	return entries, nil
+}
+
+func nonEmpty(values []string) []string {
+	var kept []string
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			kept = append(kept, v)
+		}
+	}
+	return kept
+}
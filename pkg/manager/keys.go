@@ -0,0 +1,132 @@
+// Package manager ...
+package manager
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// MetaPrefix - Folder in the target bucket holding shape metadata
+const MetaPrefix = "meta/"
+
+// ManifestPrefix - Folder in the target bucket holding source manifests
+const ManifestPrefix = "manifests/"
+
+// CheckpointPrefix - Folder in the target bucket holding checkpoints for
+// partially processed sources
+const CheckpointPrefix = "checkpoints/"
+
+// RefsPrefix - Folder in the target bucket holding the sources that
+// produce each shape
+const RefsPrefix = "refs/"
+
+const metaSuffix = "_meta.json"
+
+// Shape data formats; GeoJSON is the default and the only one the web
+// service reads
+const (
+	FormatGeoJSON    = "geojson"
+	FormatGeobuf     = "geobuf"
+	FormatFlatGeobuf = "fgb"
+)
+
+// formatExtensions - Data object extension per format
+var formatExtensions = map[string]string{
+	FormatGeoJSON:    ".geojson",
+	FormatGeobuf:     ".pbf",
+	FormatFlatGeobuf: ".fgb",
+}
+
+// contentTypes - By object extension; anything else is
+// application/octet-stream
+var contentTypes = map[string]string{
+	".geojson": "application/geo+json",
+	".json":    "application/json",
+	".pbf":     "application/x-protobuf",
+	".fgb":     "application/flatgeobuf",
+}
+
+// IsDataFormat - Whether `format` is one of the shape data formats
+func IsDataFormat(format string) bool {
+	_, ok := formatExtensions[format]
+	return ok
+}
+
+// ContentType - The Content-Type an object is stored with, by its key's
+// extension
+func ContentType(key string) string {
+	if t, ok := contentTypes[strings.ToLower(path.Ext(key))]; ok {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+// ShapeKeys - Every object key derived from a shape's hash. All code that
+// reads or writes shape objects should go through `KeysForHash` so the
+// upload worker, the meta `Path`, and the indexer never disagree.
+type ShapeKeys struct {
+	Hash string
+	Data string
+	Meta string
+}
+
+// KeysForHash - Derive the object keys for a shape hash
+func KeysForHash(hash string) ShapeKeys {
+	return ShapeKeys{
+		Hash: hash,
+		Data: hash + formatExtensions[FormatGeoJSON],
+		Meta: fmt.Sprintf("%s%s%s", MetaPrefix, hash, metaSuffix),
+	}
+}
+
+// DataKey - Key of the shape's data object in `format`; `Data` for
+// GeoJSON
+func (k ShapeKeys) DataKey(format string) string {
+	return k.Hash + formatExtensions[format]
+}
+
+// HashFromMetaKey - Inverse of `KeysForHash(hash).Meta`; returns false
+// if `key` isn't a meta object key
+func HashFromMetaKey(key string) (string, bool) {
+	if !strings.HasPrefix(key, MetaPrefix) || !strings.HasSuffix(key, metaSuffix) {
+		return "", false
+	}
+	hash := strings.TrimSuffix(strings.TrimPrefix(key, MetaPrefix), metaSuffix)
+	return hash, hash != "" && !strings.Contains(hash, "/")
+}
+
+// ManifestKey - Key of the manifest for a source, where `source` is the
+// source object's `bucket/key`
+func ManifestKey(source string) string {
+	return fmt.Sprintf("%s%s.json", ManifestPrefix, source)
+}
+
+// CheckpointKey - Key of the checkpoint for a source (`bucket/key`)
+func CheckpointKey(source string) string {
+	return fmt.Sprintf("%s%s.json", CheckpointPrefix, source)
+}
+
+// RefsKey - Key of the source references for a shape hash
+func RefsKey(hash string) string {
+	return fmt.Sprintf("%s%s.json", RefsPrefix, hash)
+}
+
+// URI - s3:// URI of `key` in `bucket`
+func URI(bucket string, key string) string {
+	return fmt.Sprintf("s3://%s/%s", bucket, key)
+}
+
+// NewS3UploadObject - Build the upload for a reduced feature; `Path`
+// points at the meta object the worker will write
+func NewS3UploadObject(bucket string, hash string, name string, data []byte) *S3UploadObject {
+	keys := KeysForHash(hash)
+	return &S3UploadObject{
+		Data: data,
+		Meta: S3UploadMeta{
+			Hash: hash,
+			Name: name,
+			Path: URI(bucket, keys.Meta),
+		},
+	}
+}
@@ -0,0 +1,124 @@
+// Package manager ...
+package manager
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+// SourceManifest - Every shape hash produced by the latest processing of
+// a source file. Re-processing diffs against this to find new and removed
+// shapes instead of skipping anything that already exists.
+type SourceManifest struct {
+	Source    string    `json:"Source"`
+	Version   int       `json:"Version"`
+	Hashes    []string  `json:"Hashes"`
+	UpdatedAt time.Time `json:"UpdatedAt"`
+}
+
+// Diff - Compare the manifest to the `hashes` produced by a new run;
+// `added` are not in the manifest, `removed` are not in `hashes`
+func (m *SourceManifest) Diff(hashes []string) (added []string, removed []string) {
+
+	var previous = make(map[string]bool, len(m.Hashes))
+	var current = make(map[string]bool, len(hashes))
+
+	for _, h := range m.Hashes {
+		previous[h] = true
+	}
+
+	for _, h := range hashes {
+		if !previous[h] && !current[h] {
+			added = append(added, h)
+		}
+		current[h] = true
+	}
+
+	for _, h := range m.Hashes {
+		if !current[h] {
+			removed = append(removed, h)
+		}
+	}
+
+	return added, removed
+}
+
+// Next - The manifest for the following version, holding `hashes`
+func (m *SourceManifest) Next(hashes []string) *SourceManifest {
+
+	var seen = make(map[string]bool, len(hashes))
+	var unique = make([]string, 0, len(hashes))
+
+	for _, h := range hashes {
+		if !seen[h] {
+			unique = append(unique, h)
+			seen[h] = true
+		}
+	}
+	sort.Strings(unique)
+
+	return &SourceManifest{
+		Source:    m.Source,
+		Version:   m.Version + 1,
+		Hashes:    unique,
+		UpdatedAt: time.Now().UTC(),
+	}
+}
+
+// ReadManifest - Fetch the manifest for `source` from `bucket`; a source
+// that was never processed gets an empty, version 0 manifest
+func ReadManifest(store ObjectStore, bucket string, source string) (*SourceManifest, error) {
+
+	var m = SourceManifest{Source: source}
+
+	b, err := store.GetObject(bucket, ManifestKey(source))
+	if err != nil {
+		if err == ErrNotFound {
+			return &m, nil
+		}
+		return nil, err
+	}
+
+	if err = json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// WriteManifest - Store `m` in `bucket`, replacing the previous version
+func WriteManifest(store ObjectStore, bucket string, m *SourceManifest) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return store.PutObject(bucket, ManifestKey(m.Source), b)
+}
+
+// TombstoneShape - Mark a shape removed from `source` by rewriting its
+// meta object with `Deleted` set; the indexer drops deleted entries. The
+// data object is kept so links handed out earlier don't break.
+func TombstoneShape(store ObjectStore, bucket string, hash string, source string, version int) error {
+
+	var keys = KeysForHash(hash)
+	var meta = S3UploadMeta{
+		Hash: hash,
+		Path: URI(bucket, keys.Meta),
+	}
+
+	// Keep the name etc. if the old meta is readable; a bare tombstone is
+	// still enough for the indexer to delete by hash
+	if b, err := store.GetObject(bucket, keys.Meta); err == nil {
+		json.Unmarshal(b, &meta)
+	}
+
+	meta.Source = source
+	meta.Version = version
+	meta.Deleted = true
+
+	b, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return store.PutObject(bucket, keys.Meta, b)
+}
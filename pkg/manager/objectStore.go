@@ -0,0 +1,136 @@
+// Package manager ...
+package manager
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrNotFound - Returned by `ObjectStore.GetObject` for a missing key
+var ErrNotFound = errors.New("manager: object not found")
+
+// ObjectStore - Bucket/key storage for sources and shapes; implemented by
+// `S3Session` and, for running the pipeline on a laptop, `LocalStorage`
+type ObjectStore interface {
+	GetObject(bucket string, key string) ([]byte, error)
+	GetEncodedObject(bucket string, key string) ([]byte, string, error)
+	PutObject(bucket string, key string, data []byte) error
+	PutEncodedObject(bucket string, key string, data []byte, encoding string) error
+	HasObject(bucket string, key string) (bool, error)
+	DeleteObject(bucket string, key string) error
+	ListObjectKeys(bucket string, prefix string) ([]string, error)
+	ListObjectKeysSince(bucket string, prefix string, since time.Time) ([]string, error)
+}
+
+// LocalStorage - `ObjectStore` on the local filesystem. Object `key` in
+// `bucket` is the file `Root/bucket/key`, unless `Buckets` maps the bucket
+// to a directory of its own.
+type LocalStorage struct {
+	Root    string
+	Buckets map[string]string
+}
+
+// NewLocalStorage - Local storage rooted at `root`
+func NewLocalStorage(root string) *LocalStorage {
+	return &LocalStorage{
+		Root:    root,
+		Buckets: make(map[string]string),
+	}
+}
+
+func (l *LocalStorage) path(bucket string, key string) string {
+	dir, ok := l.Buckets[bucket]
+	if !ok {
+		dir = filepath.Join(l.Root, bucket)
+	}
+	return filepath.Join(dir, filepath.FromSlash(key))
+}
+
+// GetObject - Read `bucket/key`
+func (l *LocalStorage) GetObject(bucket string, key string) ([]byte, error) {
+	b, err := ioutil.ReadFile(l.path(bucket, key))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return b, err
+}
+
+// GetEncodedObject - `GetObject`; files carry no Content-Encoding, so
+// it's always empty (compressed files are recognized by their content)
+func (l *LocalStorage) GetEncodedObject(bucket string, key string) ([]byte, string, error) {
+	b, err := l.GetObject(bucket, key)
+	return b, "", err
+}
+
+// PutObject - Write `bucket/key`, creating directories as needed
+func (l *LocalStorage) PutObject(bucket string, key string, data []byte) error {
+	path := l.path(bucket, key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// PutEncodedObject - `PutObject`; the encoding isn't recorded
+func (l *LocalStorage) PutEncodedObject(bucket string, key string, data []byte, encoding string) error {
+	return l.PutObject(bucket, key, data)
+}
+
+// HasObject - Check if `bucket/key` exists
+func (l *LocalStorage) HasObject(bucket string, key string) (bool, error) {
+	_, err := os.Stat(l.path(bucket, key))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// DeleteObject - Remove `bucket/key`; a missing key isn't an error, as
+// with S3
+func (l *LocalStorage) DeleteObject(bucket string, key string) error {
+	err := os.Remove(l.path(bucket, key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// ListObjectKeys - List every key in `bucket` starting with `prefix`
+func (l *LocalStorage) ListObjectKeys(bucket string, prefix string) ([]string, error) {
+	return l.ListObjectKeysSince(bucket, prefix, time.Time{})
+}
+
+// ListObjectKeysSince - `ListObjectKeys`, but only files modified at or
+// after `since`
+func (l *LocalStorage) ListObjectKeysSince(bucket string, prefix string, since time.Time) ([]string, error) {
+
+	var keys []string
+	var root = l.path(bucket, "")
+
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == root {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if info.IsDir() || info.ModTime().Before(since) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		if key := filepath.ToSlash(rel); strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+
+	return keys, err
+}
@@ -0,0 +1,39 @@
+package manager
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLocalStorageListObjectKeysSince(t *testing.T) {
+
+	store := NewLocalStorage(t.TempDir())
+	since := time.Now().Add(-time.Hour)
+
+	for key, modified := range map[string]time.Time{
+		"meta/old_meta.json": since.Add(-time.Minute),
+		"meta/new_meta.json": since.Add(time.Minute),
+		"other/new.json":     since.Add(time.Minute),
+	} {
+		if err := store.PutObject("b", key, []byte("{}")); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(store.path("b", key), modified, modified); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	keys, err := store.ListObjectKeysSince("b", MetaPrefix, since)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"meta/new_meta.json"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("since: %v, want %v", keys, want)
+	}
+
+	if keys, _ = store.ListObjectKeys("b", MetaPrefix); len(keys) != 2 {
+		t.Errorf("all: %v", keys)
+	}
+}
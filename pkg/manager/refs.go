@@ -0,0 +1,157 @@
+// Package manager ...
+package manager
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+NOTES:
+	- Two sources can produce the same shape (same canonical hash), so a
+	shape is only tombstoned once no source's manifest holds it. The
+	sources holding each hash are kept at `refs/<hash>.json`, updated when a
+	source completes: added hashes first, then removed ones.
+	- A shape without a refs object predates them; removing it from a
+	source tombstones it, as before. `RebuildShapeRefs` (migrate -refs)
+	fills them in from the manifests.
+	- Updates are read-modify-write. Two sources completing at the same
+	moment and touching the same hash can lose an update; the sources are
+	normally processed one at a time, and rebuilding fixes the refs.
+*/
+
+// ShapeRefs - The sources (`bucket/key`) whose manifest holds a shape
+type ShapeRefs struct {
+	Hash      string    `json:"Hash"`
+	Sources   []string  `json:"Sources"`
+	UpdatedAt time.Time `json:"UpdatedAt"`
+}
+
+// ReadShapeRefs - Fetch the refs for `hash`; returns nil (and no error)
+// if there aren't any
+func ReadShapeRefs(store ObjectStore, bucket string, hash string) (*ShapeRefs, error) {
+
+	var r ShapeRefs
+
+	b, err := store.GetObject(bucket, RefsKey(hash))
+	if err != nil {
+		if err == ErrNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	if err = json.Unmarshal(b, &r); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// writeShapeRefs - Store `r`, or delete it once no source is left
+func writeShapeRefs(store ObjectStore, bucket string, r *ShapeRefs) error {
+
+	if len(r.Sources) == 0 {
+		return store.DeleteObject(bucket, RefsKey(r.Hash))
+	}
+
+	sort.Strings(r.Sources)
+	r.UpdatedAt = time.Now().UTC()
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return store.PutObject(bucket, RefsKey(r.Hash), b)
+}
+
+// AddShapeRefs - Record that each of `sources` produces `hash`
+func AddShapeRefs(store ObjectStore, bucket string, hash string, sources ...string) error {
+
+	r, err := ReadShapeRefs(store, bucket, hash)
+	if err != nil {
+		return err
+	}
+	if r == nil {
+		r = &ShapeRefs{Hash: hash}
+	}
+
+	var changed bool
+	for _, source := range sources {
+		if !containsString(r.Sources, source) {
+			r.Sources = append(r.Sources, source)
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return writeShapeRefs(store, bucket, r)
+}
+
+// RemoveShapeRef - Record that `source` no longer produces `hash`;
+// returns the number of sources that still do. A shape without refs is
+// taken to have had `source` alone (see NOTES).
+func RemoveShapeRef(store ObjectStore, bucket string, hash string, source string) (int, error) {
+
+	r, err := ReadShapeRefs(store, bucket, hash)
+	if err != nil || r == nil {
+		return 0, err
+	}
+
+	var remaining = r.Sources[:0]
+	for _, s := range r.Sources {
+		if s != source {
+			remaining = append(remaining, s)
+		}
+	}
+	if len(remaining) == len(r.Sources) {
+		return len(remaining), nil
+	}
+
+	r.Sources = remaining
+	return len(remaining), writeShapeRefs(store, bucket, r)
+}
+
+// RebuildShapeRefs - Add every manifest's source to the refs of the
+// hashes it holds; returns the number of hashes seen. Refs for sources
+// that were since removed are left alone.
+func RebuildShapeRefs(store ObjectStore, bucket string) (int, error) {
+
+	keys, err := store.ListObjectKeys(bucket, ManifestPrefix)
+	if err != nil {
+		return 0, err
+	}
+
+	var sources = make(map[string][]string)
+	for _, key := range keys {
+		if !strings.HasSuffix(key, ".json") {
+			continue
+		}
+		source := strings.TrimSuffix(strings.TrimPrefix(key, ManifestPrefix), ".json")
+
+		m, err := ReadManifest(store, bucket, source)
+		if err != nil {
+			return 0, err
+		}
+		for _, h := range m.Hashes {
+			sources[h] = append(sources[h], source)
+		}
+	}
+
+	for hash, s := range sources {
+		if err := AddShapeRefs(store, bucket, hash, s...); err != nil {
+			return 0, err
+		}
+	}
+	return len(sources), nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,74 @@
+package manager
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestShapeRefs(t *testing.T) {
+
+	store := NewLocalStorage(t.TempDir())
+
+	for _, source := range []string{"src/a.geojson", "src/b.geojson", "src/a.geojson"} {
+		if err := AddShapeRefs(store, "target", "h1", source); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r, err := ReadShapeRefs(store, "target", "h1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"src/a.geojson", "src/b.geojson"}; r == nil || !reflect.DeepEqual(r.Sources, want) {
+		t.Fatalf("refs = %+v, want sources %v", r, want)
+	}
+
+	// Still produced by b, so not to be tombstoned
+	if n, err := RemoveShapeRef(store, "target", "h1", "src/a.geojson"); err != nil || n != 1 {
+		t.Fatalf("remove a = %d, %v; want 1", n, err)
+	}
+	// A source that never held it changes nothing
+	if n, err := RemoveShapeRef(store, "target", "h1", "src/c.geojson"); err != nil || n != 1 {
+		t.Fatalf("remove c = %d, %v; want 1", n, err)
+	}
+	if n, err := RemoveShapeRef(store, "target", "h1", "src/b.geojson"); err != nil || n != 0 {
+		t.Fatalf("remove b = %d, %v; want 0", n, err)
+	}
+	if ok, _ := store.HasObject("target", RefsKey("h1")); ok {
+		t.Error("refs without sources weren't deleted")
+	}
+
+	// No refs at all - the shape predates them
+	if n, err := RemoveShapeRef(store, "target", "h2", "src/a.geojson"); err != nil || n != 0 {
+		t.Errorf("remove without refs = %d, %v; want 0", n, err)
+	}
+}
+
+func TestRebuildShapeRefs(t *testing.T) {
+
+	store := NewLocalStorage(t.TempDir())
+
+	for source, hashes := range map[string][]string{
+		"src/a.geojson": {"h1", "h2"},
+		"src/b.zip":     {"h2"},
+	} {
+		m := (&SourceManifest{Source: source}).Next(hashes)
+		if err := WriteManifest(store, "target", m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	n, err := RebuildShapeRefs(store, "target")
+	if err != nil || n != 2 {
+		t.Fatalf("RebuildShapeRefs = %d, %v; want 2", n, err)
+	}
+
+	for hash, want := range map[string][]string{
+		"h1": {"src/a.geojson"},
+		"h2": {"src/a.geojson", "src/b.zip"},
+	} {
+		r, err := ReadShapeRefs(store, "target", hash)
+		if err != nil || r == nil || !reflect.DeepEqual(r.Sources, want) {
+			t.Errorf("refs %s = %+v, %v; want sources %v", hash, r, err, want)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+// Package manager ...
+package manager
+
+import (
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// RetryPolicy - Exponential backoff with full jitter; attempt `n` sleeps
+// a random duration on [0, min(MaxDelay, BaseDelay * 2^n))
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+// DefaultRetryPolicy - Used by the upload workers
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 5,
+	BaseDelay:   100 * time.Millisecond,
+	MaxDelay:    5 * time.Second,
+}
+
+// Do - Call `fn` until it succeeds, returns a non-retryable error, or
+// runs out of attempts; returns the last error
+func (p RetryPolicy) Do(fn func() error) error {
+
+	var err error
+	var attempts = p.MaxAttempts
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	for attempt := 0; attempt < attempts; attempt++ {
+		if err = fn(); err == nil || !IsRetryable(err) {
+			return err
+		}
+
+		if attempt < attempts-1 {
+			time.Sleep(p.backoff(attempt))
+		}
+	}
+
+	return err
+}
+
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	ceiling := p.MaxDelay
+	if attempt < 32 {
+		if d := p.BaseDelay << uint(attempt); d > 0 && d < ceiling {
+			ceiling = d
+		}
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(ceiling)))
+}
+
+// IsRetryable - Throttling, 5xx, and connection errors are worth another
+// attempt; 4xx (bad bucket, access denied, ...) are not
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	return request.IsErrorRetryable(err) || request.IsErrorThrottle(err)
+}
+
+// isNotFound - A HEAD on a missing key returns a bare 404, not NoSuchKey
+func isNotFound(err error) bool {
+	if reqErr, ok := err.(awserr.RequestFailure); ok {
+		return reqErr.StatusCode() == http.StatusNotFound
+	}
+	return false
+}
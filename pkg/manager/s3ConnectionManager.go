@@ -3,16 +3,21 @@ package manager
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"net/http"
 	"os"
+	"strconv"
 	"sync"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
@@ -23,17 +28,110 @@ type S3Session struct {
 	session *session.Session
 }
 
-// S3UploadObject -
+// S3UploadObject - `Data` is the GeoJSON feature (nil if GeoJSON isn't
+// written); `Formats` holds any other encodings, by format
 type S3UploadObject struct {
-	Data []byte       `json:"Data"`
-	Meta S3UploadMeta `json:"Meta"`
+	Data    []byte            `json:"Data"`
+	Formats map[string][]byte `json:"-"`
+	Meta    S3UploadMeta      `json:"Meta"`
 }
 
-// S3UploadMeta -
+// SetFormat - Attach the shape encoded as `format` (see `FormatGeoJSON`
+// and friends) and list it, with its size and digest, in the meta
+func (o *S3UploadObject) SetFormat(format string, data []byte) {
+	if format == FormatGeoJSON {
+		o.Data = data
+	} else {
+		if o.Formats == nil {
+			o.Formats = make(map[string][]byte)
+		}
+		o.Formats[format] = data
+	}
+	if o.Meta.Sizes == nil {
+		o.Meta.Sizes = make(map[string]int)
+	}
+	if o.Meta.Digests == nil {
+		o.Meta.Digests = make(map[string]string)
+	}
+	o.Meta.Formats = append(o.Meta.Formats, format)
+	o.Meta.Sizes[format] = len(data)
+	o.Meta.Digests[format] = digest(data)
+}
+
+// digest - The hex SHA-256 of a data object's stored bytes, as recorded
+// in `S3UploadMeta.Digests`
+func digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// objects - Every data object to write, by format
+func (o *S3UploadObject) objects() map[string][]byte {
+	var objects = make(map[string][]byte, len(o.Formats)+1)
+	if o.Data != nil {
+		objects[FormatGeoJSON] = o.Data
+	}
+	for format, data := range o.Formats {
+		objects[format] = data
+	}
+	return objects
+}
+
+// S3UploadMeta - `Source` and `Version` record the source file (and its
+// manifest version) that last produced the shape; `Deleted` marks a
+// tombstone for a shape dropped from its source. `Formats` lists the data
+// objects written when that's not just GeoJSON, `Encoding` their
+// Content-Encoding if compressed, `Sizes` their stored bytes and `Digests`
+// the SHA-256 of those bytes, by format. `Grid` is the grid coordinates were quantized to, if any.
+// `GeometryType`, `Category`, `State` and `Properties` (the feature's
+// scalar properties, as strings) are what search filters on and shows.
 type S3UploadMeta struct {
-	Hash string `json:"Hash"`
-	Name string `json:"Name"`
-	Path string `json:"Path"`
+	Hash     string         `json:"Hash"`
+	Name     string         `json:"Name"`
+	Path     string         `json:"Path"`
+	Source   string         `json:"Source,omitempty"`
+	Version  int            `json:"Version,omitempty"`
+	Deleted  bool           `json:"Deleted,omitempty"`
+	Formats  []string       `json:"Formats,omitempty"`
+	Encoding string         `json:"Encoding,omitempty"`
+	Sizes    map[string]int `json:"Sizes,omitempty"`
+	Grid     float64        `json:"Grid,omitempty"`
+	BBox     []float64      `json:"BBox,omitempty"` // minX, minY, maxX, maxY
+
+	Digests map[string]string `json:"Digests,omitempty"`
+
+	GeometryType string `json:"GeometryType,omitempty"`
+	Category     string `json:"Category,omitempty"`
+	State        string `json:"State,omitempty"`
+
+	Properties map[string]string `json:"Properties,omitempty"`
+}
+
+// PropertyStrings - A feature's string, number and boolean properties as
+// strings, for `S3UploadMeta.Properties`; nested values are left out.
+// Numbers are float64 from GeoJSON, but integers from shapefile DBFs.
+func PropertyStrings(properties map[string]interface{}) map[string]string {
+	var strs = make(map[string]string, len(properties))
+	for k, v := range properties {
+		switch v := v.(type) {
+		case string:
+			strs[k] = v
+		case float64:
+			strs[k] = strconv.FormatFloat(v, 'f', -1, 64)
+		case int:
+			strs[k] = strconv.Itoa(v)
+		case int64:
+			strs[k] = strconv.FormatInt(v, 10)
+		case bool:
+			strs[k] = strconv.FormatBool(v)
+		case json.Number:
+			strs[k] = v.String()
+		}
+	}
+	if len(strs) == 0 {
+		return nil
+	}
+	return strs
 }
 
 // NewS3Session - Initialize S3 Connection
@@ -63,6 +161,12 @@ func (s *S3Session) initializeSession() {
 
 // DownloadFeatureFromS3 - Could return pointer to bytes instead...
 func (s *S3Session) DownloadFeatureFromS3(sourceBucket string, fileKey string) ([]byte, error) {
+	b, _, err := s.download(sourceBucket, fileKey)
+	return b, err
+}
+
+// download - The object's content, as stored, and its Content-Encoding
+func (s *S3Session) download(sourceBucket string, fileKey string) ([]byte, string, error) {
 
 	var err error
 	svc := s3.New(session.Must(s.session, err))
@@ -75,83 +179,252 @@ func (s *S3Session) DownloadFeatureFromS3(sourceBucket string, fileKey string) (
 	)
 
 	if err != nil {
-		log.Fatal(err)
+		return nil, "", err
+	}
+	defer output.Body.Close()
+
+	b, err := ioutil.ReadAll(output.Body)
+	return b, aws.StringValue(output.ContentEncoding), err
+}
+
+// UploadError - A shape the upload workers gave up on
+type UploadError struct {
+	Meta S3UploadMeta
+	Err  error
+}
+
+func (e *UploadError) Error() string {
+	return fmt.Sprintf("upload %s (%s): %v", e.Meta.Hash, e.Meta.Name, e.Err)
+}
+
+// StartUploadWorker - Originally Boosted from: https://golangcode.com/uploading-a-file-to-s3/
+// Uploads each shape from `jobs` to `store`. With an `S3Session` store,
+// retryable failures are retried per `DefaultRetryPolicy`; anything that
+// still fails is sent on `errs`, which the caller must drain.
+func StartUploadWorker(store ObjectStore, i int, targetBucket string, jobs <-chan *S3UploadObject, errs chan<- *UploadError, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	for s3Upload := range jobs {
+		log.Infof("Worker %d Recieved: %+v", i, s3Upload.Meta)
+
+		if err := uploadShape(store, targetBucket, s3Upload); err != nil {
+			errs <- &UploadError{Meta: s3Upload.Meta, Err: err}
+		}
 	}
+}
+
+// uploadShape - Write the data objects (those that don't exist yet, or
+// were stored differently) and the meta object for a single shape
+func uploadShape(store ObjectStore, targetBucket string, s3Upload *S3UploadObject) error {
 
-	body, err := ioutil.ReadAll(output.Body)
+	var keys = KeysForHash(s3Upload.Meta.Hash)
+
+	// The meta describes the stored data, so data with other content or
+	// encoding (per the previous meta) is replaced
+	previous, err := storedMeta(store, targetBucket, keys.Meta)
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+
+	for format, data := range s3Upload.objects() {
+
+		var key = keys.DataKey(format)
+		var exists bool
+
+		// Check IF File Exists - only worth it if it was stored the same way
+		if previous.sameData(&s3Upload.Meta, format) {
+			if exists, err = store.HasObject(targetBucket, key); err != nil {
+				return err
+			}
+		}
+
+		// If file DNE - Send the Main Content to Main Folder
+		if !exists {
+			if err = store.PutEncodedObject(targetBucket, key, data, s3Upload.Meta.Encoding); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Always (re)write the Meta - the shape may have been tombstoned
+	// by an earlier version of its source
+	metaContent, err := json.Marshal(s3Upload.Meta)
+	if err != nil {
+		return err
 	}
 
-	return body, nil
+	return store.PutObject(targetBucket, keys.Meta, metaContent)
+}
+
+// storedMeta - The meta object at `key`, or nil if there's none (or it
+// can't be read, in which case the data is rewritten)
+func storedMeta(store ObjectStore, bucket string, key string) (*S3UploadMeta, error) {
 
+	b, err := store.GetObject(bucket, key)
+	if err == ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var meta S3UploadMeta
+	if json.Unmarshal(b, &meta) != nil {
+		return nil, nil
+	}
+	return &meta, nil
 }
 
-// StartS3UploadWorker - Originally Boosted from: https://golangcode.com/uploading-a-file-to-s3/
-// AddObjectToS3 will upload a single file to S3, it will require a pre-built aws session
-// and will set file info like content type and encryption on the uploaded file.
-// TODO: Error logging channel
-func (s *S3Session) StartS3UploadWorker(i int, targetBucket string, jobs <-chan *S3UploadObject, wg *sync.WaitGroup) {
-	defer wg.Done()
+// sameData - Whether `m` records the `format` data object as `next`
+// would write it, byte for byte; false for a nil `m`, or one from before
+// `Digests`
+func (m *S3UploadMeta) sameData(next *S3UploadMeta, format string) bool {
+	if m == nil {
+		return false
+	}
+	stored, ok := m.Digests[format]
+	return ok && stored == next.Digests[format] && m.Encoding == next.Encoding
+}
 
-	var err error
+// GetObject - `DownloadFeatureFromS3`, but a missing key is `ErrNotFound`
+// and other failures are retried per `DefaultRetryPolicy`
+func (s *S3Session) GetObject(bucket string, key string) ([]byte, error) {
+	b, _, err := s.GetEncodedObject(bucket, key)
+	return b, err
+}
 
-	// Init Session if not Initialized
-	if s.session == nil {
-		s.initializeSession()
+// GetEncodedObject - `GetObject`, also returning the object's
+// Content-Encoding; retried per `DefaultRetryPolicy`
+func (s *S3Session) GetEncodedObject(bucket string, key string) ([]byte, string, error) {
+
+	var b []byte
+	var encoding string
+	err := DefaultRetryPolicy.Do(func() error {
+		var err error
+		b, encoding, err = s.download(bucket, key)
+		return err
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+		return nil, "", ErrNotFound
 	}
+	return b, encoding, err
+}
 
-	svc := s3.New(session.Must(s.session, err))
+// HasObject - Check if `bucket/key` exists; only a 404 means it doesn't,
+// any other error is retried, then returned
+func (s *S3Session) HasObject(bucket string, key string) (bool, error) {
 
-	// Check IF File Exists
-	for s3Upload := range jobs {
-		log.Info("Worker %d Recieved: %+v\n", i, s3Upload.Meta)
-		var fileKey string = fmt.Sprintf("%s.geojson", s3Upload.Meta.Hash)
+	var exists bool
+	svc := s.client()
 
-		// Check if Head Exists
-		output, _ := svc.HeadObject(
+	err := DefaultRetryPolicy.Do(func() error {
+		_, err := svc.HeadObject(
 			&s3.HeadObjectInput{
-				Bucket: aws.String(targetBucket), // Environment Variable
-				Key:    aws.String(fileKey),
+				Bucket: aws.String(bucket),
+				Key:    aws.String(key),
 			},
 		)
+		if isNotFound(err) {
+			return nil
+		}
+		exists = err == nil
+		return err
+	})
 
-		// If file DNE - Send to S3
-		if output.ContentLength == nil {
-
-			// Send the Main Content to Main Folder
-			_, err = svc.PutObject(&s3.PutObjectInput{
-				Bucket:               aws.String(targetBucket),
-				Key:                  aws.String(fileKey),
-				Body:                 bytes.NewReader(s3Upload.Data), // QUESTION: Does this waste space???
-				ContentLength:        aws.Int64(int64(len(s3Upload.Data))),
-				ContentType:          aws.String(http.DetectContentType(s3Upload.Data)),
-				ContentDisposition:   aws.String("attachment"),
-				ServerSideEncryption: aws.String("AES256"),
-				ACL:                  aws.String("private"),
-			})
-			if err != nil {
-				log.Warn(err)
-			}
+	return exists, err
+}
+
+// PutObject - Write `data` to `bucket/key`, replacing any existing
+// object; retried per `DefaultRetryPolicy`
+func (s *S3Session) PutObject(bucket string, key string, data []byte) error {
+	return s.PutEncodedObject(bucket, key, data, "")
+}
 
-			// Send the Meta to a Meta Folder
-
-			// Generate Metadata Object
-			metaContent, _ := json.Marshal(s3Upload.Meta)
-
-			_, err = svc.PutObject(&s3.PutObjectInput{
-				Bucket:               aws.String(targetBucket),
-				Key:                  aws.String(fmt.Sprintf("meta/%s_meta.json", s3Upload.Meta.Hash)),
-				Body:                 bytes.NewReader(metaContent), // QUESTION: Does this waste space???
-				ContentLength:        aws.Int64(int64(len(metaContent))),
-				ContentType:          aws.String(http.DetectContentType(metaContent)),
-				ContentDisposition:   aws.String("attachment"),
-				ServerSideEncryption: aws.String("AES256"),
-				ACL:                  aws.String("private"),
-			})
-			if err != nil {
-				log.Warn(err)
+// PutEncodedObject - `PutObject` for data already compressed with
+// Content-Encoding `encoding` (none if empty)
+func (s *S3Session) PutEncodedObject(bucket string, key string, data []byte, encoding string) error {
+	svc := s.client()
+	return DefaultRetryPolicy.Do(func() error {
+		return putObject(svc, bucket, key, data, encoding)
+	})
+}
+
+// DeleteObject - Remove `bucket/key`; retried per `DefaultRetryPolicy`
+func (s *S3Session) DeleteObject(bucket string, key string) error {
+	svc := s.client()
+	return DefaultRetryPolicy.Do(func() error {
+		_, err := svc.DeleteObject(
+			&s3.DeleteObjectInput{
+				Bucket: aws.String(bucket),
+				Key:    aws.String(key),
+			},
+		)
+		return err
+	})
+}
+
+// ListObjectKeys - List every key in `bucket` starting with `prefix`,
+// following continuation tokens
+func (s *S3Session) ListObjectKeys(bucket string, prefix string) ([]string, error) {
+	return s.ListObjectKeysSince(bucket, prefix, time.Time{})
+}
+
+// ListObjectKeysSince - `ListObjectKeys`, but only objects last modified
+// at or after `since` (by S3's clock)
+func (s *S3Session) ListObjectKeysSince(bucket string, prefix string, since time.Time) ([]string, error) {
+
+	var keys []string
+
+	err := s.client().ListObjectsV2Pages(
+		&s3.ListObjectsV2Input{
+			Bucket: aws.String(bucket),
+			Prefix: aws.String(prefix),
+		},
+		func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+			for _, object := range page.Contents {
+				if !aws.TimeValue(object.LastModified).Before(since) {
+					keys = append(keys, aws.StringValue(object.Key))
+				}
 			}
-		}
+			return true
+		},
+	)
+
+	return keys, err
+}
+
+// Ping - Check `bucket` exists and can be reached; bound by `ctx`, not
+// retried
+func (s *S3Session) Ping(ctx context.Context, bucket string) error {
+	_, err := s.client().HeadBucketWithContext(ctx, &s3.HeadBucketInput{
+		Bucket: aws.String(bucket),
+	})
+	return err
+}
+
+func (s *S3Session) client() *s3.S3 {
+	if s.session == nil {
+		s.initializeSession()
+	}
+	return s3.New(s.session)
+}
+
+func putObject(svc *s3.S3, bucket string, key string, data []byte, encoding string) error {
+	var contentEncoding *string
+	if encoding != "" {
+		contentEncoding = aws.String(encoding)
 	}
+
+	_, err := svc.PutObject(&s3.PutObjectInput{
+		Bucket:               aws.String(bucket),
+		Key:                  aws.String(key),
+		Body:                 bytes.NewReader(data), // QUESTION: Does this waste space???
+		ContentLength:        aws.Int64(int64(len(data))),
+		ContentType:          aws.String(ContentType(key)),
+		ContentEncoding:      contentEncoding,
+		ContentDisposition:   aws.String("attachment"),
+		ServerSideEncryption: aws.String("AES256"),
+		ACL:                  aws.String("private"),
+	})
+	return err
 }
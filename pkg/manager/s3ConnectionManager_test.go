@@ -0,0 +1,103 @@
+package manager
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestPropertyStrings(t *testing.T) {
+
+	got := PropertyStrings(map[string]interface{}{
+		"name":   "Springfield",
+		"GEOID":  int64(1772000),
+		"count":  3,
+		"area":   12.5,
+		"big":    1e21,
+		"coast":  false,
+		"pop":    json.Number("116250"),
+		"nested": map[string]interface{}{"a": 1},
+		"none":   nil,
+	})
+
+	want := map[string]string{
+		"name":  "Springfield",
+		"GEOID": "1772000",
+		"count": "3",
+		"area":  "12.5",
+		"big":   "1000000000000000000000",
+		"coast": "false",
+		"pop":   "116250",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("PropertyStrings = %v, want %v", got, want)
+	}
+
+	if got := PropertyStrings(map[string]interface{}{"nested": []interface{}{1}}); got != nil {
+		t.Errorf("PropertyStrings of nothing scalar = %v, want nil", got)
+	}
+}
+
+// countingStore - Counts the data objects written
+type countingStore struct {
+	ObjectStore
+	puts int
+}
+
+func (s *countingStore) PutEncodedObject(bucket string, key string, data []byte, encoding string) error {
+	s.puts++
+	return s.ObjectStore.PutEncodedObject(bucket, key, data, encoding)
+}
+
+func TestUploadShapeRewritesChangedData(t *testing.T) {
+
+	store := &countingStore{ObjectStore: NewLocalStorage(t.TempDir())}
+	keys := KeysForHash("h1")
+
+	upload := func(encoding string, grid float64, data string) {
+		o := NewS3UploadObject("target", "h1", "name", nil)
+		o.Meta.Encoding = encoding
+		o.Meta.Grid = grid
+		o.SetFormat(FormatGeoJSON, []byte(data))
+		if err := uploadShape(store, "target", o); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stored := func() string {
+		b, err := store.GetObject("target", keys.Data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+
+	upload("", 0, "plain")
+	for _, c := range []struct {
+		encoding  string
+		grid      float64
+		data      string
+		rewritten bool
+	}{
+		{"", 0, "plain", false},        // Stored the same way - kept
+		{"", 0, "other", true},         // Same size, other content
+		{"gzip", 0, "other", true},     // Encoding changed
+		{"gzip", 0.01, "other", false}, // Grid changed, same bytes
+		{"gzip", 0.01, "longer", true},
+	} {
+		puts := store.puts
+		upload(c.encoding, c.grid, c.data)
+		if got := store.puts > puts; got != c.rewritten {
+			t.Errorf("after %+v, rewritten = %v", c, got)
+		}
+		if got := stored(); got != c.data {
+			t.Errorf("after %+v, data = %q", c, got)
+		}
+	}
+
+	// Data missing despite the meta
+	store.DeleteObject("target", keys.Data)
+	upload("gzip", 0.01, "longer")
+	if got := stored(); got != "longer" {
+		t.Errorf("missing data not rewritten, got %q", got)
+	}
+}
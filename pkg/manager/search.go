@@ -0,0 +1,59 @@
+// Package manager ...
+package manager
+
+import (
+	"context"
+)
+
+// SearchBackend - What the web service queries shapes through;
+// implemented by `ElasticClient`. `Ping` checks it can be queried.
+type SearchBackend interface {
+	Ping(ctx context.Context) error
+	Suggest(ctx context.Context, q SuggestQuery) ([]*S3UploadMeta, error)
+	Search(ctx context.Context, q SearchQuery) (*SearchResult, error)
+}
+
+// Suggestion sizes
+const (
+	DefaultSuggestSize = 5
+	MaxSuggestSize     = 25
+)
+
+// SuggestQuery - A prefix to complete on `Name`. `Fuzzy` allows typos
+// (one edit for 3-5 characters, two beyond). `Categories` and `States`
+// limit results to shapes with one of those context values; empty means
+// any.
+type SuggestQuery struct {
+	Prefix     string
+	Size       int
+	Fuzzy      bool
+	Categories []string
+	States     []string
+}
+
+// Search page sizes; `From + Size` can't pass `MaxSearchWindow`
+const (
+	DefaultSearchSize = 20
+	MaxSearchSize     = 100
+	MaxSearchWindow   = 10000
+)
+
+// SearchQuery - Shapes matching `Text` (against the name and property
+// values; empty matches everything) and every filter. Each filter is a
+// list of accepted values; `Properties` filters each named property on
+// its exact value.
+type SearchQuery struct {
+	Text          string
+	Properties    map[string][]string
+	GeometryTypes []string
+	Categories    []string
+	States        []string
+	From          int
+	Size          int
+}
+
+// SearchResult - One page of matches, best first, and how many there are
+type SearchResult struct {
+	Total  int64
+	Shapes []*S3UploadMeta
+}
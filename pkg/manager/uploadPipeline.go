@@ -0,0 +1,72 @@
+// Package manager ...
+package manager
+
+import (
+	"context"
+	"sync"
+)
+
+// UploadPipeline - A pool of upload workers scoped to one batch of work
+// (e.g. one Lambda invocation). Create one per batch with
+// `NewUploadPipeline`, feed it with `Send`, and drain it with `Close`;
+// pipelines share nothing, so concurrent invocations can't interfere.
+type UploadPipeline struct {
+	jobs      chan *S3UploadObject
+	errs      chan *UploadError
+	wg        sync.WaitGroup
+	collected chan struct{}
+	failures  []*UploadError
+	sent      int
+}
+
+// NewUploadPipeline - Start `concurrency` workers (at least 1) uploading
+// to `targetBucket` in `store`
+func NewUploadPipeline(store ObjectStore, targetBucket string, concurrency int) *UploadPipeline {
+
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	p := &UploadPipeline{
+		jobs:      make(chan *S3UploadObject),
+		errs:      make(chan *UploadError),
+		collected: make(chan struct{}),
+	}
+
+	for i := 0; i < concurrency; i++ {
+		p.wg.Add(1)
+		go StartUploadWorker(store, i, targetBucket, p.jobs, p.errs, &p.wg)
+	}
+
+	// Collect failures while the workers run
+	go func() {
+		defer close(p.collected)
+		for err := range p.errs {
+			p.failures = append(p.failures, err)
+		}
+	}()
+
+	return p
+}
+
+// Send - Queue an upload, blocking until a worker takes it or `ctx` is
+// done. Not safe for concurrent use, and must not be called after `Close`.
+func (p *UploadPipeline) Send(ctx context.Context, upload *S3UploadObject) error {
+	select {
+	case p.jobs <- upload:
+		p.sent++
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close - Wait for every queued upload to finish; returns the number of
+// uploads sent and those that failed
+func (p *UploadPipeline) Close() (int, []*UploadError) {
+	close(p.jobs)
+	p.wg.Wait()
+	close(p.errs)
+	<-p.collected
+	return p.sent, p.failures
+}
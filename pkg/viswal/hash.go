@@ -0,0 +1,151 @@
+// Package viswal -
+package viswal
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"math"
+	"sort"
+	"strings"
+
+	geojson "github.com/paulmach/go.geojson"
+)
+
+// hashPrecision - Coordinates are rounded to this many decimal places
+// before hashing, so float formatting noise (e.g. 0.1+0.2) doesn't
+// change a shape's identity. 1e-9 deg is well under a millimeter.
+const hashPrecision = 1e9
+
+// DefaultHashProperties - Properties that contribute to a shape's
+// identity when the caller doesn't specify any.
+var DefaultHashProperties = []string{"name"}
+
+// ParseHashProperties - A comma separated list of hash properties, as
+// given in `SHAPE_HASH_PROPERTIES` or -hash-properties; names are trimmed
+// and empty entries dropped. `DefaultHashProperties` if none are left.
+// Every writer of shape keys must parse the list the same way, or the same
+// shape hashes differently.
+func ParseHashProperties(list string) []string {
+	var properties []string
+	for _, p := range strings.Split(list, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			properties = append(properties, p)
+		}
+	}
+	if len(properties) == 0 {
+		return DefaultHashProperties
+	}
+	return properties
+}
+
+// CanonicalHash - Returns the hex SHA-256 of a feature's normalized
+// geometry plus the values of the selected `properties`. Unlike hashing
+// the marshaled feature, the result doesn't change when properties are
+// reordered, unrelated properties (e.g. `Order`) are added, or
+// coordinates are re-serialized with a different float format.
+func CanonicalHash(f *geojson.Feature, properties []string) (string, error) {
+
+	if f == nil || f.Geometry == nil {
+		return "", fmt.Errorf("viswal: cannot hash feature without geometry")
+	}
+
+	h := sha256.New()
+	if err := writeGeometry(h, f.Geometry); err != nil {
+		return "", err
+	}
+
+	// Sort (a copy of) the property names so the caller's order doesn't matter
+	var keys = append([]string{}, properties...)
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		v, ok := f.Properties[k]
+		if !ok {
+			continue
+		}
+
+		// encoding/json sorts map keys, so nested objects are stable too
+		b, err := json.Marshal(v)
+		if err != nil {
+			return "", fmt.Errorf("viswal: cannot hash property %q: %v", k, err)
+		}
+		writeString(h, k)
+		writeString(h, string(b))
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// writeGeometry - Writes a length-prefixed, type-tagged encoding of the
+// geometry to `h`; lengths are included so [[a, b], [c]] and [[a], [b, c]]
+// hash differently.
+func writeGeometry(h hash.Hash, g *geojson.Geometry) error {
+
+	writeString(h, string(g.Type))
+
+	switch g.Type {
+	case geojson.GeometryPoint:
+		writePositions(h, [][]float64{g.Point})
+	case geojson.GeometryMultiPoint:
+		writePositions(h, g.MultiPoint)
+	case geojson.GeometryLineString:
+		writePositions(h, g.LineString)
+	case geojson.GeometryMultiLineString:
+		writePaths(h, g.MultiLineString)
+	case geojson.GeometryPolygon:
+		writePaths(h, g.Polygon)
+	case geojson.GeometryMultiPolygon:
+		writeLength(h, len(g.MultiPolygon))
+		for _, polygon := range g.MultiPolygon {
+			writePaths(h, polygon)
+		}
+	case geojson.GeometryCollection:
+		writeLength(h, len(g.Geometries))
+		for _, geom := range g.Geometries {
+			if err := writeGeometry(h, geom); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("viswal: cannot hash geometry type %q", g.Type)
+	}
+
+	return nil
+}
+
+func writePaths(h hash.Hash, paths [][][]float64) {
+	writeLength(h, len(paths))
+	for _, path := range paths {
+		writePositions(h, path)
+	}
+}
+
+func writePositions(h hash.Hash, positions [][]float64) {
+	var buf [8]byte
+
+	writeLength(h, len(positions))
+	for _, p := range positions {
+		writeLength(h, len(p))
+		for _, c := range p {
+			// Add 0 to fold -0 into +0 after rounding
+			binary.BigEndian.PutUint64(buf[:], math.Float64bits(math.Round(c*hashPrecision)/hashPrecision+0))
+			h.Write(buf[:])
+		}
+	}
+}
+
+func writeLength(w io.Writer, n int) {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], uint64(n))
+	w.Write(buf[:])
+}
+
+func writeString(w io.Writer, s string) {
+	writeLength(w, len(s))
+	io.WriteString(w, s)
+}
@@ -0,0 +1,201 @@
+// Package viswal -
+package viswal
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	geojson "github.com/paulmach/go.geojson"
+)
+
+/*
+NOTES:
+	- Quantization snaps x and y to a grid (Z and M are left alone), then
+	cleans up what rounding breaks: consecutive duplicate vertices are
+	dropped, and rings are re-validated - a ring must still have 4
+	positions, be closed and enclose some area, and keeps its original
+	winding. Rings that fail are dropped; a polygon whose outer ring fails
+	is dropped with its holes. Lines need 2 vertices. A geometry with
+	nothing left is `ErrCollapsed`.
+	- Apply it after `SimplifyGeometry`, and before `ReduceGeometry` so
+	`Order` lines up with the quantized vertices.
+*/
+
+// ErrCollapsed - Nothing of the geometry survives quantization
+var ErrCollapsed = errors.New("viswal: geometry collapses when quantized")
+
+// DecimalGrid - The grid that rounds to `decimals` decimal places
+func DecimalGrid(decimals int) float64 {
+	return math.Pow10(-decimals)
+}
+
+// QuantizeGeometry - Returns a copy of `geom` with coordinates snapped to
+// multiples of `grid` (see NOTES); a grid <= 0 returns `geom` as is
+func QuantizeGeometry(geom *geojson.Geometry, grid float64) (*geojson.Geometry, error) {
+	if grid <= 0 || geom == nil {
+		return geom, nil
+	}
+	quantized, err := quantizeGeometry(geom, newQuantizer(grid))
+	if err == nil && CountVertices(quantized) == 0 {
+		return nil, ErrCollapsed
+	}
+	return quantized, err
+}
+
+// quantizer - Rounds to a grid. A decimal grid (1/grid is a whole
+// number) rounds by multiplying, so 0.1 + 0.2 comes out as 0.3, not
+// 0.30000000000000004.
+type quantizer struct {
+	grid  float64
+	scale float64
+}
+
+func newQuantizer(grid float64) quantizer {
+	q := quantizer{grid: grid}
+	if scale := 1 / grid; math.Abs(scale-math.Round(scale)) < 1e-9*scale {
+		q.scale = math.Round(scale)
+	}
+	return q
+}
+
+func (q quantizer) round(v float64) float64 {
+	if q.scale > 0 {
+		return math.Round(v*q.scale) / q.scale
+	}
+	return math.Round(v/q.grid) * q.grid
+}
+
+func (q quantizer) position(p []float64) []float64 {
+	var out = append([]float64(nil), p...)
+	for i := 0; i < len(out) && i < 2; i++ {
+		out[i] = q.round(out[i])
+	}
+	return out
+}
+
+// path - Quantize and drop consecutive duplicates (by x, y)
+func (q quantizer) path(path [][]float64) [][]float64 {
+	var out [][]float64
+	for _, p := range path {
+		p = q.position(p)
+		if n := len(out); n > 0 && out[n-1][0] == p[0] && out[n-1][1] == p[1] {
+			continue
+		}
+		out = append(out, p)
+	}
+	return out
+}
+
+// ring - `path`, or nil if the ring no longer holds up
+func (q quantizer) ring(ring [][]float64) [][]float64 {
+
+	out := q.path(ring)
+	if len(out) < 4 {
+		return nil
+	}
+	first, last := out[0], out[len(out)-1]
+	if first[0] != last[0] || first[1] != last[1] {
+		return nil
+	}
+
+	before, after := ringArea(ring), ringArea(out)
+	if after == 0 {
+		return nil
+	}
+	if (before < 0) != (after < 0) {
+		for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+			out[i], out[j] = out[j], out[i]
+		}
+	}
+	return out
+}
+
+// polygon - nil if the outer ring fails
+func (q quantizer) polygon(rings [][][]float64) [][][]float64 {
+	var out [][][]float64
+	for i, ring := range rings {
+		r := q.ring(ring)
+		if r == nil {
+			if i == 0 {
+				return nil
+			}
+			continue
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+func (q quantizer) line(line [][]float64) [][]float64 {
+	out := q.path(line)
+	if len(out) < 2 {
+		return nil
+	}
+	return out
+}
+
+func quantizeGeometry(geom *geojson.Geometry, q quantizer) (*geojson.Geometry, error) {
+
+	switch geom.Type {
+
+	case geojson.GeometryPoint:
+		return geojson.NewPointGeometry(q.position(geom.Point)), nil
+
+	case geojson.GeometryMultiPoint:
+		var points = make([][]float64, len(geom.MultiPoint))
+		for i, p := range geom.MultiPoint {
+			points[i] = q.position(p)
+		}
+		return geojson.NewMultiPointGeometry(points...), nil
+
+	case geojson.GeometryLineString:
+		return geojson.NewLineStringGeometry(q.line(geom.LineString)), nil
+
+	case geojson.GeometryMultiLineString:
+		var lines [][][]float64
+		for _, line := range geom.MultiLineString {
+			if l := q.line(line); l != nil {
+				lines = append(lines, l)
+			}
+		}
+		return geojson.NewMultiLineStringGeometry(lines...), nil
+
+	case geojson.GeometryPolygon:
+		return geojson.NewPolygonGeometry(q.polygon(geom.Polygon)), nil
+
+	case geojson.GeometryMultiPolygon:
+		var polygons [][][][]float64
+		for _, polygon := range geom.MultiPolygon {
+			if p := q.polygon(polygon); p != nil {
+				polygons = append(polygons, p)
+			}
+		}
+		return geojson.NewMultiPolygonGeometry(polygons...), nil
+
+	case geojson.GeometryCollection:
+		var geometries []*geojson.Geometry
+		for _, g := range geom.Geometries {
+			quantized, err := quantizeGeometry(g, q)
+			if err != nil {
+				return nil, err
+			}
+			if CountVertices(quantized) > 0 {
+				geometries = append(geometries, quantized)
+			}
+		}
+		return geojson.NewCollectionGeometry(geometries...), nil
+
+	default:
+		return nil, fmt.Errorf("viswal: cannot quantize geometry type %q", geom.Type)
+	}
+}
+
+// ringArea - Shoelace area, positive for counterclockwise rings
+func ringArea(ring [][]float64) float64 {
+	var area float64
+	for i := 0; i+1 < len(ring); i++ {
+		area += ring[i][0]*ring[i+1][1] - ring[i+1][0]*ring[i][1]
+	}
+	return area / 2
+}
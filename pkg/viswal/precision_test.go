@@ -0,0 +1,132 @@
+package viswal
+
+import (
+	"reflect"
+	"testing"
+
+	geojson "github.com/paulmach/go.geojson"
+)
+
+func TestQuantizePosition(t *testing.T) {
+
+	var cases = []struct {
+		name  string
+		grid  float64
+		point []float64
+		want  []float64
+	}{
+		{"decimal grid", DecimalGrid(2), []float64{1.23456, 2.34567}, []float64{1.23, 2.35}},
+		{"exact decimals", DecimalGrid(1), []float64{0.1 + 0.2, -0.15000001}, []float64{0.3, -0.2}},
+		{"z and m left alone", DecimalGrid(0), []float64{1.4, 2.6, 100.123, 7.77}, []float64{1, 3, 100.123, 7.77}},
+		{"other grid", 0.25, []float64{1.13, -0.3}, []float64{1.25, -0.25}},
+		{"coarse grid", 10, []float64{14, 15}, []float64{10, 20}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			g, err := QuantizeGeometry(geojson.NewPointGeometry(tc.point), tc.grid)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(g.Point, tc.want) {
+				t.Errorf("Point = %v, want %v", g.Point, tc.want)
+			}
+		})
+	}
+}
+
+func TestQuantizeGeometry(t *testing.T) {
+
+	var square = [][]float64{{0, 0}, {1, 0}, {1, 1}, {0, 1}, {0, 0}}
+	var clockwise = [][]float64{{0, 0}, {0, 1}, {1, 1}, {1, 0}, {0, 0}}
+	var speck = [][]float64{{0.5, 0.5}, {0.51, 0.5}, {0.51, 0.51}, {0.5, 0.5}}
+	var sliver = [][]float64{{0, 0}, {1, 0.01}, {2, 0}, {1, -0.01}, {0, 0}}
+
+	var cases = []struct {
+		name     string
+		geometry *geojson.Geometry
+		want     *geojson.Geometry
+	}{
+		{
+			"duplicates dropped",
+			geojson.NewLineStringGeometry([][]float64{{0, 0}, {0.01, 0.02}, {1, 1}, {1.04, 0.96}, {2, 2}}),
+			geojson.NewLineStringGeometry([][]float64{{0, 0}, {1, 1}, {2, 2}}),
+		},
+		{
+			"collapsed line dropped",
+			geojson.NewMultiLineStringGeometry([][]float64{{0, 0}, {0.1, 0.1}}, [][]float64{{0, 0}, {3, 3}}),
+			geojson.NewMultiLineStringGeometry([][]float64{{0, 0}, {3, 3}}),
+		},
+		{
+			"hole collapses, polygon kept",
+			geojson.NewPolygonGeometry([][][]float64{square, speck}),
+			geojson.NewPolygonGeometry([][][]float64{square}),
+		},
+		{
+			"clockwise kept",
+			geojson.NewPolygonGeometry([][][]float64{clockwise}),
+			geojson.NewPolygonGeometry([][][]float64{clockwise}),
+		},
+		{
+			// Counterclockwise, but clockwise once snapped
+			"winding restored",
+			geojson.NewPolygonGeometry([][][]float64{{{4, 3.4}, {0.5, 1.3}, {2.9, 2.8}, {3.7, 1.7}, {4, 3.4}}}),
+			geojson.NewPolygonGeometry([][][]float64{{{4, 3}, {4, 2}, {3, 3}, {1, 1}, {4, 3}}}),
+		},
+		{
+			"zero area ring dropped with its polygon",
+			geojson.NewMultiPolygonGeometry([][][]float64{sliver}, [][][]float64{square, speck}),
+			geojson.NewMultiPolygonGeometry([][][]float64{square}),
+		},
+		{
+			"empty members of a collection dropped",
+			geojson.NewCollectionGeometry(
+				geojson.NewLineStringGeometry([][]float64{{0, 0}, {0.2, 0.2}}),
+				geojson.NewPointGeometry([]float64{1.2, 3.7}),
+			),
+			geojson.NewCollectionGeometry(geojson.NewPointGeometry([]float64{1, 4})),
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			before := CountVertices(tc.geometry)
+			g, err := QuantizeGeometry(tc.geometry, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(g, tc.want) {
+				t.Errorf("got %+v, want %+v", g, tc.want)
+			}
+			if CountVertices(tc.geometry) != before {
+				t.Error("input was modified")
+			}
+		})
+	}
+}
+
+func TestQuantizeGeometryCollapsed(t *testing.T) {
+	for _, g := range []*geojson.Geometry{
+		geojson.NewLineStringGeometry([][]float64{{0, 0}, {0.1, 0.1}}),
+		geojson.NewPolygonGeometry([][][]float64{{{0.5, 0.5}, {0.51, 0.5}, {0.51, 0.51}, {0.5, 0.5}}}),
+		geojson.NewMultiPolygonGeometry([][][]float64{{{0, 0}, {1, 0.01}, {2, 0}, {1, -0.01}, {0, 0}}}),
+		geojson.NewCollectionGeometry(geojson.NewLineStringGeometry([][]float64{{0, 0}, {0.2, 0.2}})),
+	} {
+		if _, err := QuantizeGeometry(g, 1); err != ErrCollapsed {
+			t.Errorf("%s: got %v, want ErrCollapsed", g.Type, err)
+		}
+	}
+}
+
+func TestQuantizeGeometryNoGrid(t *testing.T) {
+
+	g := geojson.NewPointGeometry([]float64{1.23456, 2.34567})
+	for _, grid := range []float64{0, -1} {
+		if q, err := QuantizeGeometry(g, grid); err != nil || q != g {
+			t.Errorf("grid %v: got %v, %v; want the geometry as is", grid, q, err)
+		}
+	}
+	if q, err := QuantizeGeometry(nil, 1); q != nil || err != nil {
+		t.Errorf("nil: got %v, %v", q, err)
+	}
+}
@@ -3,6 +3,7 @@ package viswal
 
 import (
 	"container/heap"
+	"math"
 )
 
 // heap.Inerface{} methods from container/heap docs, see:
@@ -53,9 +54,14 @@ func (pq *PriorityQueue) Pop() interface{} {
 	return point
 }
 
-// priorityQueueFromPolygon - Generates a New `PriorityQueue` from a list of coordinates
+// priorityQueueFromPolygon - Generates a New `PriorityQueue` from a list
+// of coordinates; empty if there are none (e.g. `POLYGON EMPTY`)
 func priorityQueueFromPolygon(polygon [][][]float64) *PriorityQueue {
 
+	if len(polygon) == 0 {
+		return &PriorityQueue{}
+	}
+
 	// Initialize with same length as input polygon
 	var pq = make(PriorityQueue, len(polygon[0]))
 
@@ -87,11 +93,26 @@ func (pq *PriorityQueue) getPointArea(point *Point) {
 // remaining pops a point, pops from the heap, and assigns a
 // value on [0, 1] for that point. Continues while pq.Len() > 2
 func (pq *PriorityQueue) getQueuePriorityOrder() []float64 {
+	order, _ := pq.getQueueRanking()
+	return order
+}
+
+// getQueueRanking - `getQueuePriorityOrder`, also returning each point's
+// effective area: the triangle area when it was dropped, raised to the
+// largest area dropped before it so the areas are monotonic with the
+// order. The endpoints are never dropped and get +Inf.
+func (pq *PriorityQueue) getQueueRanking() ([]float64, []float64) {
 
 	var countPoints = pq.Len()
 	var priorityOrder = make([]float64, countPoints)
+	var effectiveArea = make([]float64, countPoints)
+	var maxArea float64
 	var point interface{}
 
+	for i := range effectiveArea {
+		effectiveArea[i] = math.Inf(1)
+	}
+
 	// Assign the Area of All Current Points
 	for _, p := range *pq {
 		pq.getPointArea(p)
@@ -104,12 +125,15 @@ func (pq *PriorityQueue) getQueuePriorityOrder() []float64 {
 		point = heap.Pop(pq)
 		priorityOrder[point.(*Point).id] = (float64(droppedCtr) / float64(countPoints))
 
+		maxArea = math.Max(maxArea, point.(*Point).currentArea)
+		effectiveArea[point.(*Point).id] = maxArea
+
 		// Update adjacent triangles
 		pq.update(point.(*Point).leftPoint)
 		pq.update(point.(*Point).rightPoint)
 	}
 
-	return priorityOrder
+	return priorityOrder, effectiveArea
 }
 
 // Update modifies the priority and value of an Point in the queue.
@@ -0,0 +1,63 @@
+package viswal
+
+import (
+	"math"
+	"testing"
+
+	geojson "github.com/paulmach/go.geojson"
+)
+
+// orderCases - `Order` pinned for known shapes; the Lambda writes these
+// values into every shape, so a change here changes every shape object
+var orderCases = []struct {
+	name     string
+	geometry *geojson.Geometry
+	order    [][]float64
+}{
+	{
+		name:     "triangle",
+		geometry: geojson.NewLineStringGeometry([][]float64{{0, 0}, {1, 1}, {2, 0}}),
+		order:    [][]float64{{0, 1, 0}},
+	},
+	{
+		name:     "zigzag",
+		geometry: geojson.NewLineStringGeometry([][]float64{{0, 0}, {1, 0.1}, {2, 0}, {3, 1}, {4, 0}, {5, 0.2}, {6, 0}}),
+		order:    [][]float64{{0, 7.0 / 7, 5.0 / 7, 3.0 / 7, 4.0 / 7, 6.0 / 7, 0}},
+	},
+	{
+		name:     "house",
+		geometry: geojson.NewPolygonGeometry([][][]float64{{{0, 0}, {2, 0}, {2, 1}, {1, 1.2}, {0, 1}, {0, 0}}}),
+		order:    [][]float64{{0, 3.0 / 6, 4.0 / 6, 6.0 / 6, 5.0 / 6, 0}},
+	},
+}
+
+func TestReduceGeometryOrder(t *testing.T) {
+	for _, tc := range orderCases {
+		t.Run(tc.name, func(t *testing.T) {
+			order, err := ReduceGeometry(tc.geometry)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !equalOrder(order, tc.order) {
+				t.Errorf("Order = %v, want %v", order, tc.order)
+			}
+		})
+	}
+}
+
+func equalOrder(a, b [][]float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if len(a[i]) != len(b[i]) {
+			return false
+		}
+		for j := range a[i] {
+			if math.Abs(a[i][j]-b[i][j]) > 1e-12 {
+				return false
+			}
+		}
+	}
+	return true
+}
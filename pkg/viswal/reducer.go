@@ -2,36 +2,46 @@
 package viswal
 
 import (
-	"log"
+	"fmt"
 	"sync"
 
 	geojson "github.com/paulmach/go.geojson"
 )
 
-// Reducer - Reads data from some source,
+// Reducer - Reads data from some source, `Project` (if set) maps each
+// geometry to the CRS it's ranked in, e.g. `crs.CRS.FromWGS84`; it must
+// keep every vertex so `Order` still lines up with the source geometry
 type Reducer struct {
-	Data []*geojson.Feature
+	Data    []*geojson.Feature
+	Project func(*geojson.Geometry) (*geojson.Geometry, error)
 }
 
-// reduceFeaturesAsyncWrapper
-func (r *Reducer) reduceFeaturesAsyncWrapper(index int, wg *sync.WaitGroup) {
-	defer wg.Done()
-	err := r.ReduceFeature(index)
-	if err != nil {
-		log.Fatal(err)
-	}
-}
-
-// ReduceFeature - wraper around geom. reducing method
+// ReduceFeature - wraper around geom. reducing method; a feature without
+// geometry (`"geometry": null`) is left as it is
 func (r *Reducer) ReduceFeature(index int) error {
 
+	var geometry = r.Data[index].Geometry
+	if geometry == nil {
+		return nil
+	}
+	if r.Project != nil {
+		projected, err := r.Project(geometry)
+		if err != nil {
+			return err
+		}
+		geometry = projected
+	}
+
 	// Reduce geometry - route to corret type
-	order, err := ReduceGeometry(r.Data[index].Geometry)
+	order, err := ReduceGeometry(geometry)
 	if err != nil {
 		return err
 	}
 
 	// Set `Order` to the reducer's feature
+	if r.Data[index].Properties == nil {
+		r.Data[index].Properties = make(map[string]interface{})
+	}
 	r.Data[index].Properties["Order"] = order
 	return nil
 }
@@ -46,6 +56,13 @@ There are also Geometry.Type == []*Geometry; Handle uniquely...
 */
 func ReduceGeometry(geom *geojson.Geometry) ([][]float64, error) {
 
+	if geom == nil {
+		return [][]float64{}, nil
+	}
+	if err := checkPositions(geom); err != nil {
+		return nil, err
+	}
+
 	switch polygonType := geom.Type; polygonType {
 
 	case "MultiPolygon": // Send each Polygon of the MultiPolygon...
@@ -91,9 +108,13 @@ func ReduceGeometry(geom *geojson.Geometry) ([][]float64, error) {
 		for i, geom := range geom.Geometries {
 			result, err := ReduceGeometry(geom)
 			if err != nil {
-				// TODO: Warn here...
-				result = [][]float64{}
-				log.Fatal(1)
+				return nil, err
+			}
+
+			// Points (and empty geometries) have no order
+			if len(result) == 0 {
+				geomCollectionOrder[i] = []float64{}
+				continue
 			}
 			geomCollectionOrder[i] = result[0] // TODO: Check this...
 		}
@@ -111,28 +132,82 @@ func ReduceGeometry(geom *geojson.Geometry) ([][]float64, error) {
 // and kick of reducing jobs.
 func BatchReduceGEOJSON(b []byte) (*geojson.FeatureCollection, error) {
 
-	var r Reducer
-	var wg sync.WaitGroup
-
 	// NOTE: BIG Assumption Here -> ioutil.ReadAll puts everything
 	// Into memory, assumes files we read won't be too large.
 	fc1, err := geojson.UnmarshalFeatureCollection(b)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
+	return ReduceFeatureCollection(fc1)
+}
+
+// ReduceFeatureCollection - Reduce every feature of an already decoded
+// collection (e.g. from `formats.ReadSource`) in place, concurrently;
+// returns the first feature's error, if any
+func ReduceFeatureCollection(fc *geojson.FeatureCollection) (*geojson.FeatureCollection, error) {
+
+	var wg sync.WaitGroup
+	var errs = make([]error, len(fc.Features))
+
 	// Initialize Reducer
-	r = Reducer{
-		Data: fc1.Features,
+	r := Reducer{
+		Data: fc.Features,
 	}
 
 	// Calculate polygon priority
 	for idx := range r.Data {
 		wg.Add(1)
-		go r.reduceFeaturesAsyncWrapper(idx, &wg)
+		go func(idx int) {
+			defer wg.Done()
+			errs[idx] = r.ReduceFeature(idx)
+		}(idx)
 	}
 
 	wg.Wait()
 
-	return fc1, nil
+	for idx, err := range errs {
+		if err != nil {
+			return fc, fmt.Errorf("feature %d: %v", idx, err)
+		}
+	}
+	return fc, nil
+}
+
+// checkPositions - Every position has at least X and Y; anything shorter
+// can't be ranked
+func checkPositions(geom *geojson.Geometry) error {
+
+	var check = func(path [][]float64) error {
+		for _, p := range path {
+			if len(p) < 2 {
+				return fmt.Errorf("viswal: %s position %v has fewer than 2 coordinates", geom.Type, p)
+			}
+		}
+		return nil
+	}
+
+	switch geom.Type {
+	case geojson.GeometryLineString:
+		return check(geom.LineString)
+	case geojson.GeometryMultiLineString, geojson.GeometryPolygon:
+		var paths = geom.MultiLineString
+		if geom.Type == geojson.GeometryPolygon {
+			paths = geom.Polygon
+		}
+		for _, path := range paths {
+			if err := check(path); err != nil {
+				return err
+			}
+		}
+	case geojson.GeometryMultiPolygon:
+		for _, polygon := range geom.MultiPolygon {
+			for _, ring := range polygon {
+				if err := check(ring); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
 }
@@ -0,0 +1,63 @@
+package viswal
+
+import (
+	"testing"
+
+	geojson "github.com/paulmach/go.geojson"
+)
+
+func TestReduceGeometryEmpty(t *testing.T) {
+
+	var cases = []struct {
+		name     string
+		geometry *geojson.Geometry
+		order    [][]float64
+	}{
+		{"nil", nil, [][]float64{}},
+		{"empty polygon", geojson.NewPolygonGeometry(nil), [][]float64{{}}},
+		{"empty linestring", geojson.NewLineStringGeometry(nil), [][]float64{{}}},
+		{"empty multipolygon", geojson.NewMultiPolygonGeometry(), [][]float64{}},
+		{"polygon with an empty part", geojson.NewMultiPolygonGeometry(nil, [][][]float64{{{0, 0}, {2, 0}, {2, 1}, {1, 1.2}, {0, 1}, {0, 0}}}), [][]float64{{}, {0, 3.0 / 6, 4.0 / 6, 6.0 / 6, 5.0 / 6, 0}}},
+		{"point", geojson.NewPointGeometry([]float64{1, 2}), [][]float64{}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			order, err := ReduceGeometry(tc.geometry)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !equalOrder(order, tc.order) {
+				t.Errorf("Order = %v, want %v", order, tc.order)
+			}
+		})
+	}
+}
+
+func TestReduceGeometryShortPosition(t *testing.T) {
+	g := geojson.NewLineStringGeometry([][]float64{{0, 0}, {1}, {2, 0}})
+	if _, err := ReduceGeometry(g); err == nil {
+		t.Error("want an error for a position without Y")
+	}
+}
+
+func TestReduceFeatureCollectionSkipsNullGeometry(t *testing.T) {
+
+	fc, err := geojson.UnmarshalFeatureCollection([]byte(`{"type": "FeatureCollection", "features": [
+		{"type": "Feature", "geometry": null, "properties": {"name": "nowhere"}},
+		{"type": "Feature", "geometry": {"type": "LineString", "coordinates": [[0, 0], [1, 1], [2, 0]]}, "properties": {}}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = ReduceFeatureCollection(fc); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fc.Features[0].Properties["Order"]; ok {
+		t.Error("feature without geometry got an Order")
+	}
+	if _, ok := fc.Features[1].Properties["Order"]; !ok {
+		t.Error("LineString has no Order")
+	}
+}
@@ -0,0 +1,191 @@
+// Package viswal -
+package viswal
+
+import (
+	"fmt"
+	"math"
+
+	geojson "github.com/paulmach/go.geojson"
+)
+
+// Threshold - How much of each path to keep when simplifying; exactly
+// one field should be set. Paths never drop below 2 vertices (4 for
+// polygon rings), whatever the threshold.
+//   - Ratio: keep this fraction of each path's vertices, on (0, 1]
+//   - Points: keep this many vertices per path
+//   - Area: keep vertices whose effective area is at least this, in
+//     squared coordinate units; see `ZoomArea`
+type Threshold struct {
+	Ratio  float64
+	Points int
+	Area   float64
+}
+
+// ZoomArea - The effective area threshold that hides detail smaller than
+// `pixels` pixels (across) at web map zoom level `zoom`, for coordinates
+// in degrees. A 256px tile spans 360 / 2^zoom degrees.
+func ZoomArea(zoom float64, pixels float64) float64 {
+	degreesPerPixel := 360 / (256 * math.Pow(2, zoom))
+	return math.Pow(degreesPerPixel*pixels, 2)
+}
+
+// Validate - Exactly one, sensible, threshold must be set
+func (t Threshold) Validate() error {
+	var set int
+	if t.Ratio != 0 {
+		if t.Ratio < 0 || t.Ratio > 1 {
+			return fmt.Errorf("viswal: ratio %v not on (0, 1]", t.Ratio)
+		}
+		set++
+	}
+	if t.Points != 0 {
+		if t.Points < 0 {
+			return fmt.Errorf("viswal: negative point count %v", t.Points)
+		}
+		set++
+	}
+	if t.Area != 0 {
+		if t.Area < 0 {
+			return fmt.Errorf("viswal: negative area %v", t.Area)
+		}
+		set++
+	}
+	if set != 1 {
+		return fmt.Errorf("viswal: set exactly one of ratio, points or area")
+	}
+	return nil
+}
+
+// SimplifyGeometry - Returns a copy of `geom` with each path reduced to
+// the vertices that pass `t`. Points and MultiPoints are copied as is.
+func SimplifyGeometry(geom *geojson.Geometry, t Threshold) (*geojson.Geometry, error) {
+
+	if err := t.Validate(); err != nil {
+		return nil, err
+	}
+	return simplifyGeometry(geom, t)
+}
+
+func simplifyGeometry(geom *geojson.Geometry, t Threshold) (*geojson.Geometry, error) {
+
+	switch geom.Type {
+
+	case geojson.GeometryPoint:
+		return geojson.NewPointGeometry(geom.Point), nil
+
+	case geojson.GeometryMultiPoint:
+		return geojson.NewMultiPointGeometry(geom.MultiPoint...), nil
+
+	case geojson.GeometryLineString:
+		return geojson.NewLineStringGeometry(SimplifyPath(geom.LineString, t, 2)), nil
+
+	case geojson.GeometryMultiLineString:
+		var lines = make([][][]float64, len(geom.MultiLineString))
+		for i, line := range geom.MultiLineString {
+			lines[i] = SimplifyPath(line, t, 2)
+		}
+		return geojson.NewMultiLineStringGeometry(lines...), nil
+
+	case geojson.GeometryPolygon:
+		return geojson.NewPolygonGeometry(simplifyRings(geom.Polygon, t)), nil
+
+	case geojson.GeometryMultiPolygon:
+		var polygons = make([][][][]float64, len(geom.MultiPolygon))
+		for i, polygon := range geom.MultiPolygon {
+			polygons[i] = simplifyRings(polygon, t)
+		}
+		return geojson.NewMultiPolygonGeometry(polygons...), nil
+
+	case geojson.GeometryCollection:
+		var geometries = make([]*geojson.Geometry, len(geom.Geometries))
+		for i, g := range geom.Geometries {
+			simplified, err := simplifyGeometry(g, t)
+			if err != nil {
+				return nil, err
+			}
+			geometries[i] = simplified
+		}
+		return geojson.NewCollectionGeometry(geometries...), nil
+
+	default:
+		return nil, fmt.Errorf("viswal: cannot simplify geometry type %q", geom.Type)
+	}
+}
+
+func simplifyRings(rings [][][]float64, t Threshold) [][][]float64 {
+	var simplified = make([][][]float64, len(rings))
+	for i, ring := range rings {
+		simplified[i] = SimplifyPath(ring, t, 4)
+	}
+	return simplified
+}
+
+// SimplifyPath - Keep the vertices of `path` that pass `t`, but at least
+// `minPoints` of them (the most significant ones)
+func SimplifyPath(path [][]float64, t Threshold, minPoints int) [][]float64 {
+
+	if len(path) <= minPoints {
+		return path
+	}
+
+	order, area := RankPath(path)
+	n := float64(len(path))
+
+	// Order is k/n for the vertex dropped when k remained, so keeping
+	// order <= k/n keeps k vertices; endpoints have order 0
+	var maxOrder = float64(minPoints) / n
+	switch {
+	case t.Ratio > 0:
+		maxOrder = math.Max(maxOrder, t.Ratio)
+	case t.Points > 0:
+		maxOrder = math.Max(maxOrder, float64(t.Points)/n)
+	}
+
+	var simplified [][]float64
+	for i, p := range path {
+		if order[i] <= maxOrder || (t.Area > 0 && area[i] >= t.Area) {
+			simplified = append(simplified, p)
+		}
+	}
+	return simplified
+}
+
+// RankPath - Visvalingam-Whyatt rank of each vertex of `path`: its
+// `Order` (as written by `ReduceFeature`) and effective area
+func RankPath(path [][]float64) ([]float64, []float64) {
+	pq := priorityQueueFromPolygon([][][]float64{path})
+	return pq.getQueueRanking()
+}
+
+// CountVertices - Total vertices in a geometry
+func CountVertices(geom *geojson.Geometry) int {
+
+	var count int
+	switch geom.Type {
+	case geojson.GeometryPoint:
+		count = 1
+	case geojson.GeometryMultiPoint:
+		count = len(geom.MultiPoint)
+	case geojson.GeometryLineString:
+		count = len(geom.LineString)
+	case geojson.GeometryMultiLineString:
+		for _, line := range geom.MultiLineString {
+			count += len(line)
+		}
+	case geojson.GeometryPolygon:
+		for _, ring := range geom.Polygon {
+			count += len(ring)
+		}
+	case geojson.GeometryMultiPolygon:
+		for _, polygon := range geom.MultiPolygon {
+			for _, ring := range polygon {
+				count += len(ring)
+			}
+		}
+	case geojson.GeometryCollection:
+		for _, g := range geom.Geometries {
+			count += CountVertices(g)
+		}
+	}
+	return count
+}
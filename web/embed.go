@@ -0,0 +1,9 @@
+// Package web - Frontend assets, embedded in the web service binary
+package web
+
+import "embed"
+
+// Templates - `templates/*.html`, parsed by cmd/web at startup
+//
+//go:embed templates/*.html
+var Templates embed.FS